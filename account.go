@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const accountDeletionsCollName = "account_deletions"
+
+const (
+	deletionPending = "pending"
+	deletionDone    = "done"
+	deletionFailed  = "failed"
+
+	// deletionRecordTTL is how long the record of a finished deletion is
+	// kept, so its outcome can still be looked up.
+	deletionRecordTTL = 30 * 24 * time.Hour
+
+	// deletedActor replaces an erased user in the history of todos that
+	// weren't theirs.
+	deletedActor = "deleted user"
+)
+
+type (
+	// accountDeletionModel tracks the erasure of a user's data, which runs
+	// in the background.
+	accountDeletionModel struct {
+		ID          primitive.ObjectID `bson:"_id"`
+		UserID      string             `bson:"user_id"`
+		WorkspaceID string             `bson:"workspace_id,omitempty"`
+		Status      string             `bson:"status"`
+		Error       string             `bson:"error,omitempty"`
+		RequestedAt time.Time          `bson:"requested_at"`
+		CompletedAt *time.Time         `bson:"completed_at,omitempty"`
+	}
+
+	accountDeletion struct {
+		ID          string `json:"id"`
+		Status      string `json:"status"`
+		RequestedAt string `json:"requested_at"`
+	}
+
+	// accountExport is account.json in an export archive.
+	accountExport struct {
+		UserID      string          `json:"user_id"`
+		WorkspaceID string          `json:"workspace_id,omitempty"`
+		ExportedAt  string          `json:"exported_at"`
+		Profile     *accountProfile `json:"profile,omitempty"`
+		Preferences preferences     `json:"preferences"`
+	}
+
+	// accountProfile is what is stored about users who signed in through
+	// OAuth.
+	accountProfile struct {
+		Name        string   `json:"name"`
+		Email       string   `json:"email,omitempty"`
+		Providers   []string `json:"providers"`
+		CreatedAt   string   `json:"created_at"`
+		LastLoginAt string   `json:"last_login_at"`
+	}
+)
+
+func (s *todoService) ensureAccountIndexes(ctx context.Context) error {
+	_, err := s.deletions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "completed_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(deletionRecordTTL.Seconds())),
+		},
+	})
+	return err
+}
+
+// ownTodos returns the todos c owns, deleted ones aside.
+func (s *todoService) ownTodos(ctx context.Context, c caller) ([]todoModel, error) {
+	cursor, err := s.todos.Find(ctx, c.scoped(bson.M{"owner_id": c.userID}), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var todos []todoModel
+	err = cursor.All(ctx, &todos)
+	return todos, err
+}
+
+// export writes everything stored about c to w as a zip archive of JSON
+// files, with the attachments of c's todos under attachments/.
+func (s *todoService) export(ctx context.Context, c caller, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	add := func(name string, v interface{}) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	account := accountExport{
+		UserID:      c.userID,
+		WorkspaceID: c.workspace,
+		ExportedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if id, err := primitive.ObjectIDFromHex(c.userID); err == nil {
+		var u userModel
+		err := s.users.FindOne(ctx, bson.M{"_id": id}).Decode(&u)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		if err == nil {
+			account.Profile = &accountProfile{
+				Name:        u.Name,
+				Email:       u.Email,
+				Providers:   []string{},
+				CreatedAt:   u.CreatedAt.UTC().Format(time.RFC3339),
+				LastLoginAt: u.LastLoginAt.UTC().Format(time.RFC3339),
+			}
+			for _, identity := range u.Identities {
+				account.Profile.Providers = append(account.Profile.Providers, identity.Provider)
+			}
+			account.Preferences = u.Preferences
+		}
+	}
+	if err := add("account.json", account); err != nil {
+		return err
+	}
+
+	owned, err := s.ownTodos(ctx, c)
+	if err != nil {
+		return err
+	}
+	todos := make([]todo, 0, len(owned))
+	ids := make(bson.A, 0, len(owned))
+	for _, t := range owned {
+		todos = append(todos, newTodo(t, time.UTC))
+		ids = append(ids, t.ID)
+	}
+	if err := add("todos.json", todos); err != nil {
+		return err
+	}
+
+	cursor, err := s.lists.Find(ctx, c.scoped(memberFilter(c.userID)), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var lists []listModel
+	if err := cursor.All(ctx, &lists); err != nil {
+		return err
+	}
+	shared := make([]sharedList, 0, len(lists))
+	for _, l := range lists {
+		shared = append(shared, newSharedList(l, c.userID))
+	}
+	if err := add("lists.json", shared); err != nil {
+		return err
+	}
+
+	// The history of c's todos, and what c did to other todos.
+	cursor, err = s.history.Find(ctx,
+		bson.M{"$or": bson.A{bson.M{"todo_id": bson.M{"$in": ids}}, bson.M{"actor": c.userID}}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	var history []historyModel
+	if err := cursor.All(ctx, &history); err != nil {
+		return err
+	}
+	entries := make([]historyEntry, 0, len(history))
+	for _, e := range history {
+		entries = append(entries, newHistoryEntry(e))
+	}
+	if err := add("history.json", entries); err != nil {
+		return err
+	}
+
+	cursor, err = s.apiKeys.Find(ctx, c.scoped(bson.M{"user_id": c.userID}))
+	if err != nil {
+		return err
+	}
+	var keys []apiKeyModel
+	if err := cursor.All(ctx, &keys); err != nil {
+		return err
+	}
+	apiKeys := make([]apiKey, 0, len(keys))
+	for _, k := range keys {
+		apiKeys = append(apiKeys, newAPIKey(k))
+	}
+	if err := add("api_keys.json", apiKeys); err != nil {
+		return err
+	}
+
+	cursor, err = s.attachments.Find(ctx, bson.M{"todo_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var stored []attachmentModel
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+	attachments := make([]attachment, 0, len(stored))
+	for _, a := range stored {
+		attachments = append(attachments, newAttachment(a))
+	}
+	if err := add("attachments.json", attachments); err != nil {
+		return err
+	}
+	for _, a := range stored {
+		body, err := s.blobs.get(ctx, a.ID.Hex())
+		if errors.Is(err, errBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f, err := zw.Create("attachments/" + a.ID.Hex() + "/" + path.Base("/"+a.Filename))
+		if err == nil {
+			_, err = io.Copy(f, body)
+		}
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// requestDeletion records that c's account is to be erased, or returns the
+// request already pending.
+func (s *todoService) requestDeletion(ctx context.Context, c caller) (accountDeletionModel, error) {
+	var d accountDeletionModel
+	err := s.deletions.FindOneAndUpdate(ctx,
+		c.scoped(bson.M{"user_id": c.userID, "status": deletionPending}),
+		bson.M{"$setOnInsert": accountDeletionModel{
+			ID:          primitive.NewObjectID(),
+			UserID:      c.userID,
+			WorkspaceID: c.workspace,
+			Status:      deletionPending,
+			RequestedAt: time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&d)
+	return d, err
+}
+
+// resumeDeletions finishes the deletions that were pending when the server
+// last stopped.
+func (s *todoService) resumeDeletions(ctx context.Context) {
+	cursor, err := s.deletions.Find(ctx, bson.M{"status": deletionPending})
+	if err != nil {
+		log.Printf("Failed to look up pending account deletions: %v", err)
+		return
+	}
+	var pending []accountDeletionModel
+	if err := cursor.All(ctx, &pending); err != nil {
+		log.Printf("Failed to look up pending account deletions: %v", err)
+		return
+	}
+	for _, d := range pending {
+		s.runDeletion(ctx, d)
+	}
+}
+
+// runDeletion erases the account and records the outcome, telling the user
+// once it is done.
+func (s *todoService) runDeletion(ctx context.Context, d accountDeletionModel) {
+	c := caller{userID: d.UserID, workspace: d.WorkspaceID, addr: "account deletion"}
+	err := s.eraseAccount(ctx, c)
+
+	now := time.Now()
+	set := bson.M{"status": deletionDone, "completed_at": now}
+	if err != nil {
+		log.Printf("Account deletion %s failed: %v", d.ID.Hex(), err)
+		set = bson.M{"status": deletionFailed, "error": err.Error(), "completed_at": now}
+	} else {
+		log.Printf("Account deletion %s done", d.ID.Hex())
+	}
+	if _, err := s.deletions.UpdateByID(context.WithoutCancel(ctx), d.ID, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to record account deletion %s: %v", d.ID.Hex(), err)
+	}
+	if err == nil {
+		s.events.publish(todoEvent{Type: eventAccountDeleted, OwnerID: d.UserID, WorkspaceID: d.WorkspaceID, At: now})
+	}
+}
+
+// eraseAccount deletes c's todos with their history and attachments, c's
+// lists, API keys, sessions, Telegram links and user record, and takes c
+// out of other users' lists. Todos others added to c's lists stay theirs,
+// outside any list. c's name is taken out of other todos' history. Each
+// step can be run again, so a deletion cut short can be resumed.
+func (s *todoService) eraseAccount(ctx context.Context, c caller) error {
+	owned, err := s.ownTodos(ctx, c)
+	if err != nil {
+		return err
+	}
+	for _, t := range owned {
+		if _, err := s.todos.DeleteOne(ctx, bson.M{"_id": t.ID}); err != nil {
+			return err
+		}
+		s.changed(ctx, eventTodoDeleted, t)
+		// Other members' sync clients need to hear of deletions in lists.
+		if t.ListID != nil {
+			if err := s.bury(ctx, t); err != nil {
+				return err
+			}
+		}
+		if _, err := s.purgeTodo(ctx, t.ID); err != nil {
+			return err
+		}
+	}
+
+	// Deleted todos of c's that haven't been purged yet.
+	deletedFilter := bson.M{"action": actionDelete, "previous.owner_id": c.userID, "previous.workspace_id": nil}
+	if c.workspace != "" {
+		deletedFilter["previous.workspace_id"] = c.workspace
+	}
+	deleted, err := s.history.Distinct(ctx, "todo_id", deletedFilter)
+	if err != nil {
+		return err
+	}
+	for _, v := range deleted {
+		if id, ok := v.(primitive.ObjectID); ok {
+			if _, err := s.purgeTodo(ctx, id); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := s.tombstones.DeleteMany(ctx, c.scoped(bson.M{"owner_id": c.userID, "list_id": nil})); err != nil {
+		return err
+	}
+
+	cursor, err := s.lists.Find(ctx, c.scoped(memberFilter(c.userID)))
+	if err != nil {
+		return err
+	}
+	var lists []listModel
+	if err := cursor.All(ctx, &lists); err != nil {
+		return err
+	}
+	for _, l := range lists {
+		if l.OwnerID == c.userID {
+			_, err = s.todos.UpdateMany(ctx, bson.M{"list_id": l.ID}, bson.M{
+				"$unset": bson.M{"list_id": "", "assignee_id": ""},
+				"$set":   bson.M{"updated_at": time.Now()},
+			})
+			if err == nil {
+				_, err = s.lists.DeleteOne(ctx, bson.M{"_id": l.ID})
+			}
+		} else {
+			_, err = s.lists.UpdateByID(ctx, l.ID, bson.M{
+				"$pull": bson.M{"members": bson.M{"user_id": c.userID}},
+				"$set":  bson.M{"updated_at": time.Now()},
+			})
+			if err == nil {
+				err = s.unassignMember(ctx, l.ID, c.userID)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		s.membersChanged(ctx, l.ID)
+	}
+
+	if _, err := s.history.UpdateMany(ctx, bson.M{"actor": c.userID}, bson.M{"$set": bson.M{"actor": deletedActor}}); err != nil {
+		return err
+	}
+	if _, err := s.apiKeys.DeleteMany(ctx, c.scoped(bson.M{"user_id": c.userID})); err != nil {
+		return err
+	}
+	if err := sessions.deleteUser(ctx, c.userID); err != nil {
+		return err
+	}
+	if telegram != nil {
+		if err := telegram.forget(ctx, c, "Your account and todos have been deleted. This chat is no longer linked."); err != nil {
+			return err
+		}
+	}
+	if id, err := primitive.ObjectIDFromHex(c.userID); err == nil {
+		if _, err := s.users.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newAccountDeletion(d accountDeletionModel) accountDeletion {
+	return accountDeletion{
+		ID:          d.ID.Hex(),
+		Status:      d.Status,
+		RequestedAt: d.RequestedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// exportAccount answers GET /me/export with a zip archive of the caller's
+// data.
+func exportAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c := requestCaller(r)
+
+	name := fmt.Sprintf("todo-go-export-%s.zip", time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := svc.export(ctx, c, w); err != nil {
+		// A zip without its central directory won't open, so a failure
+		// after the archive started still shows.
+		log.Printf("Account export failed: %v", err)
+	}
+}
+
+// deleteAccount answers DELETE /me by signing the caller out and starting
+// to erase their account in the background. An account.deleted event
+// follows once it is done.
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c := requestCaller(r)
+
+	d, err := svc.requestDeletion(ctx, c)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to delete account",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err := signOut(ctx, w, r); err != nil {
+		log.Printf("Sign-out before account deletion failed: %v", err)
+	}
+	go svc.runDeletion(context.Background(), d)
+
+	rnd.JSON(w, http.StatusAccepted, itemResponse[accountDeletion]{
+		Message: "Account deletion started",
+		Data:    newAccountDeletion(d),
+	})
+}
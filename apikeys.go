@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	apiKeysCollName = "api_keys"
+	apiKeyPrefix    = "tdk_"
+)
+
+var errAPIKeyNotFound = errors.New("api key not found")
+
+// apiKeyScope limits what an API key may do: read keys may only make safe
+// (GET, HEAD, OPTIONS) requests, write keys may do anything their user can.
+type apiKeyScope string
+
+const (
+	scopeRead  apiKeyScope = "read"
+	scopeWrite apiKeyScope = "write"
+)
+
+type (
+	apiKeyModel struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		UserID      string             `bson:"user_id"`
+		WorkspaceID string             `bson:"workspace_id,omitempty"`
+		Name        string             `bson:"name"`
+		Prefix      string             `bson:"prefix"`
+		Hash        string             `bson:"hash"`
+		Scope       apiKeyScope        `bson:"scope"`
+		CreatedAt   time.Time          `bson:"created_at"`
+		LastUsedAt  *time.Time         `bson:"last_used_at,omitempty"`
+		RevokedAt   *time.Time         `bson:"revoked_at,omitempty"`
+	}
+
+	apiKey struct {
+		ID         string      `json:"id"`
+		Name       string      `json:"name"`
+		Prefix     string      `json:"prefix"`
+		Scope      apiKeyScope `json:"scope"`
+		Key        string      `json:"key,omitempty"`
+		CreatedAt  string      `json:"created_at"`
+		LastUsedAt string      `json:"last_used_at,omitempty"`
+		RevokedAt  string      `json:"revoked_at,omitempty"`
+	}
+)
+
+func newAPIKey(k apiKeyModel) apiKey {
+	key := apiKey{
+		ID:        k.ID.Hex(),
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scope:     k.Scope,
+		CreatedAt: k.CreatedAt.Format(time.RFC3339),
+	}
+	if k.LastUsedAt != nil {
+		key.LastUsedAt = k.LastUsedAt.Format(time.RFC3339)
+	}
+	if k.RevokedAt != nil {
+		key.RevokedAt = k.RevokedAt.Format(time.RFC3339)
+	}
+	return key
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIKey generates a new key for c. The plain key is only returned
+// here; just its hash is stored.
+func (s *todoService) createAPIKey(ctx context.Context, c caller, name string, scope apiKeyScope) (apiKeyModel, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return apiKeyModel{}, "", err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	k := apiKeyModel{
+		ID:          primitive.NewObjectID(),
+		UserID:      c.userID,
+		WorkspaceID: c.workspace,
+		Name:        name,
+		Prefix:      key[:len(apiKeyPrefix)+6],
+		Hash:        hashAPIKey(key),
+		Scope:       scope,
+		CreatedAt:   time.Now(),
+	}
+	if _, err := s.apiKeys.InsertOne(ctx, k); err != nil {
+		return apiKeyModel{}, "", err
+	}
+	return k, key, nil
+}
+
+func (s *todoService) listAPIKeys(ctx context.Context, c caller) ([]apiKeyModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := s.apiKeys.Find(ctx, c.scoped(bson.M{"user_id": c.userID}), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []apiKeyModel
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *todoService) revokeAPIKey(ctx context.Context, c caller, id primitive.ObjectID) error {
+	res, err := s.apiKeys.UpdateOne(ctx,
+		c.scoped(bson.M{"_id": id, "user_id": c.userID, "revoked_at": nil}),
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+// authenticateAPIKey looks up an unrevoked key and records its use.
+func (s *todoService) authenticateAPIKey(ctx context.Context, key string) (apiKeyModel, error) {
+	var k apiKeyModel
+	err := s.apiKeys.FindOneAndUpdate(ctx,
+		bson.M{"hash": hashAPIKey(key), "revoked_at": nil},
+		bson.M{"$set": bson.M{"last_used_at": time.Now()}},
+	).Decode(&k)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return k, errAPIKeyNotFound
+	}
+	return k, err
+}
+
+// apiKeyAuth authenticates requests carrying "Authorization: Bearer tdk_..."
+// as the key's user. Invalid or revoked keys get 401 and read-only keys get
+// 403 on anything but safe methods. Requests without an API key pass through
+// unchanged.
+func apiKeyAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(token, apiKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		k, err := svc.authenticateAPIKey(ctx, strings.TrimSpace(token))
+		if errors.Is(err, errAPIKeyNotFound) {
+			rnd.JSON(w, http.StatusUnauthorized, errorResponse{
+				Message: "Invalid API key",
+			})
+			return
+		}
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+				Message: "Failed to check API key",
+				Error:   err.Error(),
+			})
+			return
+		}
+
+		if k.Scope != scopeWrite {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				rnd.JSON(w, http.StatusForbidden, errorResponse{
+					Message: "This API key is read-only",
+				})
+				return
+			}
+		}
+
+		// The key's workspace is the one it was created in, whatever the
+		// proxy's workspace header says.
+		rctx := context.WithValue(r.Context(), userContextKey, k.UserID)
+		rctx = context.WithValue(rctx, workspaceContextKey, k.WorkspaceID)
+		next.ServeHTTP(w, r.WithContext(rctx))
+	})
+}
+
+func fetchAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys, err := svc.listAPIKeys(ctx, requestCaller(r))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch API keys",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	data := []apiKey{}
+	for _, k := range keys {
+		data = append(data, newAPIKey(k))
+	}
+
+	respond(w, r, http.StatusOK, listResponse[apiKey]{
+		Data: data,
+	})
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name  string      `json:"name"`
+		Scope apiKeyScope `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create API key",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create API key",
+			Error:   "Name is required",
+		})
+		return
+	}
+	if body.Scope == "" {
+		body.Scope = scopeRead
+	}
+	if body.Scope != scopeRead && body.Scope != scopeWrite {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create API key",
+			Error:   "Scope must be read or write",
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	k, key, err := svc.createAPIKey(ctx, requestCaller(r), body.Name, body.Scope)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to create API key",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	data := newAPIKey(k)
+	data.Key = key
+	rnd.JSON(w, http.StatusCreated, itemResponse[apiKey]{
+		Message: "API key created successfully. Store the key now, it won't be shown again",
+		Data:    data,
+	})
+}
+
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	err = svc.revokeAPIKey(ctx, requestCaller(r), id)
+	if errors.Is(err, errAPIKeyNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "API key not found",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to revoke API key",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "API key revoked successfully",
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//go:embed static
+var embeddedAssets embed.FS
+
+// assetsDir serves the frontend from a directory on disk instead of the copy
+// embedded in the binary, so edits show up on a browser reload during
+// development.
+var assetsDir = envString("ASSETS_DIR", "")
+
+// assets holds the frontend, set up by setup.
+var assets fs.FS
+
+func assetFS() (fs.FS, error) {
+	if assetsDir != "" {
+		if _, err := os.Stat(filepath.Join(assetsDir, "index.tpl")); err != nil {
+			return nil, fmt.Errorf("ASSETS_DIR doesn't hold the frontend: %w", err)
+		}
+		log.Printf("Serving frontend assets from %s", assetsDir)
+		return os.DirFS(assetsDir), nil
+	}
+	return fs.Sub(embeddedAssets, "static")
+}
+
+var (
+	viewsOnce sync.Once
+	viewsTpl  *template.Template
+	viewsErr  error
+)
+
+// viewTemplates parses the page and partial templates once, or on every
+// request when the assets come from disk.
+func viewTemplates() (*template.Template, error) {
+	if assetsDir != "" {
+		return template.ParseFS(assets, "*.tpl")
+	}
+	viewsOnce.Do(func() {
+		viewsTpl, viewsErr = template.ParseFS(assets, "*.tpl")
+	})
+	return viewsTpl, viewsErr
+}
+
+var (
+	etagsOnce sync.Once
+	etags     map[string]string
+)
+
+// assetETag returns a content hash for an embedded asset. Embedded files
+// have no modification time, so this is what lets browsers revalidate them.
+func assetETag(name string) string {
+	etagsOnce.Do(func() {
+		etags = map[string]string{}
+		fs.WalkDir(assets, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := fs.ReadFile(assets, p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			etags[p] = `"` + hex.EncodeToString(sum[:8]) + `"`
+			return nil
+		})
+	})
+	return etags[name]
+}
+
+// staticHandler serves the frontend assets under /static/. Embedded assets
+// may be cached for an hour and are revalidated by ETag; assets read from
+// disk are never cached.
+func staticHandler() http.Handler {
+	files := http.StripPrefix("/static/", http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/static/")
+		if path.Ext(name) == ".tpl" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if assetsDir != "" {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			if etag := assetETag(name); etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errNotShared   = errors.New("only todos on a shared list can be assigned")
+	errNotAssignee = errors.New("the assignee must be a member of the todo's list")
+)
+
+// assign sets or, with an empty assigneeID, clears who a todo on a shared
+// list is assigned to. The assignee must be the list's owner or a member.
+// Assigning someone tells them with a todo.assigned event.
+func (s *todoService) assign(ctx context.Context, c caller, id primitive.ObjectID, assigneeID string) (todoModel, error) {
+	current, err := s.getForAccess(ctx, c, id, true)
+	if err != nil || current.AssigneeID == assigneeID {
+		return current, err
+	}
+	if current.ListID == nil {
+		return current, errNotShared
+	}
+	if assigneeID != "" {
+		l, err := s.getList(ctx, c, *current.ListID)
+		if err != nil {
+			return current, err
+		}
+		if l.roleOf(assigneeID) == "" {
+			return current, errNotAssignee
+		}
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"updated_at": now}}
+	if assigneeID != "" {
+		update["$set"].(bson.M)["assignee_id"] = assigneeID
+	} else {
+		update["$unset"] = bson.M{"assignee_id": ""}
+	}
+
+	var before todoModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = s.todos.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return current, errTodoNotFound
+	}
+	if err != nil {
+		return current, err
+	}
+
+	after := before
+	after.AssigneeID = assigneeID
+	after.UpdatedAt = now
+	s.changed(ctx, eventTodoUpdated, after)
+	if assigneeID != "" {
+		s.events.publish(newTodoEvent(eventTodoAssigned, after))
+	}
+	return after, s.recordHistory(ctx, id, actionUpdate, c.actor(), &before, diffTodos(&before, &after))
+}
+
+// unassignMember clears the assignments of a user who left a list.
+func (s *todoService) unassignMember(ctx context.Context, listID primitive.ObjectID, userID string) error {
+	filter := bson.M{"list_id": listID, "assignee_id": userID}
+	cursor, err := s.todos.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var assigned []todoModel
+	if err := cursor.All(ctx, &assigned); err != nil {
+		return err
+	}
+	if len(assigned) == 0 {
+		return nil
+	}
+
+	_, err = s.todos.UpdateMany(ctx, filter, bson.M{
+		"$unset": bson.M{"assignee_id": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		for _, t := range assigned {
+			s.cache.invalidateTodo(context.WithoutCancel(ctx), t.ID)
+		}
+	}
+	return nil
+}
+
+// patchTodo answers PATCH /todo/{id}, which changes the fields it is sent.
+// So far that is only assignee_id, a user ID or null to unassign; the
+// other fields are changed with PUT.
+func patchTodo(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update todo",
+			Error:   err.Error(),
+		})
+		return
+	}
+	raw, ok := patch["assignee_id"]
+	if !ok || len(patch) > 1 {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update todo",
+			Error:   "Only assignee_id can be patched; change the other fields with PUT",
+		})
+		return
+	}
+	var assigneeID *string
+	if err := json.Unmarshal(raw, &assigneeID); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update todo",
+			Error:   "assignee_id must be a user ID or null",
+		})
+		return
+	}
+	assignee := ""
+	if assigneeID != nil {
+		assignee = strings.TrimSpace(*assigneeID)
+	}
+
+	ctx := r.Context()
+	c := requestCaller(r)
+
+	tm, err := svc.assign(ctx, c, objID, assignee)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to update todo",
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	}
+	if errors.Is(err, errNotShared) || errors.Is(err, errNotAssignee) {
+		rnd.JSON(w, http.StatusUnprocessableEntity, fieldErrorResponse{
+			errorResponse: errorResponse{Message: "Failed to update todo", Error: err.Error()},
+			Field:         "assignee_id",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to update todo",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, todoResponse{
+		Message: "Todo updated successfully",
+		Data:    newTodo(tm, c.location()),
+	})
+}
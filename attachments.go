@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const attachmentsCollName = "attachments"
+
+var (
+	errAttachmentNotFound = errors.New("attachment not found")
+	errAttachmentTooLarge = errors.New("attachment is too large")
+	errAttachmentType     = errors.New("attachment type is not allowed")
+)
+
+// Attachment limits, configurable through MAX_ATTACHMENT_SIZE (bytes) and
+// ATTACHMENT_TYPES (comma separated media types).
+var (
+	maxAttachmentSize      = envInt64("MAX_ATTACHMENT_SIZE", 10<<20)
+	allowedAttachmentTypes = envList("ATTACHMENT_TYPES", []string{
+		"image/png",
+		"image/jpeg",
+		"image/gif",
+		"image/webp",
+		"application/pdf",
+		"text/plain",
+	})
+)
+
+type (
+	attachmentModel struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		TodoID      primitive.ObjectID `bson:"todo_id"`
+		OwnerID     string             `bson:"owner_id"` // the todo's owner, whose storage quota it counts against
+		WorkspaceID string             `bson:"workspace_id,omitempty"`
+		Filename    string             `bson:"filename"`
+		ContentType string             `bson:"content_type"`
+		Size        int64              `bson:"size"`
+		Storage     string             `bson:"storage"`
+		CreatedAt   time.Time          `bson:"created_at"`
+	}
+
+	attachment struct {
+		ID          string `json:"id"`
+		TodoID      string `json:"todo_id"`
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		CreatedAt   string `json:"created_at"`
+	}
+)
+
+func newAttachment(a attachmentModel) attachment {
+	return attachment{
+		ID:          a.ID.Hex(),
+		TodoID:      a.TodoID.Hex(),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		CreatedAt:   a.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// attachmentType sniffs the media type of an upload from its first bytes,
+// ignoring whatever the client claimed, and checks it against the allowed
+// types.
+func attachmentType(data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "", errAttachmentType
+	}
+	for _, allowed := range allowedAttachmentTypes {
+		if strings.EqualFold(allowed, mediaType) {
+			return mediaType, nil
+		}
+	}
+	return "", errAttachmentType
+}
+
+func (s *todoService) addAttachment(ctx context.Context, c caller, todoID primitive.ObjectID, filename string, data []byte) (attachmentModel, error) {
+	if int64(len(data)) > maxAttachmentSize {
+		return attachmentModel{}, errAttachmentTooLarge
+	}
+	contentType, err := attachmentType(data)
+	if err != nil {
+		return attachmentModel{}, err
+	}
+	t, err := s.getForAccess(ctx, c, todoID, true)
+	if err != nil {
+		return attachmentModel{}, err
+	}
+	if err := s.checkStorageQuota(ctx, t.owner(), int64(len(data))); err != nil {
+		return attachmentModel{}, err
+	}
+
+	a := attachmentModel{
+		ID:          primitive.NewObjectID(),
+		TodoID:      todoID,
+		OwnerID:     t.OwnerID,
+		WorkspaceID: t.WorkspaceID,
+		Filename:    filepath.Base(filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Storage:     s.blobs.name(),
+		CreatedAt:   time.Now(),
+	}
+	if err := s.blobs.put(ctx, a.ID.Hex(), a.ContentType, bytes.NewReader(data), a.Size); err != nil {
+		return attachmentModel{}, err
+	}
+	if _, err := s.attachments.InsertOne(ctx, a); err != nil {
+		// Don't leave an orphaned blob behind.
+		s.blobs.delete(ctx, a.ID.Hex())
+		return attachmentModel{}, err
+	}
+	return a, nil
+}
+
+func (s *todoService) listAttachments(ctx context.Context, c caller, todoID primitive.ObjectID) ([]attachmentModel, error) {
+	if _, err := s.get(ctx, c, todoID); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := s.attachments.Find(ctx, bson.M{"todo_id": todoID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []attachmentModel
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *todoService) getAttachment(ctx context.Context, c caller, todoID, id primitive.ObjectID) (attachmentModel, error) {
+	var a attachmentModel
+	if _, err := s.get(ctx, c, todoID); err != nil {
+		return a, err
+	}
+
+	err := s.attachments.FindOne(ctx, bson.M{"_id": id, "todo_id": todoID}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return a, errAttachmentNotFound
+	}
+	return a, err
+}
+
+func (s *todoService) openAttachment(ctx context.Context, a attachmentModel) (io.ReadCloser, error) {
+	body, err := s.blobs.get(ctx, a.ID.Hex())
+	if errors.Is(err, errBlobNotFound) {
+		return nil, errAttachmentNotFound
+	}
+	return body, err
+}
+
+func (s *todoService) deleteAttachment(ctx context.Context, c caller, todoID, id primitive.ObjectID) error {
+	if _, err := s.getForAccess(ctx, c, todoID, true); err != nil {
+		return err
+	}
+
+	var a attachmentModel
+	err := s.attachments.FindOneAndDelete(ctx, bson.M{"_id": id, "todo_id": todoID}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errAttachmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.blobs.delete(ctx, a.ID.Hex()); err != nil && !errors.Is(err, errBlobNotFound) {
+		return err
+	}
+	return nil
+}
+
+// attachmentIDs parses the todo and attachment ids from the URL, writing a
+// 400 response and returning false if either is malformed.
+func attachmentIDs(w http.ResponseWriter, r *http.Request) (todoID, id primitive.ObjectID, ok bool) {
+	todoID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return todoID, id, false
+	}
+	if param := chi.URLParam(r, "attachmentID"); param != "" {
+		id, err = primitive.ObjectIDFromHex(strings.TrimSpace(param))
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, errorResponse{
+				Message: "Invalid attachment id",
+			})
+			return todoID, id, false
+		}
+	}
+	return todoID, id, true
+}
+
+func uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	todoID, _, ok := attachmentIDs(w, r)
+	if !ok {
+		return
+	}
+
+	// Leave some room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			rnd.JSON(w, http.StatusRequestEntityTooLarge, errorResponse{
+				Message: "Failed to upload attachment",
+				Error:   fmt.Sprintf("Attachments must be at most %d bytes", maxAttachmentSize),
+			})
+			return
+		}
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to upload attachment",
+			Error:   "A multipart \"file\" field is required",
+		})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to upload attachment",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	a, err := svc.addAttachment(ctx, requestCaller(r), todoID, header.Filename, data)
+	if quotaExceeded(w, "Failed to upload attachment", err) {
+		return
+	}
+	switch {
+	case errors.Is(err, errForbidden):
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to upload attachment",
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	case errors.Is(err, errAttachmentTooLarge):
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, errorResponse{
+			Message: "Failed to upload attachment",
+			Error:   fmt.Sprintf("Attachments must be at most %d bytes", maxAttachmentSize),
+		})
+		return
+	case errors.Is(err, errAttachmentType):
+		rnd.JSON(w, http.StatusUnsupportedMediaType, errorResponse{
+			Message: "Failed to upload attachment",
+			Error:   "Allowed types are " + strings.Join(allowedAttachmentTypes, ", "),
+		})
+		return
+	case errors.Is(err, errTodoNotFound):
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	case err != nil:
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to upload attachment",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, itemResponse[attachment]{
+		Message: "Attachment uploaded successfully",
+		Data:    newAttachment(a),
+	})
+}
+
+func fetchAttachments(w http.ResponseWriter, r *http.Request) {
+	todoID, _, ok := attachmentIDs(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	list, err := svc.listAttachments(ctx, requestCaller(r), todoID)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch attachments",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	attachments := []attachment{}
+	for _, a := range list {
+		attachments = append(attachments, newAttachment(a))
+	}
+
+	respond(w, r, http.StatusOK, listResponse[attachment]{
+		Data: attachments,
+	})
+}
+
+func downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	todoID, id, ok := attachmentIDs(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	a, err := svc.getAttachment(ctx, requestCaller(r), todoID, id)
+	var body io.ReadCloser
+	if err == nil {
+		body, err = svc.openAttachment(ctx, a)
+	}
+	if errors.Is(err, errAttachmentNotFound) || errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Attachment not found",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to download attachment",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
+
+func deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	todoID, id, ok := attachmentIDs(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	err := svc.deleteAttachment(ctx, requestCaller(r), todoID, id)
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to delete attachment",
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	}
+	if errors.Is(err, errAttachmentNotFound) || errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Attachment not found",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to delete attachment",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "Attachment deleted successfully",
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+	workspaceContextKey
+	timezoneContextKey
+	localeContextKey
+)
+
+// authUserHeader names the header an authenticating reverse proxy in front
+// of the app (oauth2-proxy, Pomerium, ...) uses to pass the signed-in user.
+// It is off ("-") unless AUTH_USER_HEADER is set, and even then only read
+// from requests coming from trustedProxies.
+var authUserHeader = envString("AUTH_USER_HEADER", "-")
+
+// trustedProxies are the networks, in CIDR notation, the user header is
+// accepted from. Without any, the header is ignored.
+var trustedProxies = parseCIDRs("TRUSTED_PROXIES", envList("TRUSTED_PROXIES", nil))
+
+func parseCIDRs(key string, list []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range list {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			invalidSetting(fmt.Sprintf("%s entry %q", key, s), err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// fromTrustedProxy reports whether r was sent by one of trustedProxies.
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceHeader names the header the authenticating proxy passes the
+// user's workspace in, alongside authUserHeader. It is off ("-") unless
+// WORKSPACE_HEADER is set, and only read from requests the user header is
+// taken from. Sessions and API keys carry the workspace of their user or
+// key, and anonymous requests are in the default workspace.
+var workspaceHeader = envString("WORKSPACE_HEADER", "-")
+
+// caller is who a service call is made on behalf of.
+type caller struct {
+	userID    string         // empty for anonymous requests
+	workspace string         // empty for the default workspace
+	tz        *time.Location // nil for UTC
+	addr      string
+}
+
+// scoped restricts filter to documents in c's workspace. Documents of the
+// default workspace have no workspace_id at all.
+func (c caller) scoped(filter bson.M) bson.M {
+	if c.workspace == "" {
+		filter["workspace_id"] = nil
+	} else {
+		filter["workspace_id"] = c.workspace
+	}
+	return filter
+}
+
+// actor is how the caller is recorded in the audit log: the user when one is
+// known, the client address otherwise.
+func (c caller) actor() string {
+	if c.userID != "" {
+		return c.userID
+	}
+	return c.addr
+}
+
+func requestCaller(r *http.Request) caller {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	userID, _ := r.Context().Value(userContextKey).(string)
+	workspace, _ := r.Context().Value(workspaceContextKey).(string)
+	tz, _ := r.Context().Value(timezoneContextKey).(*time.Location)
+	return caller{userID: userID, workspace: workspace, tz: tz, addr: addr}
+}
+
+// identify stores the signed-in user, if any, and their workspace in the
+// request context. The user and workspace are taken from the session cookie
+// or, failing that, from the authenticating proxy's headers when the
+// request came from a trusted proxy. Unsafe requests authenticated by the
+// cookie must carry the session's CSRF token.
+func identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var userID, workspace string
+		if s, ok := requestSession(r); ok {
+			if !safeMethod(r.Method) && !validCSRF(r, s) {
+				csrfFailed(w)
+				return
+			}
+			userID, workspace = s.UserID, s.WorkspaceID
+			ctx = context.WithValue(ctx, sessionContextKey, s)
+		} else if authUserHeader != "-" && fromTrustedProxy(r) {
+			userID = strings.TrimSpace(r.Header.Get(authUserHeader))
+			if userID != "" && workspaceHeader != "-" {
+				workspace = strings.TrimSpace(r.Header.Get(workspaceHeader))
+			}
+		}
+		if userID != "" {
+			ctx = context.WithValue(ctx, userContextKey, userID)
+		}
+		if workspace != "" {
+			ctx = context.WithValue(ctx, workspaceContextKey, workspace)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireUser rejects anonymous requests with 401.
+func requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestCaller(r).userID == "" {
+			rnd.JSON(w, http.StatusUnauthorized, errorResponse{
+				Message: "Authentication required",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signInEnabled reports whether users can sign in, through the
+// authenticating proxy or an OAuth provider. Without either every request
+// is anonymous.
+func signInEnabled() bool {
+	return authUserHeader != "-" || len(oauthProviders) > 0
+}
+
+// adminUsers lists the user IDs allowed into the admin-only endpoints.
+var adminUsers = envList("ADMIN_USERS", nil)
+
+func (c caller) isAdmin() bool {
+	for _, id := range adminUsers {
+		if c.userID != "" && c.userID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdmin rejects anonymous requests with 401 and requests from users
+// not in ADMIN_USERS with 403.
+func requireAdmin(next http.Handler) http.Handler {
+	return requireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requestCaller(r).isAdmin() {
+			rnd.JSON(w, http.StatusForbidden, errorResponse{
+				Message: "Admin access required",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// trustTestProxy turns on the user and workspace headers for requests from httptest's
+// default client address, so tests can sign in by setting it.
+func trustTestProxy() {
+	authUserHeader = "X-Forwarded-User"
+	workspaceHeader = "X-Workspace-ID"
+	trustedProxies = parseCIDRs("TRUSTED_PROXIES", []string{"192.0.2.0/24"})
+}
+
+func TestUserHeaderNeedsTrustedProxy(t *testing.T) {
+	oldHeader, oldProxies := authUserHeader, trustedProxies
+	t.Cleanup(func() { authUserHeader, trustedProxies = oldHeader, oldProxies })
+
+	var got caller
+	h := identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestCaller(r)
+	}))
+	spoofed := func(remoteAddr string) caller {
+		got = caller{}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-User", "root")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	// By default the header is off, even from a proxy's address.
+	authUserHeader = "-"
+	if c := spoofed("192.0.2.1:1234"); c.userID != "" {
+		t.Errorf("header honored by default: %+v", c)
+	}
+
+	authUserHeader = "X-Forwarded-User"
+	trustedProxies = nil
+	if c := spoofed("192.0.2.1:1234"); c.userID != "" {
+		t.Errorf("header honored without trusted proxies: %+v", c)
+	}
+
+	trustedProxies = parseCIDRs("TRUSTED_PROXIES", []string{"10.0.0.0/8", "not a network"})
+	if c := spoofed("192.0.2.1:1234"); c.userID != "" {
+		t.Errorf("header honored from an untrusted address: %+v", c)
+	}
+	if c := spoofed("10.1.2.3:1234"); c.userID != "root" {
+		t.Errorf("header from a trusted proxy = %+v", c)
+	}
+
+	// A signed-in session wins over the header, even from a trusted proxy.
+	oldSessions := sessions
+	sessions = newMemorySessionStore()
+	t.Cleanup(func() { sessions = oldSessions })
+	s := session{ID: "s1", UserID: "ann", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := sessions.save(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-User", "root")
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: s.ID})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.userID != "ann" {
+		t.Errorf("header overrode the session: %+v", got)
+	}
+}
+
+func TestWorkspaceFromHeader(t *testing.T) {
+	oldSessions := sessions
+	sessions = newMemorySessionStore()
+	t.Cleanup(func() { sessions = oldSessions })
+	s := session{ID: "s1", UserID: "bob", WorkspaceID: "globex", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := sessions.save(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	var got caller
+	h := identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestCaller(r)
+	}))
+	tests := []struct {
+		name       string
+		remoteAddr string
+		user       string
+		cookie     string
+		want       caller
+	}{
+		{"proxy user", "192.0.2.1:1234", "ann", "", caller{userID: "ann", workspace: "acme"}},
+		{"anonymous", "192.0.2.1:1234", "", "", caller{}},
+		{"untrusted address", "203.0.113.9:1234", "ann", "", caller{}},
+		{"session", "192.0.2.1:1234", "", s.ID, caller{userID: "bob", workspace: "globex"}},
+	}
+	for _, tt := range tests {
+		got = caller{}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.user != "" {
+			req.Header.Set(authUserHeader, tt.user)
+		}
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+		}
+		req.Header.Set(workspaceHeader, " acme ")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got.userID != tt.want.userID || got.workspace != tt.want.workspace {
+			t.Errorf("%s: caller = %+v, want %+v", tt.name, got, tt.want)
+		}
+
+		// Only the proxy's user may see acme's todos.
+		err := svc.authorize(context.Background(), got, todoModel{WorkspaceID: "acme"}, false)
+		if visible := err == nil; visible != (tt.want.workspace == "acme") {
+			t.Errorf("%s: acme todo visible = %v (%v)", tt.name, visible, err)
+		}
+	}
+}
+
+func TestAuthorizeAcrossWorkspaces(t *testing.T) {
+	tests := []struct {
+		todo   todoModel
+		caller caller
+		err    error
+	}{
+		{todoModel{OwnerID: "ann", WorkspaceID: "acme"}, caller{userID: "ann", workspace: "acme"}, nil},
+		{todoModel{OwnerID: "ann", WorkspaceID: "acme"}, caller{userID: "ann"}, errTodoNotFound},
+		{todoModel{OwnerID: "ann"}, caller{userID: "ann", workspace: "acme"}, errTodoNotFound},
+		{todoModel{WorkspaceID: "acme"}, caller{workspace: "globex"}, errTodoNotFound},
+		{todoModel{WorkspaceID: "acme"}, caller{workspace: "acme"}, errForbidden},
+	}
+	for _, tt := range tests {
+		if err := svc.authorize(context.Background(), tt.caller, tt.todo, true); !errors.Is(err, tt.err) {
+			t.Errorf("%+v as %+v: error %v, want %v", tt.todo, tt.caller, err, tt.err)
+		}
+	}
+}
+
+func TestAuthorizeAnonymousTodos(t *testing.T) {
+	oldHeader, oldAdmins := authUserHeader, adminUsers
+	t.Cleanup(func() { authUserHeader, adminUsers = oldHeader, oldAdmins })
+	adminUsers = []string{"root"}
+
+	anonymous := todoModel{Title: "Shared"}
+	tests := []struct {
+		signIn bool
+		caller caller
+		write  bool
+		err    error
+	}{
+		{true, caller{userID: "ann"}, false, nil},
+		{true, caller{userID: "ann"}, true, errForbidden},
+		{true, caller{}, true, errForbidden},
+		{true, caller{userID: "root"}, true, nil},
+		{false, caller{}, true, nil},
+	}
+	for _, tt := range tests {
+		authUserHeader = "-"
+		if tt.signIn {
+			authUserHeader = "X-Forwarded-User"
+		}
+		if err := svc.authorize(context.Background(), tt.caller, anonymous, tt.write); !errors.Is(err, tt.err) {
+			t.Errorf("sign-in %v, %+v, write %v: error %v, want %v", tt.signIn, tt.caller, tt.write, err, tt.err)
+		}
+	}
+}
+
+func TestCallerScoped(t *testing.T) {
+	if f := (caller{}).scoped(bson.M{}); f["workspace_id"] != nil {
+		t.Errorf("default workspace filter = %v", f)
+	}
+	if f := (caller{workspace: "acme"}).scoped(bson.M{}); f["workspace_id"] != "acme" {
+		t.Errorf("acme filter = %v", f)
+	}
+}
+
+func TestSignupWorkspace(t *testing.T) {
+	old, oldErrors := workspaceDomains, configErrors
+	t.Cleanup(func() { workspaceDomains, configErrors = old, oldErrors })
+	t.Setenv("WORKSPACE_DOMAINS_TEST", "Acme.com = acme, globex.com, =x")
+	workspaceDomains = parseWorkspaceDomains("WORKSPACE_DOMAINS_TEST")
+	if len(workspaceDomains) != 1 || workspaceDomains["acme.com"] != "acme" {
+		t.Fatalf("domains = %v", workspaceDomains)
+	}
+
+	id := primitive.NewObjectID()
+	tests := []struct{ email, want string }{
+		{"ann@acme.com", "acme"},
+		{"bob@globex.com", id.Hex()},
+		{"", id.Hex()},
+	}
+	for _, tt := range tests {
+		if got := signupWorkspace(id, tt.email); got != tt.want {
+			t.Errorf("signupWorkspace(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
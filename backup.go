@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	backupFormat  = "todo-go-backup"
+	backupVersion = 1
+
+	// restoreBatchSize is how many documents are inserted at a time.
+	restoreBatchSize = 500
+)
+
+// backupHeader starts a backup. The collections follow under
+// "collections", each a list of documents in canonical extended JSON, so
+// ObjectIDs, dates and binary data come back as they were:
+//
+//	{"format":"todo-go-backup","version":1,"created_at":"...",
+//	 "collections":{"lists":[...],"todo":[...]}}
+type backupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupDump is a backup read back for restoring, by collection.
+type backupDump map[string][]bson.D
+
+// restoreResult counts the documents a restore wrote, or with DryRun would
+// write, by collection.
+type restoreResult struct {
+	DryRun      bool           `json:"dry_run"`
+	Collections map[string]int `json:"collections"`
+	Documents   int            `json:"documents"`
+}
+
+// backupCollections lists the collections to back up: every collection in
+// the database but MongoDB's own.
+func (s *todoService) backupCollections(ctx context.Context) ([]string, error) {
+	names, err := s.todos.Database().ListCollectionNames(ctx, bson.M{
+		"name": bson.M{"$not": bson.M{"$regex": "^system\\."}},
+		"type": "collection",
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// backup writes every collection to w as JSON, one document at a time.
+func (s *todoService) backup(ctx context.Context, w io.Writer) error {
+	names, err := s.backupCollections(ctx)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	header, _ := json.Marshal(backupHeader{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().UTC()})
+	// The collections go inside the header's object.
+	bw.Write(header[:len(header)-1])
+	bw.WriteString(`,"collections":{`)
+	for i, name := range names {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		bw.Write(key)
+		bw.WriteString(":[")
+
+		cursor, err := s.todos.Database().Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		for n := 0; cursor.Next(ctx); n++ {
+			doc, err := bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			if n > 0 {
+				bw.WriteByte(',')
+			}
+			bw.Write(doc)
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return err
+		}
+		bw.WriteByte(']')
+	}
+	bw.WriteString("}}\n")
+	return bw.Flush()
+}
+
+// readBackup reads a backup written by backup, gzipped or not.
+func readBackup(r io.Reader) (backupDump, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	var raw struct {
+		backupHeader
+		Collections map[string][]json.RawMessage `json:"collections"`
+	}
+	if err := json.NewDecoder(br).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	if raw.Format != backupFormat {
+		return nil, errors.New("not a backup made by GET /admin/backup")
+	}
+	if raw.Version != backupVersion {
+		return nil, fmt.Errorf("backup version %d isn't supported", raw.Version)
+	}
+
+	dump := backupDump{}
+	for name, docs := range raw.Collections {
+		if name == "" || strings.HasPrefix(name, "system.") || strings.ContainsAny(name, "$\x00") {
+			return nil, fmt.Errorf("%q can't be restored", name)
+		}
+		dump[name] = make([]bson.D, 0, len(docs))
+		for i, doc := range docs {
+			var d bson.D
+			if err := bson.UnmarshalExtJSON(doc, true, &d); err != nil {
+				return nil, fmt.Errorf("%s document %d: %w", name, i+1, err)
+			}
+			dump[name] = append(dump[name], d)
+		}
+	}
+	return dump, nil
+}
+
+// result counts the documents in the dump.
+func (d backupDump) result(dryRun bool) restoreResult {
+	res := restoreResult{DryRun: dryRun, Collections: map[string]int{}}
+	for name, docs := range d {
+		res.Collections[name] = len(docs)
+		res.Documents += len(docs)
+	}
+	return res
+}
+
+// restore replaces the contents of each collection in the dump with the
+// dump's documents. Collections the dump doesn't have are left alone.
+func (s *todoService) restore(ctx context.Context, dump backupDump) error {
+	// Todos both gone and restored may be cached.
+	stale, err := s.todos.Distinct(ctx, "_id", bson.M{})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(dump))
+	for name := range dump {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		coll := s.todos.Database().Collection(name)
+		if _, err := coll.DeleteMany(ctx, bson.M{}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		docs := dump[name]
+		for start := 0; start < len(docs); start += restoreBatchSize {
+			end := min(start+restoreBatchSize, len(docs))
+			batch := make([]interface{}, 0, end-start)
+			for _, doc := range docs[start:end] {
+				batch = append(batch, doc)
+			}
+			if _, err := coll.InsertMany(ctx, batch); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if name == collName {
+			for _, doc := range docs {
+				stale = append(stale, doc.Map()["_id"])
+			}
+		}
+	}
+
+	if s.cache != nil {
+		cctx := context.WithoutCancel(ctx)
+		for _, id := range stale {
+			if id, ok := id.(primitive.ObjectID); ok {
+				s.cache.invalidateTodo(cctx, id)
+			}
+		}
+		s.cache.invalidateLists(cctx)
+	}
+	return nil
+}
+
+// backupData answers GET /admin/backup with a gzipped dump of the
+// database.
+func backupData(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Fail before the response starts if the database is unreachable.
+	if _, err := svc.backupCollections(ctx); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to back up data",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	name := "todo-go-backup-" + time.Now().UTC().Format("20060102-150405") + ".json.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	zw := gzip.NewWriter(w)
+	err := svc.backup(ctx, zw)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// The status has gone out; a truncated file won't unzip, so the
+		// client can tell.
+		log.Printf("Backup failed: %v", err)
+	}
+}
+
+// restoreData answers POST /admin/restore, which restores a dump made by
+// backupData. With ?dry_run=true it only checks the dump and says what it
+// would restore.
+func restoreData(w http.ResponseWriter, r *http.Request) {
+	dump, err := readBackup(r.Body)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to restore data",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	res := dump.result(r.URL.Query().Get("dry_run") == "true")
+	if res.DryRun {
+		rnd.JSON(w, http.StatusOK, itemResponse[restoreResult]{
+			Message: fmt.Sprintf("Would restore %d documents in %d collections", res.Documents, len(res.Collections)),
+			Data:    res,
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := svc.restore(ctx, dump); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to restore data",
+			Error:   err.Error(),
+		})
+		return
+	}
+	log.Printf("Restored %d documents in %d collections", res.Documents, len(res.Collections))
+	rnd.JSON(w, http.StatusOK, itemResponse[restoreResult]{
+		Message: fmt.Sprintf("Restored %d documents in %d collections", res.Documents, len(res.Collections)),
+		Data:    res,
+	})
+}
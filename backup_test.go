@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReadBackup(t *testing.T) {
+	id := primitive.NewObjectID()
+	created := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	doc, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "Buy milk"}, {Key: "created_at", Value: created}}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := `{"format":"todo-go-backup","version":1,"created_at":"2026-10-16T00:00:00Z","collections":{"todo":[` + string(doc) + `],"lists":[]}}`
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(backup))
+	zw.Close()
+
+	for name, body := range map[string][]byte{"plain": []byte(backup), "gzip": gz.Bytes()} {
+		dump, err := readBackup(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(dump["todo"]) != 1 || len(dump["lists"]) != 0 {
+			t.Fatalf("%s: dump = %v", name, dump)
+		}
+		got := dump["todo"][0].Map()
+		if got["_id"] != id || got["created_at"] != primitive.NewDateTimeFromTime(created) {
+			t.Errorf("%s: todo = %v", name, got)
+		}
+		if res := dump.result(true); res.Documents != 1 || res.Collections["lists"] != 0 || !res.DryRun {
+			t.Errorf("%s: result = %+v", name, res)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"todos":[]}`,
+		`{"format":"todo-go-backup","version":2,"collections":{}}`,
+		`{"format":"todo-go-backup","version":1,"collections":{"system.users":[]}}`,
+		`{"format":"todo-go-backup","version":1,"collections":{"todo":[{"_id":{"$oid":"nope"}}]}}`,
+	} {
+		if _, err := readBackup(strings.NewReader(bad)); err == nil {
+			t.Errorf("readBackup(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestRestoreRejectsBadDumps(t *testing.T) {
+	old := adminUsers
+	adminUsers = []string{"root"}
+	t.Cleanup(func() { adminUsers = old })
+
+	rec := serve(t, http.MethodPost, "/admin/restore?dry_run=true", "root", `{"format":"something else"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, http.MethodPost, "/admin/restore", "ann", `{}`); rec.Code != http.StatusForbidden {
+		t.Errorf("restore as a non-admin: status %d", rec.Code)
+	}
+}
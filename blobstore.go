@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errBlobNotFound = errors.New("blob not found")
+
+// blobStore keeps the contents of attachments. Keys are generated by the
+// caller and are safe to use in URLs.
+type blobStore interface {
+	name() string
+	put(ctx context.Context, key, contentType string, body io.Reader, size int64) error
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+	delete(ctx context.Context, key string) error
+}
+
+// newBlobStore picks the attachment storage backend from ATTACHMENT_STORAGE:
+// "gridfs" (the default) stores files in the todo database, "s3" stores them
+// in an S3 compatible bucket configured through the S3_* variables.
+func newBlobStore(db *mongo.Database) (blobStore, error) {
+	switch backend := envString("ATTACHMENT_STORAGE", "gridfs"); backend {
+	case "gridfs":
+		return &gridFSStore{db: db}, nil
+	case "s3":
+		s := &s3Store{
+			endpoint:  strings.TrimRight(envString("S3_ENDPOINT", "https://s3.amazonaws.com"), "/"),
+			bucket:    envString("S3_BUCKET", ""),
+			region:    envString("S3_REGION", "us-east-1"),
+			accessKey: envString("S3_ACCESS_KEY_ID", ""),
+			secretKey: envString("S3_SECRET_ACCESS_KEY", ""),
+			client:    &http.Client{Timeout: 30 * time.Second, Transport: tracedTransport()},
+		}
+		if s.bucket == "" || s.accessKey == "" || s.secretKey == "" {
+			return nil, errors.New("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for s3 attachment storage")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown ATTACHMENT_STORAGE %q", backend)
+	}
+}
+
+const attachmentBucketName = "attachments"
+
+type gridFSStore struct {
+	db *mongo.Database
+}
+
+func (g *gridFSStore) name() string { return "gridfs" }
+
+// bucket returns a GridFS bucket bounded by the context deadline. Buckets
+// are cheap and keep their deadlines as state, so one is made per call.
+func (g *gridFSStore) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	b, err := gridfs.NewBucket(g.db, options.GridFSBucket().SetName(attachmentBucketName))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := b.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		if err := b.SetWriteDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (g *gridFSStore) put(ctx context.Context, key, contentType string, body io.Reader, size int64) error {
+	b, err := g.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	return b.UploadFromStreamWithID(key, key, body)
+}
+
+func (g *gridFSStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	b, err := g.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := b.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, errBlobNotFound
+	}
+	return stream, err
+}
+
+func (g *gridFSStore) delete(ctx context.Context, key string) error {
+	b, err := g.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	err = b.DeleteContext(ctx, key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return errBlobNotFound
+	}
+	return err
+}
+
+// s3Store talks to an S3 compatible object store (AWS S3, MinIO, ...) using
+// path-style URLs and AWS Signature Version 4.
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (s *s3Store) name() string { return "s3" }
+
+func (s *s3Store) put(ctx context.Context, key, contentType string, body io.Reader, size int64) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3Store) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3Store) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(s.endpoint + "/" + s.bucket + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+func (s *s3Store) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errBlobNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req. The
+// payload is left unsigned so uploads can be streamed.
+func (s *s3Store) sign(req *http.Request, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
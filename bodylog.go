@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// debugHeader asks for a request's bodies to be logged whatever the sample
+// rate, so a client integration can be followed one request at a time.
+const debugHeader = "X-Debug"
+
+const redacted = "[REDACTED]"
+
+var (
+	// logBodiesOn turns body logging on. It is off by default, as bodies
+	// hold users' todos.
+	logBodiesOn = envBool("LOG_BODIES", false)
+	// logBodiesSample is the percentage of other requests whose bodies are
+	// logged.
+	logBodiesSample = int(envInt64("LOG_BODIES_SAMPLE", 0))
+	// logBodiesLimit caps how many bytes of each body are logged.
+	logBodiesLimit = int(envInt64("LOG_BODIES_LIMIT", 4096))
+)
+
+// secretFields are the body fields, in JSON or form bodies, whose values are
+// never logged. A field matches if its lower-cased name contains one.
+var secretFields = []string{"code", "key", "password", "secret", "token"}
+
+// logBodies logs the request and response bodies of requests carrying
+// X-Debug, and of a LOG_BODIES_SAMPLE share of the rest, when LOG_BODIES is
+// on. Bodies are cut at LOG_BODIES_LIMIT bytes and secrets are redacted.
+func logBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logBodiesOn || !sampled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var reqBody []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(logBodiesLimit)+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		// One byte over the limit tells loggedBody the body was cut.
+		respBody := &cappedBuffer{limit: logBodiesLimit + 1}
+		ww.Tee(respBody)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.Printf("debug: %s %s request=%s", r.Method, r.URL.Path,
+			loggedBody(r.Header.Get("Content-Type"), reqBody))
+		log.Printf("debug: %s %s %d response=%s", r.Method, r.URL.Path, status,
+			loggedBody(ww.Header().Get("Content-Type"), respBody.Bytes()))
+	})
+}
+
+// sampled reports whether the request's bodies should be logged.
+func sampled(r *http.Request) bool {
+	if v := r.Header.Get(debugHeader); v != "" && v != "0" && v != "false" {
+		return true
+	}
+	return logBodiesSample > 0 && rand.Intn(100) < logBodiesSample
+}
+
+// readCloser reads from a replacement reader but closes the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// cappedBuffer keeps the first limit bytes written to it.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// loggedBody formats a body for the log: JSON and form bodies with their
+// secrets redacted, other text as it is, and other bodies by their type only.
+// A body of more than logBodiesLimit bytes is cut there.
+func loggedBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return `""`
+	}
+	cut := len(body) > logBodiesLimit
+	if cut {
+		body = body[:logBodiesLimit]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var out string
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		out = redactJSON(body, cut)
+	case mediaType == "application/x-www-form-urlencoded":
+		out = redactForm(body)
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/xml":
+		out = string(body)
+	case mediaType == "":
+		return "[binary body]"
+	default:
+		return "[" + mediaType + " body]"
+	}
+	if cut {
+		out += "..."
+	}
+	// Quoting keeps the body on one log line.
+	return strconv.Quote(out)
+}
+
+// redactJSON replaces the values of secret fields. A body that was cut, or
+// isn't valid JSON, can't be parsed; its secrets are blanked out by name.
+func redactJSON(body []byte, cut bool) string {
+	var v interface{}
+	if !cut && json.Unmarshal(body, &v) == nil {
+		b, _ := json.Marshal(redactValue(v))
+		return string(b)
+	}
+	return redactJSONText(string(body))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if secretField(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return v
+}
+
+// redactJSONText blanks out the string values following secret field names
+// in text that may not parse as JSON.
+func redactJSONText(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, `":`)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		name := s[strings.LastIndex(s[:i], `"`)+1 : i]
+		b.WriteString(s[:i+2])
+		s = s[i+2:]
+		rest := strings.TrimLeft(s, " ")
+		if !secretField(name) || !strings.HasPrefix(rest, `"`) {
+			continue
+		}
+		b.WriteString(`"` + redacted + `"`)
+		end := strings.Index(rest[1:], `"`)
+		if end < 0 {
+			return b.String()
+		}
+		s = rest[end+2:]
+	}
+}
+
+func redactForm(body []byte) string {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "[unparsable form body]"
+	}
+	for k := range values {
+		if secretField(k) {
+			values[k] = []string{redacted}
+		}
+	}
+	return values.Encode()
+}
+
+func secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggedBody(t *testing.T) {
+	tests := []struct {
+		contentType, body string
+		want              string
+	}{
+		{"application/json", `{"title":"Buy milk","password":"hunter2"}`, `"{\"password\":\"[REDACTED]\",\"title\":\"Buy milk\"}"`},
+		{"application/json; charset=utf-8", `{"data":[{"key":"tdk_abc"}]}`, `"{\"data\":[{\"key\":\"[REDACTED]\"}]}"`},
+		{"application/x-www-form-urlencoded", "text=milk&token=xyz", `"text=milk&token=%5BREDACTED%5D"`},
+		{"text/plain", "hello\nworld", `"hello\nworld"`},
+		{"image/png", "\x89PNG", "[image/png body]"},
+		{"", "", `""`},
+	}
+	for _, tt := range tests {
+		if got := loggedBody(tt.contentType, []byte(tt.body)); got != tt.want {
+			t.Errorf("loggedBody(%q, %q) = %s, want %s", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestLoggedBodyCut(t *testing.T) {
+	old := logBodiesLimit
+	logBodiesLimit = 30
+	t.Cleanup(func() { logBodiesLimit = old })
+
+	body := `{"api_token":"abc","title":"` + strings.Repeat("x", 40) + `"}`
+	got := loggedBody("application/json", []byte(body))
+	if strings.Contains(got, "abc") || !strings.HasSuffix(got, `..."`) {
+		t.Errorf("loggedBody = %s", got)
+	}
+}
+
+func TestLogBodies(t *testing.T) {
+	oldOn, oldSample, oldOutput := logBodiesOn, logBodiesSample, log.Writer()
+	logBodiesOn, logBodiesSample = true, 0
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() {
+		logBodiesOn, logBodiesSample = oldOn, oldSample
+		log.SetOutput(oldOutput)
+	})
+
+	h := logBodies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(b)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/todo/", strings.NewReader(`{"title":"Buy milk"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if logged.Len() != 0 {
+		t.Errorf("logged an unsampled request: %s", logged.String())
+	}
+	if rec.Body.String() != `{"title":"Buy milk"}` {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/todo/", strings.NewReader(`{"title":"Buy milk"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(debugHeader, "1")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Body.String() != `{"title":"Buy milk"}` {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+	out := logged.String()
+	if !strings.Contains(out, `POST /todo/ request="{\"title\":\"Buy milk\"}"`) ||
+		!strings.Contains(out, `POST /todo/ 201 response=`) {
+		t.Errorf("log = %s", out)
+	}
+}
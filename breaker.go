@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// storeTimeout bounds each todo store operation, so a slow database
+	// fails it instead of holding the request for as long as it may run. 0
+	// waits as long as the request does.
+	storeTimeout = envDuration("STORE_TIMEOUT", 5*time.Second)
+
+	// breaker stops sending requests to MongoDB after BREAKER_FAILURES
+	// failures in a row, for BREAKER_COOLDOWN. It is nil when
+	// BREAKER_FAILURES is 0.
+	breaker = newCircuitBreaker(int(envInt64("BREAKER_FAILURES", 5)), envDuration("BREAKER_COOLDOWN", 30*time.Second))
+)
+
+var errStoreUnavailable = errors.New("the database isn't answering, please try again later")
+
+// storeContext bounds a single store operation by storeTimeout.
+func storeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if storeTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, storeTimeout)
+}
+
+// storeUnavailable answers 503 with a Retry-After header, and reports true,
+// if err means the database is unreachable or didn't answer in time.
+func storeUnavailable(w http.ResponseWriter, message string, err error) bool {
+	if !errors.Is(err, errStoreUnavailable) && !errors.Is(err, context.DeadlineExceeded) && !mongo.IsTimeout(err) {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfter(breaker.open()))
+	rnd.JSON(w, http.StatusServiceUnavailable, errorResponse{
+		Message: message,
+		Error:   errStoreUnavailable.Error(),
+	})
+	return true
+}
+
+// retryAfter formats wait for a Retry-After header, in whole seconds and
+// at least one.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(max(int((wait+time.Second-1)/time.Second), 1))
+}
+
+// circuitBreaker tracks whether MongoDB is answering. It learns of
+// failures from the driver: connections that broke or timed out, check-outs
+// that found no connection, and failed heartbeats. Any command that
+// succeeds resets it.
+//
+// After threshold failures in a row it opens: writes are refused with 503
+// and reads are served from the read cache until cooldown has passed. Then
+// requests go through again, and the next failure opens it for another
+// cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+func init() {
+	expvar.Publish("store_breaker", expvar.Func(func() interface{} {
+		return breaker.stats()
+	}))
+}
+
+// stats reports the breaker under /debug/vars.
+func (b *circuitBreaker) stats() interface{} {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{"failures": b.failures, "open": b.openLocked(time.Now()) > 0}
+}
+
+// open returns how much longer the breaker stays open, or 0 if requests
+// may go through.
+func (b *circuitBreaker) open() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openLocked(time.Now())
+}
+
+func (b *circuitBreaker) openLocked(now time.Time) time.Duration {
+	if b.failures < b.threshold {
+		return 0
+	}
+	return max(b.openedAt.Add(b.cooldown).Sub(now), 0)
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures >= b.threshold {
+		log.Println("MongoDB is answering again, closing the circuit breaker")
+	}
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.openLocked(now) > 0 {
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = now
+		log.Printf("MongoDB failed %d times in a row, opening the circuit breaker for %s", b.failures, b.cooldown)
+	}
+}
+
+// poolMonitor passes the pool events to next and counts the ones that mean
+// MongoDB can't be reached or is too slow.
+func (b *circuitBreaker) poolMonitor(next *event.PoolMonitor) *event.PoolMonitor {
+	if b == nil {
+		return next
+	}
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch {
+			case e.Type == event.GetFailed && e.Reason != event.ReasonPoolClosed,
+				e.Type == event.ConnectionClosed && e.Reason == event.ReasonError:
+				b.failure()
+			}
+			next.Event(e)
+		},
+	}
+}
+
+// commandMonitor passes the command events to next, if any, and resets the
+// breaker on every command that succeeds. Commands that fail with an error
+// from the server, such as a duplicate key, don't count as failures.
+func (b *circuitBreaker) commandMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	if b == nil {
+		return next
+	}
+	m := &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			b.success()
+			if next != nil {
+				next.Succeeded(ctx, e)
+			}
+		},
+	}
+	if next != nil {
+		m.Started, m.Failed = next.Started, next.Failed
+	}
+	return m
+}
+
+func (b *circuitBreaker) serverMonitor() *event.ServerMonitor {
+	if b == nil {
+		return nil
+	}
+	return &event.ServerMonitor{
+		ServerHeartbeatFailed: func(*event.ServerHeartbeatFailedEvent) {
+			b.failure()
+		},
+	}
+}
+
+type cacheOnlyKey struct{}
+
+// storeFallback notes that a cache-only request needed the database.
+type storeFallback struct {
+	missed bool
+}
+
+// storeMissed returns errStoreUnavailable, noting the miss, for requests
+// that may only read from the cache.
+func storeMissed(ctx context.Context) error {
+	fallback, _ := ctx.Value(cacheOnlyKey{}).(*storeFallback)
+	if fallback == nil {
+		return nil
+	}
+	fallback.missed = true
+	return errStoreUnavailable
+}
+
+// guardStore answers writes with 503 and a Retry-After header while the
+// breaker is open, rather than letting them wait on the database. Todos
+// and todo listings are read from the read cache; those it doesn't hold
+// get 503 too. Reads that don't touch todos, like /version, go on as usual.
+func guardStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait := breaker.open()
+		if wait <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !safeMethod(r.Method) {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			rnd.JSON(w, http.StatusServiceUnavailable, errorResponse{
+				Message: "Database unavailable",
+				Error:   errStoreUnavailable.Error(),
+			})
+			return
+		}
+
+		fallback := &storeFallback{}
+		ctx := context.WithValue(r.Context(), cacheOnlyKey{}, fallback)
+		next.ServeHTTP(&fallbackWriter{ResponseWriter: w, fallback: fallback, retryAfter: retryAfter(wait)}, r.WithContext(ctx))
+	})
+}
+
+// fallbackWriter turns the 500 a handler answers a cache miss with into a
+// 503, which clients know to retry.
+type fallbackWriter struct {
+	http.ResponseWriter
+	fallback   *storeFallback
+	retryAfter string
+}
+
+func (w *fallbackWriter) WriteHeader(status int) {
+	if status == http.StatusInternalServerError && w.fallback.missed {
+		w.Header().Set("Retry-After", w.retryAfter)
+		status = http.StatusServiceUnavailable
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *fallbackWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
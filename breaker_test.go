@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(3, 50*time.Millisecond)
+
+	b.failure()
+	b.failure()
+	if b.open() > 0 {
+		t.Fatal("open after 2 of 3 failures")
+	}
+	b.success()
+	b.failure()
+	b.failure()
+	if b.open() > 0 {
+		t.Fatal("a success didn't reset the count")
+	}
+	b.failure()
+	if b.open() <= 0 {
+		t.Fatal("closed after 3 failures in a row")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b.open() > 0 {
+		t.Fatal("still open after the cooldown")
+	}
+	// One more failure opens it again, a success closes it.
+	b.failure()
+	if b.open() <= 0 {
+		t.Fatal("closed after failing again")
+	}
+	b.success()
+	if b.open() > 0 {
+		t.Fatal("open after a success")
+	}
+
+	if newCircuitBreaker(0, time.Second).open() > 0 {
+		t.Error("a disabled breaker opened")
+	}
+}
+
+func TestGuardStoreWhenOpen(t *testing.T) {
+	unreachableService(t)
+	svc.cache = &memoryCache{
+		todos: newLRUCache("todos", 10, time.Minute),
+		lists: newLRUCache("lists", 10, time.Minute),
+	}
+	cached := todoModel{ID: primitive.NewObjectID(), Title: "Cached", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	svc.cache.putTodo(context.Background(), cached, svc.cache.generation(context.Background()))
+
+	old := breaker
+	breaker = newCircuitBreaker(1, time.Minute)
+	breaker.failure()
+	t.Cleanup(func() { breaker = old })
+
+	rec := serve(t, http.MethodPost, "/todo/", "", `{"title":"Paint the fence"}`)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("create: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	var resp todoResponse
+	rec = serve(t, http.MethodGet, "/todo/"+cached.ID.Hex(), "", "")
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.Data.Title != "Cached" {
+		t.Errorf("cached read: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	start := time.Now()
+	rec = serve(t, http.MethodGet, "/todo/"+primitive.NewObjectID().Hex(), "", "")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("uncached read: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("uncached read took %s, it went to the database", elapsed)
+	}
+
+	if rec := serve(t, http.MethodGet, "/version", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("version: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cacheStats counts hits, misses and evictions per cache, e.g. "todos.hits".
+// They are served with the other expvars under /debug/vars.
+var cacheStats = expvar.NewMap("cache")
+
+// readCache holds recently read todos and todo lists. Writes invalidate the
+// entries they affect, and each entry also expires after a while, which
+// bounds how stale a read can be if an invalidation is missed.
+//
+// To keep a slow read from caching what it saw before a concurrent write,
+// readers take the generation before querying and pass it to put; the put
+// is dropped if anything was invalidated in between.
+type readCache interface {
+	generation(ctx context.Context) uint64
+	todo(ctx context.Context, id primitive.ObjectID) (todoModel, bool)
+	putTodo(ctx context.Context, t todoModel, gen uint64)
+	list(ctx context.Context, key string) ([]todoModel, bool)
+	putList(ctx context.Context, key string, todos []todoModel, gen uint64)
+	// invalidateTodo drops the todo and every cached list, since any of
+	// them may include it.
+	invalidateTodo(ctx context.Context, id primitive.ObjectID)
+	// invalidateLists drops every cached list, e.g. after list membership
+	// changed what a user can see.
+	invalidateLists(ctx context.Context)
+}
+
+// newReadCache returns the cache selected by CACHE_STORE. "memory" keeps up
+// to CACHE_SIZE todos and as many lists in each instance; "redis" shares
+// one cache between all instances and is the default when REDIS_URL is set.
+// Without either the cache is disabled and newReadCache returns nil.
+func newReadCache(rdb *redis.Client) (readCache, error) {
+	ttl := envDuration("CACHE_TTL", 30*time.Second)
+
+	fallback := ""
+	if rdb != nil {
+		fallback = "redis"
+	} else if envInt64("CACHE_SIZE", 0) > 0 {
+		fallback = "memory"
+	}
+
+	switch store := envString("CACHE_STORE", fallback); store {
+	case "":
+		return nil, nil
+	case "memory":
+		size := int(envInt64("CACHE_SIZE", 1000))
+		return &memoryCache{
+			todos: newLRUCache("todos", size, ttl),
+			lists: newLRUCache("lists", size, ttl),
+		}, nil
+	case "redis":
+		if rdb == nil {
+			return nil, errors.New("CACHE_STORE=redis requires REDIS_URL")
+		}
+		return &redisCache{
+			client: rdb,
+			prefix: envString("REDIS_PREFIX", "todo-go:"),
+			ttl:    ttl,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_STORE %q", store)
+	}
+}
+
+// listCacheKey identifies a todo listing as seen by c.
+func listCacheKey(c caller, q todoQuery) string {
+	return "workspace:" + c.workspace + ":user:" + c.userID + q.cacheKey()
+}
+
+type memoryCache struct {
+	gen   atomic.Uint64
+	todos *lruCache
+	lists *lruCache
+}
+
+func (m *memoryCache) generation(ctx context.Context) uint64 {
+	return m.gen.Load()
+}
+
+func (m *memoryCache) todo(ctx context.Context, id primitive.ObjectID) (todoModel, bool) {
+	v, ok := m.todos.get(id.Hex())
+	if !ok {
+		return todoModel{}, false
+	}
+	return v.(todoModel), true
+}
+
+func (m *memoryCache) putTodo(ctx context.Context, t todoModel, gen uint64) {
+	m.todos.put(t.ID.Hex(), t, func() bool { return m.gen.Load() == gen })
+}
+
+func (m *memoryCache) list(ctx context.Context, key string) ([]todoModel, bool) {
+	v, ok := m.lists.get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]todoModel), true
+}
+
+func (m *memoryCache) putList(ctx context.Context, key string, todos []todoModel, gen uint64) {
+	m.lists.put(key, todos, func() bool { return m.gen.Load() == gen })
+}
+
+func (m *memoryCache) invalidateTodo(ctx context.Context, id primitive.ObjectID) {
+	m.gen.Add(1)
+	m.todos.remove(id.Hex())
+	m.lists.clear()
+}
+
+func (m *memoryCache) invalidateLists(ctx context.Context) {
+	m.gen.Add(1)
+	m.lists.clear()
+}
+
+// lruCache is a size-bounded map that evicts the least recently used entry
+// when full and treats entries older than its ttl as missing.
+type lruCache struct {
+	name     string
+	capacity int
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRUCache(name string, capacity int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		name:     name,
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		cacheStats.Add(c.name+".misses", 1)
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		cacheStats.Add(c.name+".misses", 1)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	cacheStats.Add(c.name+".hits", 1)
+	return e.value, true
+}
+
+// put stores value under key unless valid, which is checked under the
+// cache's lock, reports false.
+func (c *lruCache) put(key string, value interface{}, valid func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !valid() {
+		return
+	}
+	e := &lruEntry{key: key, value: value, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(e)
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+		cacheStats.Add(c.name+".evictions", 1)
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *lruCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = map[string]*list.Element{}
+}
+
+// redisCache keeps the cache in Redis so all instances share it. Lists are
+// stored under the current generation, so invalidating them is a single
+// INCR and the stale entries simply expire. Redis errors are logged and
+// treated as misses; the database remains the source of truth.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// redisCacheTimeout bounds every cache command within the request's own
+// deadline, so a slow Redis costs a read a little latency rather than
+// failing it.
+const redisCacheTimeout = 500 * time.Millisecond
+
+type cachedList struct {
+	Todos []todoModel `bson:"todos"`
+}
+
+func (rc *redisCache) failed(op string, err error) {
+	cacheStats.Add("redis.errors", 1)
+	log.Printf("Redis cache %s failed: %v", op, err)
+}
+
+func (rc *redisCache) generation(ctx context.Context) uint64 {
+	gen, _ := rc.currentGeneration(ctx)
+	return gen
+}
+
+// currentGeneration reports false when Redis can't be reached, in which case
+// nothing should be read from or written to the cache.
+func (rc *redisCache) currentGeneration(ctx context.Context) (uint64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	v, err := rc.client.Get(ctx, rc.prefix+"gen").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		rc.failed("generation", err)
+		return 0, false
+	}
+	gen, _ := strconv.ParseUint(v, 10, 64)
+	return gen, true
+}
+
+func (rc *redisCache) todo(ctx context.Context, id primitive.ObjectID) (todoModel, bool) {
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	var t todoModel
+	v, err := rc.client.Get(ctx, rc.prefix+"todo:"+id.Hex()).Bytes()
+	if err == nil {
+		err = bson.Unmarshal(v, &t)
+	}
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			rc.failed("read", err)
+		}
+		cacheStats.Add("todos.misses", 1)
+		return t, false
+	}
+	cacheStats.Add("todos.hits", 1)
+	return t, true
+}
+
+// putTodo skips the write if the generation moved on. The check and the
+// write aren't atomic, so a write racing the check can leave a stale entry
+// for up to the TTL.
+func (rc *redisCache) putTodo(ctx context.Context, t todoModel, gen uint64) {
+	if current, ok := rc.currentGeneration(ctx); !ok || current != gen {
+		return
+	}
+	data, err := bson.Marshal(t)
+	if err != nil {
+		rc.failed("write", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	if err := rc.client.Set(ctx, rc.prefix+"todo:"+t.ID.Hex(), data, rc.ttl).Err(); err != nil {
+		rc.failed("write", err)
+	}
+}
+
+func (rc *redisCache) listKey(key string, gen uint64) string {
+	return rc.prefix + "lists:" + strconv.FormatUint(gen, 10) + ":" + key
+}
+
+func (rc *redisCache) list(ctx context.Context, key string) ([]todoModel, bool) {
+	gen, ok := rc.currentGeneration(ctx)
+	if !ok {
+		cacheStats.Add("lists.misses", 1)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	var l cachedList
+	v, err := rc.client.Get(ctx, rc.listKey(key, gen)).Bytes()
+	if err == nil {
+		err = bson.Unmarshal(v, &l)
+	}
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			rc.failed("read", err)
+		}
+		cacheStats.Add("lists.misses", 1)
+		return nil, false
+	}
+	cacheStats.Add("lists.hits", 1)
+	return l.Todos, true
+}
+
+// putList stores the list under the generation it was read in, where no
+// reader will look once that generation has been invalidated.
+func (rc *redisCache) putList(ctx context.Context, key string, todos []todoModel, gen uint64) {
+	data, err := bson.Marshal(cachedList{Todos: todos})
+	if err != nil {
+		rc.failed("write", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	if err := rc.client.Set(ctx, rc.listKey(key, gen), data, rc.ttl).Err(); err != nil {
+		rc.failed("write", err)
+	}
+}
+
+func (rc *redisCache) invalidateTodo(ctx context.Context, id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	if err := rc.client.Incr(ctx, rc.prefix+"gen").Err(); err != nil {
+		rc.failed("invalidate", err)
+	}
+	if err := rc.client.Del(ctx, rc.prefix+"todo:"+id.Hex()).Err(); err != nil {
+		rc.failed("invalidate", err)
+	}
+}
+
+func (rc *redisCache) invalidateLists(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, redisCacheTimeout)
+	defer cancel()
+
+	if err := rc.client.Incr(ctx, rc.prefix+"gen").Err(); err != nil {
+		rc.failed("invalidate", err)
+	}
+}
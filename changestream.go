@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeStreams turns on watching the database for changes when MongoDB is
+// a replica set or a sharded cluster, which are the deployments that have
+// change streams. Standalone servers keep using the events each instance
+// publishes itself.
+var changeStreams = envBool("CHANGE_STREAMS", true)
+
+// changeDoc is the part of a change stream event that is read.
+type changeDoc struct {
+	OperationType string `bson:"operationType"`
+	NS            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// supportsChangeStreams asks the server whether it is a replica set member
+// or a mongos.
+func supportsChangeStreams(ctx context.Context, db *mongo.Database) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// watchChanges broadcasts the changes to todos, however they were made, from
+// a change stream until ctx is done. Writes to the todos collection become
+// todo.created and todo.updated events and new tombstones todo.deleted
+// ones. Once the stream is open the bus stops publishing todo events of its
+// own, so they aren't delivered twice. It returns at once when change
+// streams are off or the server doesn't have them.
+func (s *todoService) watchChanges(ctx context.Context) {
+	if !changeStreams {
+		return
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ok, err := supportsChangeStreams(checkCtx, s.todos.Database())
+	cancel()
+	if err != nil {
+		log.Printf("Checking for change streams failed, using in-process events: %v", err)
+		return
+	}
+	if !ok {
+		log.Println("MongoDB is standalone, using in-process events")
+		return
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"ns.coll":       bson.M{"$in": bson.A{s.todos.Name(), s.tombstones.Name()}},
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}},
+	}}}}
+
+	var resumeToken bson.Raw
+	backoff := time.Second
+	const maxBackoff = time.Minute
+	for {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := s.todos.Database().Watch(ctx, pipeline, opts)
+		if err == nil {
+			if !s.events.streaming.Swap(true) {
+				log.Println("Broadcasting todo changes from a MongoDB change stream")
+			}
+			backoff = time.Second
+			for stream.Next(ctx) {
+				resumeToken = stream.ResumeToken()
+				var ch changeDoc
+				if err := stream.Decode(&ch); err != nil {
+					log.Printf("Ignoring malformed change: %v", err)
+					continue
+				}
+				if ev, ok := s.eventFromChange(ch); ok {
+					s.events.receive(ev)
+				}
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if resumeToken != nil && isResumeError(err) {
+			// The change the token points at has left the oplog.
+			log.Printf("Change stream can't resume, changes were missed: %v", err)
+			resumeToken = nil
+		}
+		log.Printf("Change stream failed, reopening in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// isResumeError reports whether err says the stream can't be resumed from
+// its token.
+func isResumeError(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	// ChangeStreamHistoryLost
+	return se.HasErrorCode(286)
+}
+
+// eventFromChange makes the event for a change to the todos or tombstones
+// collection. Updates to todos deleted since carry no document and are
+// dropped.
+func (s *todoService) eventFromChange(ch changeDoc) (todoEvent, bool) {
+	if ch.FullDocument == nil {
+		return todoEvent{}, false
+	}
+	switch ch.NS.Coll {
+	case s.todos.Name():
+		var t todoModel
+		if err := bson.Unmarshal(ch.FullDocument, &t); err != nil {
+			return todoEvent{}, false
+		}
+		typ := eventTodoUpdated
+		if ch.OperationType == "insert" {
+			typ = eventTodoCreated
+		}
+		return newTodoEvent(typ, t), true
+	case s.tombstones.Name():
+		var tomb tombstoneModel
+		if err := bson.Unmarshal(ch.FullDocument, &tomb); err != nil {
+			return todoEvent{}, false
+		}
+		return newTodoEvent(eventTodoDeleted, todoModel{
+			ID:          tomb.ID,
+			ListID:      tomb.ListID,
+			OwnerID:     tomb.OwnerID,
+			WorkspaceID: tomb.WorkspaceID,
+		}), true
+	}
+	return todoEvent{}, false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEventFromChange(t *testing.T) {
+	unreachableService(t)
+
+	id, listID := primitive.NewObjectID(), primitive.NewObjectID()
+	change := func(op, coll string, doc interface{}) changeDoc {
+		var ch changeDoc
+		ch.OperationType = op
+		ch.NS.Coll = coll
+		if doc != nil {
+			raw, err := bson.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch.FullDocument = raw
+		}
+		return ch
+	}
+	todoDoc := todoModel{ID: id, Title: "x", OwnerID: "ann", WorkspaceID: "acme"}
+	tombDoc := tombstoneModel{ID: id, ListID: &listID}
+
+	tests := []struct {
+		ch   changeDoc
+		want todoEvent
+		ok   bool
+	}{
+		{change("insert", svc.todos.Name(), todoDoc), todoEvent{Type: eventTodoCreated, TodoID: id.Hex(), OwnerID: "ann", WorkspaceID: "acme"}, true},
+		{change("update", svc.todos.Name(), todoDoc), todoEvent{Type: eventTodoUpdated, TodoID: id.Hex(), OwnerID: "ann", WorkspaceID: "acme"}, true},
+		{change("replace", svc.tombstones.Name(), tombDoc), todoEvent{Type: eventTodoDeleted, TodoID: id.Hex(), ListID: listID.Hex()}, true},
+		{change("update", svc.todos.Name(), nil), todoEvent{}, false},
+		{change("insert", "history", todoDoc), todoEvent{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := svc.eventFromChange(tt.ch)
+		got.At = tt.want.At
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%s on %s = %+v, %v; want %+v, %v", tt.ch.OperationType, tt.ch.NS.Coll, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStreamingBusSkipsTodoEvents(t *testing.T) {
+	b := newEventBus(nil)
+	events, unsubscribe := b.subscribe()
+	defer unsubscribe()
+
+	b.streaming.Store(true)
+	b.publish(todoEvent{Type: eventTodoUpdated})
+	b.publish(todoEvent{Type: eventListMembers})
+	b.receive(todoEvent{Type: eventTodoDeleted})
+
+	for _, want := range []string{eventListMembers, eventTodoDeleted} {
+		if ev := <-events; ev.Type != want {
+			t.Errorf("got %s, want %s", ev.Type, want)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected %s", ev.Type)
+	default:
+	}
+}
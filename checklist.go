@@ -0,0 +1,71 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// checklistItemRe matches a Markdown task list item, "- [ ] step" or
+// "1. [x] step", capturing the box.
+var checklistItemRe = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+\[([ xX])\](?:\s|$)`)
+
+// checklistProgress is how far along the checklist in a todo's
+// description is.
+type checklistProgress struct {
+	Done    int `json:"done"`
+	Total   int `json:"total"`
+	Percent int `json:"percent"`
+}
+
+// progressOf counts the checked and unchecked task list items in a
+// description, outside code blocks. It returns nil when there are none, so
+// todos without a checklist have no progress.
+func progressOf(description string) *checklistProgress {
+	var p checklistProgress
+	inCode := false
+	for _, line := range strings.Split(description, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		if m := checklistItemRe.FindStringSubmatch(trimmed); m != nil {
+			p.Total++
+			if m[1] != " " {
+				p.Done++
+			}
+		}
+	}
+	if p.Total == 0 {
+		return nil
+	}
+	p.Percent = p.Done * 100 / p.Total
+	return &p
+}
+
+// resetChecklist unchecks the task list items in a description, outside
+// code blocks, leaving the rest of it as it is.
+func resetChecklist(description string) string {
+	lines := strings.Split(description, "\n")
+	inCode := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		m := checklistItemRe.FindStringSubmatchIndex(trimmed)
+		if m == nil || trimmed[m[2]] == ' ' {
+			continue
+		}
+		box := strings.Index(line, trimmed) + m[2]
+		lines[i] = line[:box] + " " + line[box+1:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestProgressOf(t *testing.T) {
+	tests := []struct {
+		description string
+		want        *checklistProgress
+	}{
+		{"", nil},
+		{"Just some notes\n- a plain item", nil},
+		{"- [x] Buy paint\n- [ ] Tape the edges\n* [X] Move the sofa\n1. [ ] Paint\n2) [ ]", &checklistProgress{Done: 2, Total: 5, Percent: 40}},
+		{"  - [x] indented\n-[x] not an item\n- [y] not a box", &checklistProgress{Done: 1, Total: 1, Percent: 100}},
+		{"```\n- [ ] in code\n```\n- [ ] outside", &checklistProgress{Done: 0, Total: 1, Percent: 0}},
+	}
+	for _, tt := range tests {
+		got := progressOf(tt.description)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("progressOf(%q) = %+v, want %+v", tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestResetChecklist(t *testing.T) {
+	in := "Steps:\n- [x] Buy paint\n  * [X] Tape [x] edges\n1. [ ] Paint\n```\n- [x] in code\n```"
+	want := "Steps:\n- [ ] Buy paint\n  * [ ] Tape [x] edges\n1. [ ] Paint\n```\n- [x] in code\n```"
+	if got := resetChecklist(in); got != want {
+		t.Errorf("resetChecklist = %q, want %q", got, want)
+	}
+}
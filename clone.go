@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cloneRequest is the optional body of POST /todo/{id}/clone. A nil ListID
+// keeps the copy in the original's list; an empty one puts it in no list.
+type cloneRequest struct {
+	ListID *string `json:"list_id"`
+}
+
+// clone copies a todo c can see into a new todo of c's: its title,
+// description, tags and priority, with the description's checklist
+// unchecked. The copy is open, unstarred and has no due date. It goes in
+// the original's list when sameList is set, or else in listID, nil for none.
+func (s *todoService) clone(ctx context.Context, c caller, id primitive.ObjectID, sameList bool, listID *primitive.ObjectID) (todoModel, error) {
+	src, err := s.getForAccess(ctx, c, id, false)
+	if err != nil {
+		return todoModel{}, err
+	}
+	if sameList {
+		listID = src.ListID
+	}
+
+	now := time.Now()
+	tm := todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       src.Title,
+		Description: resetChecklist(src.Description),
+		Tags:        src.Tags,
+		Priority:    src.Priority,
+		ListID:      listID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.create(ctx, c, tm); err != nil {
+		return todoModel{}, err
+	}
+	tm.OwnerID = c.userID
+	tm.WorkspaceID = c.workspace
+	return tm, nil
+}
+
+// cloneTodo answers POST /todo/{id}/clone with the new todo.
+func cloneTodo(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	var req cloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to clone todo",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	var listID *primitive.ObjectID
+	if req.ListID != nil && *req.ListID != "" {
+		id, err := primitive.ObjectIDFromHex(*req.ListID)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, errorResponse{
+				Message: "Failed to clone todo",
+				Error:   "Invalid list_id",
+			})
+			return
+		}
+		listID = &id
+	}
+
+	ctx := r.Context()
+	c := requestCaller(r)
+
+	tm, err := svc.clone(ctx, c, objID, req.ListID == nil, listID)
+	if quotaExceeded(w, "Failed to clone todo", err) {
+		return
+	}
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if errors.Is(err, errListNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "List not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to clone todo",
+			Error:   "Viewers cannot add todos to this list",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to clone todo",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, todoResponse{
+		Message: "Todo cloned",
+		Data:    newTodo(tm, c.location()),
+	})
+}
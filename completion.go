@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setCompleted completes or reopens a todo, stamping completed_at when it is
+// completed. Completing a todo that is already completed keeps the time it
+// was completed at.
+func (s *todoService) setCompleted(ctx context.Context, c caller, id primitive.ObjectID, completed bool) (todoModel, error) {
+	current, err := s.getForAccess(ctx, c, id, true)
+	if err != nil || current.Completed == completed {
+		return current, err
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"completed": completed, "updated_at": now}}
+	if completed {
+		update["$set"].(bson.M)["completed_at"] = now
+	} else {
+		update["$unset"] = bson.M{"completed_at": ""}
+	}
+
+	var before todoModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = s.todos.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return current, errTodoNotFound
+	}
+	if err != nil {
+		return current, err
+	}
+
+	after := before
+	after.Completed = completed
+	after.UpdatedAt = now
+	after.CompletedAt = nil
+	if completed {
+		after.CompletedAt = &now
+	}
+	s.changed(ctx, eventTodoUpdated, after)
+	return after, s.recordHistory(ctx, id, actionUpdate, c.actor(), &before, diffTodos(&before, &after))
+}
+
+func completeTodo(w http.ResponseWriter, r *http.Request) {
+	setTodoCompleted(w, r, true)
+}
+
+func uncompleteTodo(w http.ResponseWriter, r *http.Request) {
+	setTodoCompleted(w, r, false)
+}
+
+// setTodoCompleted answers POST /todo/{id}/complete and /uncomplete with
+// the todo as it now is.
+func setTodoCompleted(w http.ResponseWriter, r *http.Request, completed bool) {
+	failed, done := "Failed to complete todo", "Todo completed"
+	if !completed {
+		failed, done = "Failed to reopen todo", "Todo reopened"
+	}
+
+	objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	c := requestCaller(r)
+
+	tm, err := svc.setCompleted(ctx, c, objID, completed)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: failed,
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: failed,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, todoResponse{
+		Message: done,
+		Data:    newTodo(tm, c.location()),
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat(`{"title":"Buy milk"},`, 200)
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			io.WriteString(w, big)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			io.WriteString(w, `{}`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, big)
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, big)
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	tests := []struct {
+		path, acceptEncoding string
+		wantEncoding         string
+	}{
+		{"/json", "gzip, deflate, br", "gzip"},
+		{"/json", "deflate", "deflate"},
+		{"/json", "gzip;q=0, deflate", "deflate"},
+		{"/json", "identity", ""},
+		{"/json", "", ""},
+		{"/small", "gzip", ""},
+		{"/image", "gzip", ""},
+		{"/events", "gzip", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+			t.Errorf("%s (Accept-Encoding %q): Content-Encoding = %q, want %q", tt.path, tt.acceptEncoding, got, tt.wantEncoding)
+			continue
+		}
+		if !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+			t.Errorf("%s: Vary = %q", tt.path, rec.Header().Get("Vary"))
+		}
+
+		var body io.Reader = rec.Body
+		switch tt.wantEncoding {
+		case "gzip":
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		case "deflate":
+			zr, err := zlib.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%s (Accept-Encoding %q): %v", tt.path, tt.acceptEncoding, err)
+		}
+		if tt.path != "/small" && string(b) != big {
+			t.Errorf("%s (Accept-Encoding %q): body changed", tt.path, tt.acceptEncoding)
+		}
+	}
+}
+
+func TestCompressKeepsStatus(t *testing.T) {
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, strings.Repeat("x", 2*compressMinSize), http.StatusTeapot)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTeapot || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("status %d, Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configErrors collects the settings that couldn't be used. The server
+// falls back to their defaults and starts anyway, but -check fails.
+var configErrors []error
+
+// invalidSetting logs that setting is ignored and records it in
+// configErrors.
+func invalidSetting(setting string, err error) {
+	log.Printf("Ignoring invalid %s: %v", setting, err)
+	configErrors = append(configErrors, fmt.Errorf("invalid %s: %w", setting, err))
+}
+
+// envString returns the value of the environment variable key, or fallback
+// when it is unset or empty.
+func envString(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt64(key string, fallback int64) int64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		invalidSetting(fmt.Sprintf("%s=%q", key, v), err)
+		return fallback
+	}
+	return n
+}
+
+func envBool(key string, fallback bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalidSetting(fmt.Sprintf("%s=%q", key, v), err)
+		return fallback
+	}
+	return b
+}
+
+// envList splits a comma separated environment variable into its trimmed,
+// non-empty elements.
+func envList(key string, fallback []string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envDuration parses a Go duration such as "500ms" or "30s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		invalidSetting(fmt.Sprintf("%s=%q", key, v), err)
+		return fallback
+	}
+	return d
+}
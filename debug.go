@@ -0,0 +1,69 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// poolStats tracks the MongoDB connection pool: connections open, checked
+// out by an operation, and failed check-outs.
+var poolStats = expvar.NewMap("mongo_pool")
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("build", expvar.Func(buildInfo))
+}
+
+// buildInfo reports what fetchVersion does.
+func buildInfo() interface{} {
+	return currentVersion()
+}
+
+func poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				poolStats.Add("open", 1)
+			case event.ConnectionClosed:
+				poolStats.Add("open", -1)
+			case event.GetSucceeded:
+				poolStats.Add("in_use", 1)
+			case event.ConnectionReturned:
+				poolStats.Add("in_use", -1)
+			case event.GetFailed:
+				poolStats.Add("checkout_failures", 1)
+			}
+		},
+	}
+}
+
+// debugRoutes serves the expvars and the pprof profiles to admins. They
+// are only mounted when DEBUG is on.
+func debugRoutes(r chi.Router) {
+	r.Use(requireAdmin)
+	r.Handle("/vars", expvar.Handler())
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/profile", untimed(pprof.Profile))
+	r.HandleFunc("/pprof/trace", untimed(pprof.Trace))
+	// Index also serves the named profiles, e.g. /debug/pprof/heap.
+	r.HandleFunc("/pprof/*", pprof.Index)
+}
+
+// untimed lifts the server's write timeout for handlers that take as long
+// as they are asked to, like a 30 second CPU profile.
+func untimed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		h(w, r)
+	}
+}
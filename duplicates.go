@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DUPLICATE_TODOS decides what happens when a todo is created with the same
+// title, ignoring case, as one of the caller's open todos: "reject" refuses
+// it, "warn" creates it and says so in the response, and "allow" doesn't
+// check. Passing ?allow_duplicate=true skips the check for one request.
+var duplicateTodos = envString("DUPLICATE_TODOS", "warn")
+
+const (
+	duplicatesReject = "reject"
+	duplicatesWarn   = "warn"
+	duplicatesAllow  = "allow"
+)
+
+// duplicateResponse refuses a todo with the same title as an open one.
+type duplicateResponse struct {
+	errorResponse
+	DuplicateOf string `json:"duplicate_of"`
+}
+
+// duplicateOf returns the id of an open todo of c's titled title, ignoring
+// case, if there is one.
+func (s *todoService) duplicateOf(ctx context.Context, c caller, title string) (primitive.ObjectID, bool, error) {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	var t struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOne().
+		SetCollation(titleCollation).
+		SetProjection(bson.M{"_id": 1})
+	err := s.todos.FindOne(ctx, c.scoped(bson.M{
+		"owner_id":  c.userID,
+		"title":     title,
+		"completed": false,
+	}), opts).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return t.ID, false, nil
+	}
+	if err != nil {
+		return t.ID, false, err
+	}
+	return t.ID, true, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	eventTodoCreated  = "todo.created"
+	eventTodoUpdated  = "todo.updated"
+	eventTodoDeleted  = "todo.deleted"
+	eventTodoAssigned = "todo.assigned"
+	eventListMembers  = "list.members"
+	// eventAccountDeleted tells a user their account has been erased.
+	eventAccountDeleted = "account.deleted"
+	eventsChannelName   = "events"
+)
+
+// todoEvent tells subscribers that something changed. It only says what
+// changed, not how, so subscribers that care fetch the todo again through
+// the usual access checks.
+type todoEvent struct {
+	Type        string    `json:"type"`
+	TodoID      string    `json:"todo_id,omitempty"`
+	ListID      string    `json:"list_id,omitempty"`
+	OwnerID     string    `json:"owner_id,omitempty"`
+	AssigneeID  string    `json:"assignee_id,omitempty"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+func newTodoEvent(typ string, t todoModel) todoEvent {
+	ev := todoEvent{Type: typ, TodoID: t.ID.Hex(), OwnerID: t.OwnerID, AssigneeID: t.AssigneeID, WorkspaceID: t.WorkspaceID, At: time.Now()}
+	if t.ListID != nil {
+		ev.ListID = t.ListID.Hex()
+	}
+	return ev
+}
+
+// eventBus fans todo events out to the subscribers in this instance. With
+// Redis configured, events are published over Redis pub/sub instead and
+// every instance, this one included, delivers what it receives, so a
+// subscriber sees changes made through any instance. When a MongoDB change
+// stream is open, todo events come from it instead (see watchChanges).
+type eventBus struct {
+	redis   *redis.Client
+	channel string
+	origin  string // tells this instance's events apart from the others'
+
+	// streaming is set once a change stream delivers the todo events.
+	streaming atomic.Bool
+
+	mu   sync.Mutex
+	subs map[chan todoEvent]struct{}
+
+	// remote is called for events published by other instances.
+	remote func(todoEvent)
+}
+
+// busMessage is what goes over Redis.
+type busMessage struct {
+	Origin string    `json:"origin"`
+	Event  todoEvent `json:"event"`
+}
+
+func newEventBus(rdb *redis.Client) *eventBus {
+	return &eventBus{
+		redis:   rdb,
+		channel: envString("REDIS_PREFIX", "todo-go:") + eventsChannelName,
+		origin:  primitive.NewObjectID().Hex(),
+		subs:    map[chan todoEvent]struct{}{},
+	}
+}
+
+// run receives events from Redis until ctx is done. It returns at once when
+// Redis isn't configured.
+func (b *eventBus) run(ctx context.Context) {
+	if b.redis == nil {
+		return
+	}
+	redisSubscribe(ctx, b.redis, b.channel, func(payload string) {
+		var msg busMessage
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			log.Printf("Ignoring malformed event: %v", err)
+			return
+		}
+		if msg.Origin != b.origin && b.remote != nil {
+			b.remote(msg.Event)
+		}
+		b.deliver(msg.Event)
+	})
+}
+
+func (b *eventBus) publish(ev todoEvent) {
+	if b.streaming.Load() && isTodoEvent(ev) {
+		return
+	}
+	if b.redis == nil {
+		b.deliver(ev)
+		return
+	}
+
+	payload, err := json.Marshal(busMessage{Origin: b.origin, Event: ev})
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err = b.redis.Publish(ctx, b.channel, payload).Err()
+		cancel()
+	}
+	if err != nil {
+		// Other instances miss this one, but local subscribers needn't.
+		log.Printf("Failed to publish event: %v", err)
+		b.deliver(ev)
+	}
+}
+
+// receive delivers an event from the change stream, which every instance
+// watches, so it also stands for changes made elsewhere.
+func (b *eventBus) receive(ev todoEvent) {
+	if b.remote != nil {
+		b.remote(ev)
+	}
+	b.deliver(ev)
+}
+
+func isTodoEvent(ev todoEvent) bool {
+	switch ev.Type {
+	case eventTodoCreated, eventTodoUpdated, eventTodoDeleted:
+		return true
+	}
+	return false
+}
+
+// deliver hands ev to the local subscribers. A subscriber that isn't keeping
+// up misses events rather than holding up everyone else.
+func (b *eventBus) deliver(ev todoEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (b *eventBus) subscribe() (<-chan todoEvent, func()) {
+	ch := make(chan todoEvent, 64)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// canSee reports whether c may read the todo or list an event is about.
+// A todo.assigned event only goes to the assignee.
+func (s *todoService) canSee(ctx context.Context, c caller, ev todoEvent) bool {
+	if ev.Type == eventTodoAssigned && ev.AssigneeID != c.userID {
+		return false
+	}
+	var t todoModel
+	t.OwnerID = ev.OwnerID
+	t.WorkspaceID = ev.WorkspaceID
+	if ev.ListID != "" {
+		listID, err := primitive.ObjectIDFromHex(ev.ListID)
+		if err != nil {
+			return false
+		}
+		t.ListID = &listID
+	}
+	return s.authorize(ctx, c, t, false) == nil
+}
+
+// streamEvents sends the changes the caller can see as server-sent events
+// until the client goes away.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := svc.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	c := requestCaller(r)
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-events:
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			visible := svc.canSee(ctx, c, ev)
+			cancel()
+			if !visible {
+				continue
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const featureFlagsCollName = "feature_flags"
+
+// featureFlagsRefresh is how often flags changed through another instance
+// are picked up.
+var featureFlagsRefresh = envDuration("FEATURE_FLAGS_REFRESH", 30*time.Second)
+
+var features *featureFlags
+
+var (
+	errInvalidFlagName = errors.New("Flag names are 1 to 64 lowercase letters, digits, '-' or '_'")
+	errInvalidPercent  = errors.New("Percent must be between 0 and 100")
+	flagNamePattern    = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+)
+
+type (
+	// featureFlagModel turns a feature on for everyone, for the listed
+	// users, or for a share of signed-in users picked by hashing their ID,
+	// so each user keeps getting the same answer.
+	featureFlagModel struct {
+		Name      string    `bson:"_id"`
+		Enabled   bool      `bson:"enabled"`
+		Users     []string  `bson:"users,omitempty"`
+		Percent   int       `bson:"percent,omitempty"`
+		UpdatedAt time.Time `bson:"updated_at"`
+	}
+
+	featureFlag struct {
+		Name      string   `json:"name"`
+		Enabled   bool     `json:"enabled"`
+		Users     []string `json:"users,omitempty"`
+		Percent   int      `json:"percent,omitempty"`
+		Source    string   `json:"source"` // "config" or "database"
+		UpdatedAt string   `json:"updated_at,omitempty"`
+	}
+)
+
+// featureFlags evaluates flags from FEATURE_FLAGS and the feature_flags
+// collection. A flag in the collection replaces one of the same name from
+// the config, so the config sets the defaults for an environment and
+// admins can change them at runtime.
+type featureFlags struct {
+	coll   *mongo.Collection
+	config map[string]featureFlagModel
+
+	mu     sync.RWMutex
+	stored map[string]featureFlagModel
+}
+
+// newFeatureFlags reads FEATURE_FLAGS, a comma separated list of flags
+// that are either on for everyone ("search"), on for some users
+// ("search=ann|bob") or on for a share of users ("search=10%").
+func newFeatureFlags(db *mongo.Database) (*featureFlags, error) {
+	f := &featureFlags{
+		coll:   db.Collection(featureFlagsCollName),
+		config: map[string]featureFlagModel{},
+		stored: map[string]featureFlagModel{},
+	}
+	for _, entry := range envList("FEATURE_FLAGS", nil) {
+		flag, err := parseFlagConfig(entry)
+		if err != nil {
+			return nil, fmt.Errorf("FEATURE_FLAGS: %w", err)
+		}
+		f.config[flag.Name] = flag
+	}
+	return f, nil
+}
+
+func parseFlagConfig(entry string) (featureFlagModel, error) {
+	name, rule, hasRule := strings.Cut(entry, "=")
+	flag := featureFlagModel{Name: strings.TrimSpace(name)}
+	if !flagNamePattern.MatchString(flag.Name) {
+		return flag, fmt.Errorf("%q: %w", entry, errInvalidFlagName)
+	}
+
+	rule = strings.TrimSpace(rule)
+	switch {
+	case !hasRule:
+		flag.Enabled = true
+	case strings.HasSuffix(rule, "%"):
+		p, err := strconv.Atoi(strings.TrimSuffix(rule, "%"))
+		if err != nil || p < 0 || p > 100 {
+			return flag, fmt.Errorf("%q: %w", entry, errInvalidPercent)
+		}
+		flag.Percent = p
+	default:
+		for _, u := range strings.Split(rule, "|") {
+			if u = strings.TrimSpace(u); u != "" {
+				flag.Users = append(flag.Users, u)
+			}
+		}
+	}
+	return flag, nil
+}
+
+// on reports whether the flag is on for c.
+func (m featureFlagModel) on(c caller) bool {
+	if m.Enabled {
+		return true
+	}
+	if c.userID == "" {
+		return false
+	}
+	for _, u := range m.Users {
+		if u == c.userID {
+			return true
+		}
+	}
+	if m.Percent > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(m.Name + ":" + c.userID))
+		return int(h.Sum32()%100) < m.Percent
+	}
+	return false
+}
+
+func (f *featureFlags) lookup(name string) (featureFlagModel, bool) {
+	f.mu.RLock()
+	flag, ok := f.stored[name]
+	f.mu.RUnlock()
+	if ok {
+		return flag, true
+	}
+	flag, ok = f.config[name]
+	return flag, ok
+}
+
+// enabled reports whether the named feature is on for c. Unknown flags are
+// off.
+func (f *featureFlags) enabled(name string, c caller) bool {
+	flag, ok := f.lookup(name)
+	return ok && flag.on(c)
+}
+
+// enabledFor lists the features that are on for c.
+func (f *featureFlags) enabledFor(c caller) []string {
+	names := []string{}
+	for _, flag := range f.all() {
+		if flag.on(c) {
+			names = append(names, flag.Name)
+		}
+	}
+	return names
+}
+
+// all returns every flag in effect, by name.
+func (f *featureFlags) all() []featureFlagModel {
+	merged := make(map[string]featureFlagModel, len(f.config))
+	for name, flag := range f.config {
+		merged[name] = flag
+	}
+	f.mu.RLock()
+	for name, flag := range f.stored {
+		merged[name] = flag
+	}
+	f.mu.RUnlock()
+
+	flags := make([]featureFlagModel, 0, len(merged))
+	for _, flag := range merged {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+func (f *featureFlags) isStored(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	_, ok := f.stored[name]
+	return ok
+}
+
+// refresh reloads the flags stored in the collection.
+func (f *featureFlags) refresh(ctx context.Context) error {
+	cursor, err := f.coll.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var flags []featureFlagModel
+	if err := cursor.All(ctx, &flags); err != nil {
+		return err
+	}
+
+	stored := make(map[string]featureFlagModel, len(flags))
+	for _, flag := range flags {
+		stored[flag.Name] = flag
+	}
+	f.mu.Lock()
+	f.stored = stored
+	f.mu.Unlock()
+	return nil
+}
+
+// run refreshes the stored flags now and then every featureFlagsRefresh
+// until ctx is done.
+func (f *featureFlags) run(ctx context.Context) {
+	interval := featureFlagsRefresh
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := f.refresh(refreshCtx); err != nil {
+			log.Printf("Feature flag refresh failed: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// set stores a flag. It takes effect on this instance at once and on the
+// others at their next refresh.
+func (f *featureFlags) set(ctx context.Context, flag featureFlagModel) error {
+	flag.UpdatedAt = time.Now()
+	_, err := f.coll.ReplaceOne(ctx, bson.M{"_id": flag.Name}, flag, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.stored[flag.Name] = flag
+	f.mu.Unlock()
+	return nil
+}
+
+// remove deletes a stored flag, which puts back the one from the config,
+// if any.
+func (f *featureFlags) remove(ctx context.Context, name string) (bool, error) {
+	res, err := f.coll.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	delete(f.stored, name)
+	f.mu.Unlock()
+	return res.DeletedCount > 0, nil
+}
+
+func (f *featureFlags) newFeatureFlag(m featureFlagModel) featureFlag {
+	flag := featureFlag{
+		Name:    m.Name,
+		Enabled: m.Enabled,
+		Users:   m.Users,
+		Percent: m.Percent,
+		Source:  "config",
+	}
+	if f.isStored(m.Name) {
+		flag.Source = "database"
+		flag.UpdatedAt = m.UpdatedAt.Format(time.RFC3339)
+	}
+	return flag
+}
+
+// requireFeature answers 404 when the named feature is off for the caller,
+// as if the route didn't exist. New risky features are mounted behind it:
+//
+//	r.With(requireFeature("search")).Get("/search", searchTodos)
+func requireFeature(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !features.enabled(name, requestCaller(r)) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fetchFeatures lists the features that are on for the caller, so clients
+// can show or hide what depends on them.
+func fetchFeatures(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, listResponse[string]{
+		Data: features.enabledFor(requestCaller(r)),
+	})
+}
+
+func fetchFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	all := features.all()
+	flags := make([]featureFlag, 0, len(all))
+	for _, m := range all {
+		flags = append(flags, features.newFeatureFlag(m))
+	}
+	respond(w, r, http.StatusOK, listResponse[featureFlag]{
+		Data: flags,
+	})
+}
+
+func putFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(chi.URLParam(r, "name"))
+	if !flagNamePattern.MatchString(name) {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to save feature flag",
+			Error:   errInvalidFlagName.Error(),
+		})
+		return
+	}
+
+	var req featureFlag
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to save feature flag",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to save feature flag",
+			Error:   errInvalidPercent.Error(),
+		})
+		return
+	}
+
+	m := featureFlagModel{Name: name, Enabled: req.Enabled, Percent: req.Percent}
+	for _, u := range req.Users {
+		if u = strings.TrimSpace(u); u != "" {
+			m.Users = append(m.Users, u)
+		}
+	}
+
+	ctx := r.Context()
+
+	if err := features.set(ctx, m); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to save feature flag",
+			Error:   err.Error(),
+		})
+		return
+	}
+	m, _ = features.lookup(name)
+	rnd.JSON(w, http.StatusOK, itemResponse[featureFlag]{
+		Message: "Feature flag saved",
+		Data:    features.newFeatureFlag(m),
+	})
+}
+
+func deleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	found, err := features.remove(ctx, chi.URLParam(r, "name"))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to delete feature flag",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !found {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Feature flag not found",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "Feature flag deleted",
+	})
+}
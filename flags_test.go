@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeatureFlagConfig(t *testing.T) {
+	tests := []struct {
+		entry string
+		user  string
+		want  bool
+	}{
+		{"search", "", true},
+		{"search", "ann", true},
+		{"search=ann|bob", "bob", true},
+		{"search=ann|bob", "carol", false},
+		{"search=ann|bob", "", false},
+		{"search=100%", "carol", true},
+		{"search=100%", "", false},
+		{"search=0%", "carol", false},
+	}
+	for _, tt := range tests {
+		flag, err := parseFlagConfig(tt.entry)
+		if err != nil {
+			t.Fatalf("parseFlagConfig(%q): %v", tt.entry, err)
+		}
+		if got := flag.on(caller{userID: tt.user}); got != tt.want {
+			t.Errorf("%s for %q = %v, want %v", tt.entry, tt.user, got, tt.want)
+		}
+	}
+
+	for _, entry := range []string{"Search", "search=150%", "search=x%", ""} {
+		if _, err := parseFlagConfig(entry); err == nil {
+			t.Errorf("parseFlagConfig(%q) succeeded", entry)
+		}
+	}
+}
+
+func TestFeatureFlagPercentIsStable(t *testing.T) {
+	flag := featureFlagModel{Name: "search", Percent: 30}
+	on := 0
+	for i := 0; i < 1000; i++ {
+		c := caller{userID: "user" + string(rune('a'+i%26)) + string(rune('a'+i/26))}
+		first := flag.on(c)
+		if flag.on(c) != first {
+			t.Fatalf("flag flipped for %s", c.userID)
+		}
+		if first {
+			on++
+		}
+	}
+	if on < 200 || on > 400 {
+		t.Errorf("30%% flag on for %d of 1000 users", on)
+	}
+}
+
+func TestRequireFeature(t *testing.T) {
+	old := features
+	features = &featureFlags{
+		config: map[string]featureFlagModel{"beta": {Name: "beta", Users: []string{"ann"}}},
+		stored: map[string]featureFlagModel{},
+	}
+	t.Cleanup(func() { features = old })
+
+	h := identify(requireFeature("beta")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for user, want := range map[string]int{"ann": http.StatusOK, "bob": http.StatusNotFound, "": http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(authUserHeader, user)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%q: status %d, want %d", user, rec.Code, want)
+		}
+	}
+
+	rec := serve(t, http.MethodGet, "/features", "ann", "")
+	var resp listResponse[string]
+	decodeBody(t, rec, &resp)
+	if len(resp.Data) != 1 || resp.Data[0] != "beta" {
+		t.Errorf("/features for ann = %s", rec.Body)
+	}
+}
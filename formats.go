@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// encoder writes a response body in one format.
+type encoder struct {
+	contentType string
+	mediaTypes  []string // the Accept types it answers
+	encode      func(w io.Writer, v interface{}) error
+
+	// document, if set, builds what is encoded from the response and the
+	// request, for formats that add to the response, such as links.
+	document func(r *http.Request, v interface{}) (interface{}, error)
+}
+
+// encoders are the formats read endpoints answer in, by the name ?format=
+// takes. Adding one here is all it takes to serve another format.
+var encoders = map[string]encoder{
+	"json": {
+		contentType: "application/json; charset=utf-8",
+		mediaTypes:  []string{"application/json"},
+		encode:      encodeJSON,
+	},
+	"xml": {
+		contentType: "application/xml; charset=utf-8",
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		encode:      encodeXML,
+	},
+	"csv": {
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		encode:      encodeCSV,
+	},
+	"hal": {
+		contentType: "application/hal+json; charset=utf-8",
+		mediaTypes:  []string{"application/hal+json"},
+		encode:      encodeJSON,
+		document:    halDocument,
+	},
+}
+
+const defaultFormat = "json"
+
+// negotiate picks the format to answer r in: the one named by ?format=, or
+// else the most preferred one in the Accept header. Anything it can't match
+// gets JSON.
+func negotiate(r *http.Request) (string, error) {
+	if name := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); name != "" {
+		if _, ok := encoders[name]; !ok {
+			return "", fmt.Errorf("format must be one of %s", strings.Join(formatNames(), ", "))
+		}
+		return name, nil
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, mr := range ranges {
+		if mr.mediaType == "*/*" {
+			return defaultFormat, nil
+		}
+		for _, name := range formatNames() {
+			for _, t := range encoders[name].mediaTypes {
+				if t == mr.mediaType || (strings.HasSuffix(mr.mediaType, "/*") && strings.HasPrefix(t, strings.TrimSuffix(mr.mediaType, "*"))) {
+					return name, nil
+				}
+			}
+		}
+	}
+	return defaultFormat, nil
+}
+
+// formatNames lists the registered formats, JSON first.
+func formatNames() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		if name != defaultFormat {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{defaultFormat}, names...)
+}
+
+// respond answers a read request with v in the format the client asked for.
+// Failed requests are still answered in JSON.
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	name, err := negotiate(r)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid query",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	enc := encoders[name]
+	if enc.document != nil {
+		if v, err = enc.document(r, v); err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+				Message: "Failed to encode response",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if err := enc.encode(&buf, v); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to encode response",
+			Error:   err.Error(),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", enc.contentType)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+type (
+	// jsonObject is a JSON object with its keys in the order they were
+	// encoded, which the other formats keep.
+	jsonObject []jsonField
+
+	jsonField struct {
+		key   string
+		value interface{}
+	}
+)
+
+func (o jsonObject) get(key string) (interface{}, bool) {
+	for _, f := range o {
+		if f.key == key {
+			return f.value, true
+		}
+	}
+	return nil, false
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonTree encodes v as JSON and reads it back as jsonObjects, slices and
+// scalars, so every format renders the same fields under the same names.
+func jsonTree(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return readJSONValue(dec)
+}
+
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{key.(string), value})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// scalarString formats a JSON string, number, bool or null as text.
+func scalarString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// encodeXML writes v as a <response> element with an element for each
+// field. List items are <item> elements, and keys that aren't XML names,
+// such as tags counted in stats, become <entry key="...">.
+func encodeXML(w io.Writer, v interface{}) error {
+	tree, err := jsonTree(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := writeXMLElement(enc, "response", tree); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlNamePattern.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "xml") {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+
+	switch v := v.(type) {
+	case jsonObject:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, f := range v {
+			if err := writeXMLElement(enc, f.key, f.value); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case []interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := writeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	}
+	return enc.EncodeElement(scalarString(v), start)
+}
+
+// encodeCSV writes the data of a response as a table, one row per item of a
+// list or a single row for one item. Nested objects are spread over columns
+// named like "by_priority.high", lists of values are joined with ";", and
+// lists of objects are written as JSON.
+func encodeCSV(w io.Writer, v interface{}) error {
+	tree, err := jsonTree(v)
+	if err != nil {
+		return err
+	}
+	if obj, ok := tree.(jsonObject); ok {
+		if data, ok := obj.get("data"); ok {
+			tree = data
+		}
+	}
+	items, ok := tree.([]interface{})
+	if !ok {
+		items = []interface{}{tree}
+	}
+
+	var columns []string
+	seen := map[string]bool{}
+	rows := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		row := map[string]string{}
+		if err := flattenCSV(row, &columns, seen, "", item); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, c := range columns {
+			record[i] = row[c]
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func flattenCSV(row map[string]string, columns *[]string, seen map[string]bool, name string, v interface{}) error {
+	set := func(value string) {
+		if name == "" {
+			name = "value"
+		}
+		if !seen[name] {
+			seen[name] = true
+			*columns = append(*columns, name)
+		}
+		row[name] = value
+	}
+
+	switch v := v.(type) {
+	case jsonObject:
+		for _, f := range v {
+			key := f.key
+			if name != "" {
+				key = name + "." + key
+			}
+			if err := flattenCSV(row, columns, seen, key, f.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case jsonObject, []interface{}:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				set(string(b))
+				return nil
+			}
+			values = append(values, scalarString(item))
+		}
+		set(strings.Join(values, ";"))
+		return nil
+	case string:
+		set(csvSafe(v))
+		return nil
+	}
+	set(scalarString(v))
+	return nil
+}
+
+// csvSafe stops a spreadsheet from running text that a user wrote, such as
+// a todo titled "=HYPERLINK(...)", as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		target, accept string
+		want           string
+	}{
+		{"/", "", "json"},
+		{"/", "application/json", "json"},
+		{"/", "text/csv", "csv"},
+		{"/", "text/xml", "xml"},
+		{"/", "application/xml;q=0.5, text/csv;q=0.9", "csv"},
+		{"/", "text/*", "csv"},
+		{"/", "text/html,application/xhtml+xml,*/*;q=0.8", "json"},
+		{"/", "image/png", "json"},
+		{"/", "text/csv;q=0, application/xml", "xml"},
+		{"/?format=CSV", "application/xml", "csv"},
+		{"/", "application/hal+json", "hal"},
+		{"/", "application/*", "json"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Header.Set("Accept", tt.accept)
+		got, err := negotiate(r)
+		if err != nil || got != tt.want {
+			t.Errorf("negotiate(%s, Accept %q) = %q, %v; want %q", tt.target, tt.accept, got, err, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/?format=yaml", nil)
+	if _, err := negotiate(r); err == nil {
+		t.Error("negotiate accepted ?format=yaml")
+	}
+}
+
+func TestEncodeCSV(t *testing.T) {
+	resp := listResponse[todo]{Data: []todo{
+		{ID: "1", Title: `Call "Bob", later`, Tags: []string{"home", "phone"}},
+		{ID: "2", Title: "=SUM(A1:A9)", Completed: true},
+	}}
+	var buf bytes.Buffer
+	if err := encodeCSV(&buf, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,title,description,completed,starred,tags,created_at,updated_at\n" +
+		"1,\"Call \"\"Bob\"\", later\",,false,false,home;phone,,\n" +
+		"2,'=SUM(A1:A9),,true,false,,,\n"
+	if buf.String() != want {
+		t.Errorf("encodeCSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestEncodeCSVNested(t *testing.T) {
+	resp := itemResponse[todoStats]{Data: todoStats{
+		Total:             2,
+		ByPriority:        map[string]int64{"high": 2},
+		CompletionsPerDay: []dayCount{{Date: "2026-10-14", Count: 1}},
+	}}
+	var buf bytes.Buffer
+	if err := encodeCSV(&buf, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "total,completed,pending,by_tag,by_priority.high,completions_per_day,completed_today,avg_time_to_complete\n" +
+		"2,0,0,,2,\"[{\"\"date\"\":\"\"2026-10-14\"\",\"\"count\"\":1}]\",0,0\n"
+	if buf.String() != want {
+		t.Errorf("encodeCSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	resp := itemResponse[todoStats]{Data: todoStats{
+		Total: 1,
+		ByTag: map[string]int64{"a & b": 1},
+	}}
+	var buf bytes.Buffer
+	if err := encodeXML(&buf, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><data><total>1</total><completed>0</completed><pending>0</pending>` +
+		`<by_tag><entry key="a &amp; b">1</entry></by_tag><by_priority></by_priority>` +
+		`<completions_per_day></completions_per_day><completed_today>0</completed_today>` +
+		`<avg_time_to_complete>0</avg_time_to_complete></data></response>`
+	if buf.String() != want {
+		t.Errorf("encodeXML =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
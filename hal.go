@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// HAL (application/hal+json) responses carry links to the resource itself
+// and to related ones under "_links", so clients can follow them instead of
+// building URLs. Listings put their items under "_embedded", each with its
+// own links, and a paged todo listing links to the first, previous and
+// next pages.
+
+type (
+	halLink struct {
+		Href string `json:"href"`
+	}
+
+	// halRel is a link from a resource, named by its relation.
+	halRel struct {
+		rel, href string
+	}
+
+	// halCollection is implemented by listing responses.
+	halCollection interface {
+		halItems() (items []interface{}, page, perPage int)
+	}
+
+	// halItem is implemented by responses that carry a single item.
+	halItem interface {
+		halItem() interface{}
+	}
+)
+
+func (l listResponse[T]) halItems() ([]interface{}, int, int) {
+	items := make([]interface{}, 0, len(l.Data))
+	for _, item := range l.Data {
+		items = append(items, item)
+	}
+	return items, l.page, l.perPage
+}
+
+func (i itemResponse[T]) halItem() interface{} {
+	return i.Data
+}
+
+func (t todoResponse) halItem() interface{} {
+	return t.Data
+}
+
+// halDocument builds the HAL document for a response to r.
+func halDocument(r *http.Request, v interface{}) (interface{}, error) {
+	self := halLink{r.URL.RequestURI()}
+
+	switch resp := v.(type) {
+	case halCollection:
+		items, page, perPage := resp.halItems()
+		links := jsonObject{{"self", self}}
+		if perPage > 0 {
+			links = append(links, pageLinks(r, page, perPage, len(items))...)
+		}
+		doc := jsonObject{{"_links", links}, {"count", len(items)}}
+
+		embedded := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			res, err := halResource(item, nil)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := res.(jsonObject); !ok {
+				// Values such as tag names aren't resources to embed.
+				tree, err := jsonTree(items)
+				if err != nil {
+					return nil, err
+				}
+				return append(doc, jsonField{"items", tree}), nil
+			}
+			embedded = append(embedded, res)
+		}
+		return append(doc, jsonField{"_embedded", jsonObject{{"items", embedded}}}), nil
+	case halItem:
+		return halResource(resp.halItem(), &self)
+	}
+	return halResource(v, &self)
+}
+
+// halResource returns v with its links, if it is an object. self, when
+// given, is used for items that don't know their own location.
+func halResource(v interface{}, self *halLink) (interface{}, error) {
+	tree, err := jsonTree(v)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := tree.(jsonObject)
+	if !ok {
+		return tree, nil
+	}
+
+	links := jsonObject{}
+	rels := itemLinks(v)
+	if self != nil && (len(rels) == 0 || rels[0].rel != "self") {
+		links = append(links, jsonField{"self", *self})
+	}
+	for _, l := range rels {
+		links = append(links, jsonField{l.rel, halLink{l.href}})
+	}
+	if len(links) == 0 {
+		return obj, nil
+	}
+	return append(jsonObject{{"_links", links}}, obj...), nil
+}
+
+// itemLinks returns the links of the items that have a location of their
+// own, self first.
+func itemLinks(v interface{}) []halRel {
+	switch v := v.(type) {
+	case todo:
+		return todoLinks(v.ID, v.ListID)
+	case map[string]interface{}:
+		// A todo limited to some of its fields.
+		id, _ := v["id"].(string)
+		listID, _ := v["list_id"].(string)
+		if id != "" {
+			return todoLinks(id, listID)
+		}
+	case sharedList:
+		return []halRel{
+			{"self", "/lists/" + v.ID},
+			{"todos", "/todo/?list_id=" + url.QueryEscape(v.ID)},
+		}
+	case attachment:
+		return []halRel{
+			{"self", "/todo/" + v.TodoID + "/attachments/" + v.ID},
+			{"todo", "/todo/" + v.TodoID},
+		}
+	case historyEntry:
+		return []halRel{
+			{"todo", "/todo/" + v.TodoID},
+			{"history", "/todo/" + v.TodoID + "/history"},
+		}
+	}
+	return nil
+}
+
+func todoLinks(id, listID string) []halRel {
+	links := []halRel{
+		{"self", "/todo/" + id},
+		{"history", "/todo/" + id + "/history"},
+		{"attachments", "/todo/" + id + "/attachments"},
+	}
+	if listID != "" {
+		links = append(links, halRel{"list", "/lists/" + listID})
+	}
+	return links
+}
+
+// pageLinks links a page of a listing to the first, previous and next
+// pages. There is a next page as long as this one is full.
+func pageLinks(r *http.Request, page, perPage, n int) jsonObject {
+	q := r.URL.Query()
+	link := func(p int) halLink {
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+		return halLink{r.URL.Path + "?" + q.Encode()}
+	}
+
+	links := jsonObject{{"first", link(1)}}
+	if page > 1 {
+		links = append(links, jsonField{"prev", link(page - 1)})
+	}
+	if n == perPage {
+		links = append(links, jsonField{"next", link(page + 1)})
+	}
+	return links
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// halJSON renders v for a request to target as HAL and reads it back.
+func halJSON(t *testing.T, target string, v interface{}) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	respond(rec, httptest.NewRequest(http.MethodGet, target, nil), http.StatusOK, v)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/hal+json; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	return doc
+}
+
+// href returns the href of the link rel in the _links of doc.
+func href(doc map[string]interface{}, rel string) string {
+	links, _ := doc["_links"].(map[string]interface{})
+	link, _ := links[rel].(map[string]interface{})
+	s, _ := link["href"].(string)
+	return s
+}
+
+func TestHALListing(t *testing.T) {
+	resp := listResponse[interface{}]{
+		Data: []interface{}{
+			todo{ID: "1", Title: "Call Bob", ListID: "9"},
+			map[string]interface{}{"id": "2", "title": "Buy milk"},
+		},
+		page:    2,
+		perPage: 2,
+	}
+	doc := halJSON(t, "/todo/?format=hal&page=2&per_page=2&completed=false", resp)
+
+	for rel, want := range map[string]string{
+		"self":  "/todo/?format=hal&page=2&per_page=2&completed=false",
+		"first": "/todo/?completed=false&format=hal&page=1&per_page=2",
+		"prev":  "/todo/?completed=false&format=hal&page=1&per_page=2",
+		"next":  "/todo/?completed=false&format=hal&page=3&per_page=2",
+	} {
+		if got := href(doc, rel); got != want {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+	if doc["count"] != 2.0 {
+		t.Errorf("count = %v", doc["count"])
+	}
+
+	embedded, _ := doc["_embedded"].(map[string]interface{})
+	items, _ := embedded["items"].([]interface{})
+	if len(items) != 2 {
+		t.Fatalf("embedded items = %v", embedded)
+	}
+	first := items[0].(map[string]interface{})
+	if href(first, "self") != "/todo/1" || href(first, "list") != "/lists/9" || first["title"] != "Call Bob" {
+		t.Errorf("first item = %v", first)
+	}
+	if second := items[1].(map[string]interface{}); href(second, "self") != "/todo/2" || href(second, "list") != "" {
+		t.Errorf("second item = %v", second)
+	}
+}
+
+func TestHALLastPage(t *testing.T) {
+	resp := listResponse[todo]{Data: []todo{{ID: "1"}}, page: 1, perPage: 2}
+	doc := halJSON(t, "/todo/?format=hal&per_page=2", resp)
+	if href(doc, "next") != "" || href(doc, "prev") != "" || href(doc, "first") == "" {
+		t.Errorf("links = %v", doc["_links"])
+	}
+
+	// Unpaged listings only link to themselves.
+	doc = halJSON(t, "/tags/?format=hal", listResponse[string]{Data: []string{"home"}})
+	if href(doc, "first") != "" || doc["_embedded"] != nil {
+		t.Errorf("unpaged listing = %v", doc)
+	}
+	if items, _ := doc["items"].([]interface{}); len(items) != 1 || items[0] != "home" {
+		t.Errorf("items = %v", doc["items"])
+	}
+}
+
+func TestHALItem(t *testing.T) {
+	doc := halJSON(t, "/todo/1?format=hal", todoResponse{Data: todo{ID: "1", Title: "Call Bob"}})
+	if href(doc, "self") != "/todo/1" || href(doc, "history") != "/todo/1/history" || doc["title"] != "Call Bob" {
+		t.Errorf("todo = %v", doc)
+	}
+
+	// Items without a location of their own link to the request.
+	doc = halJSON(t, "/me/usage?format=hal", itemResponse[usage]{Data: usage{}})
+	if href(doc, "self") != "/me/usage?format=hal" {
+		t.Errorf("usage = %v", doc)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() { trustTestProxy() }
+
+// serve sends a request through the full router. user, when set, signs the
+// request in through the proxy header.
+func serve(t *testing.T, method, target, user, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != "" {
+		req.Header.Set(authUserHeader, user)
+	}
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// The requests below are all turned away before reaching the store, which
+// unreachableService would make fail with a 500.
+func TestHandlersRejectInvalidRequests(t *testing.T) {
+	unreachableService(t)
+
+	tests := []struct {
+		name         string
+		method, path string
+		user, body   string
+		status       int
+		errContains  string
+	}{
+		{"malformed id", http.MethodGet, "/todo/nope", "", "", http.StatusBadRequest, ""},
+		{"update malformed id", http.MethodPut, "/todo/nope", "", `{"title":"a"}`, http.StatusBadRequest, ""},
+		{"delete malformed id", http.MethodDelete, "/todo/nope", "", "", http.StatusBadRequest, ""},
+		{"complete malformed id", http.MethodPost, "/todo/nope/complete", "", "", http.StatusBadRequest, ""},
+		{"uncomplete malformed id", http.MethodPost, "/todo/nope/uncomplete", "", "", http.StatusBadRequest, ""},
+		{"star malformed id", http.MethodPost, "/todo/nope/star", "", "", http.StatusBadRequest, ""},
+		{"unstar malformed id", http.MethodPost, "/todo/nope/unstar", "", "", http.StatusBadRequest, ""},
+		{"clone malformed id", http.MethodPost, "/todo/nope/clone", "", "", http.StatusBadRequest, ""},
+		{"clone bad list_id", http.MethodPost, "/todo/000000000000000000000001/clone", "", `{"list_id":"nope"}`, http.StatusBadRequest, "Invalid list_id"},
+		{"patch malformed id", http.MethodPatch, "/todo/nope", "", `{"assignee_id":"bob"}`, http.StatusBadRequest, ""},
+		{"patch other fields", http.MethodPatch, "/todo/000000000000000000000001", "", `{"title":"a"}`, http.StatusBadRequest, "Only assignee_id can be patched"},
+		{"patch bad assignee", http.MethodPatch, "/todo/000000000000000000000001", "", `{"assignee_id":7}`, http.StatusBadRequest, "assignee_id must be a user ID or null"},
+		{"assignee me anonymous", http.MethodGet, "/todo/?assignee=me", "", "", http.StatusBadRequest, "assignee=me needs a signed-in user"},
+		{"bad starred filter", http.MethodGet, "/todo/?starred=maybe", "", "", http.StatusBadRequest, "starred must be true or false"},
+		{"bad completed_on", http.MethodGet, "/todo/?completed_on=yesterday", "", "", http.StatusBadRequest, "completed_on must be"},
+		{"bad completed filter", http.MethodGet, "/todo/?completed=maybe", "", "", http.StatusBadRequest, "completed must be true or false"},
+		{"bad sort", http.MethodGet, "/todo/?sort=owner", "", "", http.StatusBadRequest, "sort must be one of"},
+		{"bad order", http.MethodGet, "/todo/?order=up", "", "", http.StatusBadRequest, "order must be asc or desc"},
+		{"bad page", http.MethodGet, "/todo/?page=0", "", "", http.StatusBadRequest, "page must be a positive number"},
+		{"bad per_page", http.MethodGet, "/todo/?per_page=1000", "", "", http.StatusBadRequest, "per_page must be between 1 and 500"},
+		{"unknown format", http.MethodGet, "/todo/?format=yaml", "", "", http.StatusBadRequest, "format must be one of json, csv, hal, xml"},
+		{"create malformed body", http.MethodPost, "/todo/", "", `{`, http.StatusBadRequest, ""},
+		{"create without title", http.MethodPost, "/todo/", "", `{"description":"x"}`, http.StatusBadRequest, "Title is required"},
+		{"create bad priority", http.MethodPost, "/todo/", "", `{"title":"a","priority":"urgent"}`, http.StatusBadRequest, "Priority must be low, medium or high"},
+		{"update malformed body", http.MethodPut, "/todo/000000000000000000000001", "", `{`, http.StatusBadRequest, "unexpected EOF"},
+		{"update without title", http.MethodPut, "/todo/000000000000000000000001", "", `{}`, http.StatusBadRequest, "Title is required"},
+		{"quickadd without title", http.MethodPost, "/todo/quickadd", "", `{"text":"tomorrow #home"}`, http.StatusBadRequest, "Title is required"},
+		{"import anonymous", http.MethodPost, "/todo/import/todoist", "", `{}`, http.StatusUnauthorized, ""},
+		{"import unknown source", http.MethodPost, "/todo/import/asana", "ann", `{}`, http.StatusNotFound, "todoist and trello"},
+		{"import wrong export", http.MethodPost, "/todo/import/trello", "ann", `{"items":[]}`, http.StatusBadRequest, "Trello board export"},
+		{"usage anonymous", http.MethodGet, "/me/usage", "", "", http.StatusUnauthorized, ""},
+		{"export anonymous", http.MethodGet, "/me/export", "", "", http.StatusUnauthorized, ""},
+		{"delete account anonymous", http.MethodDelete, "/me", "", "", http.StatusUnauthorized, ""},
+		{"lists anonymous", http.MethodGet, "/lists/", "", "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.method, tt.path, tt.user, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			var resp errorResponse
+			decodeBody(t, rec, &resp)
+			if resp.Message == "" {
+				t.Errorf("response has no message: %s", rec.Body)
+			}
+			if !strings.Contains(resp.Error, tt.errContains) {
+				t.Errorf("error = %q, want it to contain %q", resp.Error, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestVersionFormats(t *testing.T) {
+	tests := []struct {
+		target, accept string
+		contentType    string
+		contains       string
+	}{
+		{"/version", "", "application/json", `"version":"dev"`},
+		{"/version", "application/xml", "application/xml", "<version>dev</version>"},
+		{"/version", "text/csv;q=0.9, application/json;q=0.5", "text/csv", "version,commit,build_time,go\ndev,"},
+		{"/version?format=xml", "application/json", "application/xml", "<response>"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s (Accept %q): status = %d", tt.target, tt.accept, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("%s (Accept %q): Content-Type = %q, want %s", tt.target, tt.accept, ct, tt.contentType)
+		}
+		if !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("%s (Accept %q): body %q doesn't contain %q", tt.target, tt.accept, rec.Body, tt.contains)
+		}
+	}
+}
+
+func TestDebugEndpointsOffByDefault(t *testing.T) {
+	if rec := serve(t, http.MethodGet, "/debug/vars", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestQuotaRefusesAnonymousCreates(t *testing.T) {
+	unreachableService(t)
+	old := maxTodosPerUser
+	maxTodosPerUser = 1
+	t.Cleanup(func() { maxTodosPerUser = old })
+
+	// Anonymous todos have no owner to count, so they'd never reach the
+	// limit.
+	for _, path := range []string{"/todo/?allow_duplicate=true", "/todo/quickadd?allow_duplicate=true"} {
+		rec := serve(t, http.MethodPost, path, "", `{"title":"Free todo","text":"Free todo"}`)
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "sign in") {
+			t.Errorf("POST %s: status %d; body %s", path, rec.Code, rec.Body)
+		}
+	}
+}
+
+// memoryTodoStore keeps todos in memory for handler tests. Like the service
+// it only shows callers the todos authorize lets them see; todos on shared
+// lists aren't supported.
+type memoryTodoStore struct {
+	mu    sync.Mutex
+	todos []todoModel // in creation order
+}
+
+// memoryStore points the todo handlers at an empty memoryTodoStore.
+func memoryStore(t *testing.T) *memoryTodoStore {
+	t.Helper()
+	m := &memoryTodoStore{}
+	old := store
+	store = m
+	t.Cleanup(func() { store = old })
+	return m
+}
+
+func (m *memoryTodoStore) each(ctx context.Context, c caller, q todoQuery, fn func(todoModel) error) error {
+	m.mu.Lock()
+	var visible []todoModel
+	for _, t := range m.todos {
+		if svc.authorize(ctx, c, t, false) != nil || (q.completed != nil && t.Completed != *q.completed) {
+			continue
+		}
+		visible = append(visible, t)
+	}
+	m.mu.Unlock()
+
+	if q.perPage > 0 {
+		from := min((q.page-1)*q.perPage, len(visible))
+		visible = visible[from:min(from+q.perPage, len(visible))]
+	}
+	for _, t := range visible {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the index of the todo id if c may access it.
+func (m *memoryTodoStore) find(ctx context.Context, c caller, id primitive.ObjectID, write bool) (int, error) {
+	for i, t := range m.todos {
+		if t.ID == id {
+			return i, svc.authorize(ctx, c, t, write)
+		}
+	}
+	return -1, errTodoNotFound
+}
+
+func (m *memoryTodoStore) get(ctx context.Context, c caller, id primitive.ObjectID) (todoModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.find(ctx, c, id, false)
+	if err != nil {
+		return todoModel{}, err
+	}
+	return m.todos[i], nil
+}
+
+func (m *memoryTodoStore) create(ctx context.Context, c caller, tm todoModel) error {
+	if tm.ListID != nil {
+		return errListNotFound
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tm.OwnerID = c.userID
+	tm.WorkspaceID = c.workspace
+	m.todos = append(m.todos, tm)
+	return nil
+}
+
+func (m *memoryTodoStore) update(ctx context.Context, c caller, id primitive.ObjectID, t todo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.find(ctx, c, id, true)
+	if err != nil {
+		return err
+	}
+	tm := &m.todos[i]
+	now := time.Now()
+	switch {
+	case t.Completed && !tm.Completed:
+		tm.CompletedAt = &now
+	case !t.Completed:
+		tm.CompletedAt = nil
+	}
+	tm.Title, tm.Description, tm.Completed = t.Title, t.Description, t.Completed
+	tm.Tags, tm.Priority, tm.DueDate = t.Tags, t.Priority, t.dueDate()
+	tm.UpdatedAt = now
+	return nil
+}
+
+func (m *memoryTodoStore) delete(ctx context.Context, c caller, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.find(ctx, c, id, true)
+	if err != nil {
+		return err
+	}
+	m.todos = append(m.todos[:i], m.todos[i+1:]...)
+	return nil
+}
+
+func (m *memoryTodoStore) duplicateOf(ctx context.Context, c caller, title string) (primitive.ObjectID, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.todos {
+		if !t.Completed && strings.EqualFold(t.Title, title) && svc.authorize(ctx, c, t, false) == nil {
+			return t.ID, true, nil
+		}
+	}
+	return primitive.NilObjectID, false, nil
+}
+
+func TestTodoHandlers(t *testing.T) {
+	memoryStore(t)
+
+	var created todoResponse
+	rec := serve(t, http.MethodPost, "/todo/", "ann", `{"title":" Buy <b>milk</b> ","tags":["Shopping"],"priority":"HIGH"}`)
+	decodeBody(t, rec, &created)
+	if rec.Code != http.StatusCreated || created.Data.Title != "Buy milk" || created.Data.OwnerID != "ann" ||
+		created.Data.Priority != "high" || len(created.Data.Tags) != 1 || created.Data.Tags[0] != "shopping" {
+		t.Fatalf("create: status %d; body %s", rec.Code, rec.Body)
+	}
+	id := created.Data.ID
+
+	var dup todoResponse
+	decodeBody(t, serve(t, http.MethodPost, "/todo/", "ann", `{"title":"buy MILK"}`), &dup)
+	if dup.DuplicateOf != id || dup.Warning == "" {
+		t.Errorf("duplicate: %+v", dup)
+	}
+	if rec := serve(t, http.MethodPost, "/todo/", "", `{"title":"Water plants"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("anonymous create: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	titles := func(user string) []string {
+		t.Helper()
+		var listed listResponse[todo]
+		rec := serve(t, http.MethodGet, "/todo/", user, "")
+		decodeBody(t, rec, &listed)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list as %q: status %d; body %s", user, rec.Code, rec.Body)
+		}
+		var titles []string
+		for _, td := range listed.Data {
+			titles = append(titles, td.Title)
+		}
+		return titles
+	}
+	if got := strings.Join(titles("ann"), ", "); got != "Buy milk, buy MILK, Water plants" {
+		t.Errorf("ann lists %q", got)
+	}
+	if got := strings.Join(titles("bob"), ", "); got != "Water plants" {
+		t.Errorf("bob lists %q", got)
+	}
+
+	// Other users' todos don't exist as far as bob is concerned.
+	for _, tc := range []struct{ method, body string }{
+		{http.MethodGet, ""},
+		{http.MethodPut, `{"title":"Mine now"}`},
+		{http.MethodDelete, ""},
+	} {
+		if rec := serve(t, tc.method, "/todo/"+id, "bob", tc.body); rec.Code != http.StatusNotFound {
+			t.Errorf("%s as bob: status %d; body %s", tc.method, rec.Code, rec.Body)
+		}
+		if rec := serve(t, tc.method, "/todo/"+id, "", tc.body); rec.Code != http.StatusNotFound {
+			t.Errorf("%s anonymously: status %d; body %s", tc.method, rec.Code, rec.Body)
+		}
+	}
+
+	rec = serve(t, http.MethodPut, "/todo/"+id, "ann", `{"title":"Buy oat milk","completed":true,"due_date":"2026-10-20"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status %d; body %s", rec.Code, rec.Body)
+	}
+	var fetched todoResponse
+	decodeBody(t, serve(t, http.MethodGet, "/todo/"+id, "ann", ""), &fetched)
+	if got := fetched.Data; got.Title != "Buy oat milk" || !got.Completed || got.CompletedAt == "" || got.DueDate == "" || len(got.Tags) != 0 {
+		t.Errorf("after update: %+v", got)
+	}
+
+	if rec := serve(t, http.MethodDelete, "/todo/"+id, "ann", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d; body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, http.MethodGet, "/todo/"+id, "ann", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status %d", rec.Code)
+	}
+}
+
+func TestTodoHandlersKeepWorkspacesApart(t *testing.T) {
+	memoryStore(t)
+
+	serveIn := func(method, target, workspace, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(authUserHeader, "ann")
+		req.Header.Set(workspaceHeader, workspace)
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	var created todoResponse
+	decodeBody(t, serveIn(http.MethodPost, "/todo/", "acme", `{"title":"Ship it"}`), &created)
+	if created.Data.ID == "" {
+		t.Fatal("create in acme failed")
+	}
+
+	for _, workspace := range []string{"globex", ""} {
+		if rec := serveIn(http.MethodGet, "/todo/"+created.Data.ID, workspace, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("get in %q: status %d", workspace, rec.Code)
+		}
+		var listed listResponse[todo]
+		decodeBody(t, serveIn(http.MethodGet, "/todo/", workspace, ""), &listed)
+		if len(listed.Data) != 0 {
+			t.Errorf("%q lists %+v", workspace, listed.Data)
+		}
+	}
+
+	// Anonymous clients can't name a workspace themselves.
+	req := httptest.NewRequest(http.MethodGet, "/todo/", nil)
+	req.Header.Set(workspaceHeader, "acme")
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Ship it") {
+		t.Errorf("anonymous list naming acme: status %d; body %s", rec.Code, rec.Body)
+	}
+}
+
+// failingStore fails the writes it is asked for as an unreachable or slow
+// database would.
+type failingStore struct {
+	todoStore
+}
+
+func (failingStore) duplicateOf(ctx context.Context, c caller, title string) (primitive.ObjectID, bool, error) {
+	return primitive.NilObjectID, false, nil
+}
+
+func (failingStore) create(ctx context.Context, c caller, tm todoModel) error {
+	return fmt.Errorf("insert: %w", context.DeadlineExceeded)
+}
+
+func (failingStore) update(ctx context.Context, c caller, id primitive.ObjectID, t todo) error {
+	return errStoreUnavailable
+}
+
+func (failingStore) delete(ctx context.Context, c caller, id primitive.ObjectID) error {
+	return errStoreUnavailable
+}
+
+func TestTodoHandlersReportStoreErrors(t *testing.T) {
+	old := store
+	store = failingStore{}
+	t.Cleanup(func() { store = old })
+
+	for _, tc := range []struct{ method, target, body string }{
+		{http.MethodPost, "/todo/", `{"title":"a"}`},
+		{http.MethodPut, "/todo/000000000000000000000001", `{"title":"a"}`},
+		{http.MethodDelete, "/todo/000000000000000000000001", ""},
+	} {
+		rec := serve(t, tc.method, tc.target, "ann", tc.body)
+		var resp errorResponse
+		decodeBody(t, rec, &resp)
+		if rec.Code != http.StatusServiceUnavailable || resp.Error != errStoreUnavailable.Error() {
+			t.Errorf("%s: status %d; body %s", tc.method, rec.Code, rec.Body)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After header", tc.method)
+		}
+	}
+}
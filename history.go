@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const historyCollName = "todo_history"
+
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+	actionUndo   = "undo"
+)
+
+// historyRetention is how long edits stay in a todo's history, and so how
+// far back they can be undone. Deletions stay until the deleted todo is
+// purged. 0 keeps the history forever.
+var historyRetention = envDuration("HISTORY_RETENTION", 0)
+
+var (
+	errNothingToUndo = errors.New("nothing to undo")
+	errCannotUndo    = errors.New("change cannot be undone")
+)
+
+type (
+	// historyModel is one entry in a todo's audit log. Previous holds the
+	// todo as it was before the change so that it can be undone; it is nil
+	// for creations.
+	historyModel struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty"`
+		TodoID    primitive.ObjectID `bson:"todo_id"`
+		Action    string             `bson:"action"`
+		Actor     string             `bson:"actor"`
+		Changes   []fieldChange      `bson:"changes"`
+		Previous  *todoModel         `bson:"previous,omitempty"`
+		Undone    bool               `bson:"undone"`
+		CreatedAt time.Time          `bson:"created_at"`
+	}
+
+	fieldChange struct {
+		Field string      `bson:"field" json:"field"`
+		From  interface{} `bson:"from" json:"from"`
+		To    interface{} `bson:"to" json:"to"`
+	}
+
+	historyEntry struct {
+		ID        string        `json:"id"`
+		TodoID    string        `json:"todo_id"`
+		Action    string        `json:"action"`
+		Actor     string        `json:"actor"`
+		Changes   []fieldChange `json:"changes"`
+		Undone    bool          `json:"undone"`
+		CreatedAt string        `json:"created_at"`
+	}
+)
+
+var trackedFieldNames = []string{"title", "description", "completed", "tags", "priority", "due_date", "assignee_id", "starred", "list_id"}
+
+// trackedFields returns the user-editable fields of t keyed by their stored
+// name. A nil todo has no fields, which is how creations and deletions show
+// up in a diff.
+func trackedFields(t *todoModel) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"title":       t.Title,
+		"description": t.Description,
+		"completed":   t.Completed,
+		"tags":        t.Tags,
+		"priority":    t.Priority,
+		"due_date":    t.DueDate,
+		"assignee_id": t.AssigneeID,
+		"starred":     t.Starred,
+		"list_id":     t.ListID,
+	}
+}
+
+func diffTodos(before, after *todoModel) []fieldChange {
+	from, to := trackedFields(before), trackedFields(after)
+
+	var changes []fieldChange
+	for _, field := range trackedFieldNames {
+		if before != nil && after != nil && reflect.DeepEqual(from[field], to[field]) {
+			continue
+		}
+		changes = append(changes, fieldChange{Field: field, From: from[field], To: to[field]})
+	}
+	return changes
+}
+
+func (s *todoService) recordHistory(ctx context.Context, todoID primitive.ObjectID, action, actor string, previous *todoModel, changes []fieldChange) error {
+	_, err := s.history.InsertOne(ctx, historyModel{
+		ID:        primitive.NewObjectID(),
+		TodoID:    todoID,
+		Action:    action,
+		Actor:     actor,
+		Changes:   changes,
+		Previous:  previous,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// authorizeHistory checks c's access to a todo's history. The todo may have
+// been deleted, in which case the snapshot taken at deletion is checked.
+func (s *todoService) authorizeHistory(ctx context.Context, c caller, todoID primitive.ObjectID, write bool) error {
+	_, err := s.getForAccess(ctx, c, todoID, write)
+	if !errors.Is(err, errTodoNotFound) {
+		return err
+	}
+
+	var entry historyModel
+	filter := bson.M{"todo_id": todoID, "previous": bson.M{"$ne": nil}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	err = s.history.FindOne(ctx, filter, opts).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errTodoNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.authorize(ctx, c, *entry.Previous, write)
+}
+
+func (s *todoService) listHistory(ctx context.Context, c caller, todoID primitive.ObjectID) ([]historyModel, error) {
+	if err := s.authorizeHistory(ctx, c, todoID, false); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.history.Find(ctx, bson.M{"todo_id": todoID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []historyModel
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// undo reverts the most recent change to a todo that has not been undone
+// yet: a creation is deleted, an update has its previous values restored and
+// a deletion is reinserted. The undo itself is recorded in the history but
+// cannot be undone in turn.
+func (s *todoService) undo(ctx context.Context, c caller, todoID primitive.ObjectID) (historyModel, error) {
+	if err := s.authorizeHistory(ctx, c, todoID, true); err != nil {
+		return historyModel{}, err
+	}
+
+	filter := bson.M{
+		"todo_id": todoID,
+		"action":  bson.M{"$in": bson.A{actionCreate, actionUpdate, actionDelete}},
+		"undone":  bson.M{"$ne": true},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	var entry historyModel
+	err := s.history.FindOne(ctx, filter, opts).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return entry, errNothingToUndo
+	}
+	if err != nil {
+		return entry, err
+	}
+	if entry.Action != actionCreate && entry.Previous == nil {
+		return entry, errCannotUndo
+	}
+
+	var before, after *todoModel
+	switch entry.Action {
+	case actionCreate:
+		var current todoModel
+		err := s.todos.FindOneAndDelete(ctx, bson.M{"_id": todoID}).Decode(&current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entry, errTodoNotFound
+		}
+		if err != nil {
+			return entry, err
+		}
+		if err := s.bury(ctx, current); err != nil {
+			return entry, err
+		}
+		before = &current
+
+	case actionUpdate:
+		prev := entry.Previous
+		current, err := s.find(ctx, todoID)
+		if err != nil {
+			return entry, err
+		}
+		if !reflect.DeepEqual(prev.ListID, current.ListID) {
+			// Moving the todo back needs write access to the list it
+			// came from, which may be gone by now.
+			if err := s.authorize(ctx, c, *prev, true); err != nil {
+				return entry, err
+			}
+		}
+		set := bson.M{
+			"title":       prev.Title,
+			"description": prev.Description,
+			"completed":   prev.Completed,
+			"updated_at":  time.Now(),
+		}
+		unset := bson.M{}
+		if prev.CompletedAt != nil {
+			set["completed_at"] = *prev.CompletedAt
+		} else {
+			unset["completed_at"] = ""
+		}
+		if len(prev.Tags) > 0 {
+			set["tags"] = prev.Tags
+		} else {
+			unset["tags"] = ""
+		}
+		if prev.Priority != "" {
+			set["priority"] = prev.Priority
+		} else {
+			unset["priority"] = ""
+		}
+		if prev.DueDate != nil {
+			set["due_date"] = *prev.DueDate
+		} else {
+			unset["due_date"] = ""
+		}
+		if prev.AssigneeID != "" {
+			set["assignee_id"] = prev.AssigneeID
+		} else {
+			unset["assignee_id"] = ""
+		}
+		if prev.Starred {
+			set["starred"] = true
+		} else {
+			unset["starred"] = ""
+		}
+		if prev.ListID != nil {
+			set["list_id"] = *prev.ListID
+		} else {
+			unset["list_id"] = ""
+		}
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+		err = s.todos.FindOneAndUpdate(ctx, bson.M{"_id": todoID}, update, opts).Decode(&current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entry, errTodoNotFound
+		}
+		if err != nil {
+			return entry, err
+		}
+		restored := current
+		restored.Title = prev.Title
+		restored.Description = prev.Description
+		restored.Completed = prev.Completed
+		restored.CompletedAt = prev.CompletedAt
+		restored.Tags = prev.Tags
+		restored.Priority = prev.Priority
+		restored.DueDate = prev.DueDate
+		restored.AssigneeID = prev.AssigneeID
+		restored.Starred = prev.Starred
+		restored.ListID = prev.ListID
+		before, after = &current, &restored
+
+	case actionDelete:
+		restored := *entry.Previous
+		restored.UpdatedAt = time.Now()
+		if err := s.checkTodoQuota(ctx, restored.owner()); err != nil {
+			return entry, err
+		}
+		if _, err := s.todos.InsertOne(ctx, restored); err != nil {
+			return entry, err
+		}
+		if err := s.unbury(ctx, restored.ID); err != nil {
+			return entry, err
+		}
+		after = &restored
+	}
+
+	switch {
+	case after == nil:
+		s.changed(ctx, eventTodoDeleted, *before)
+	case before == nil:
+		s.changed(ctx, eventTodoCreated, *after)
+	default:
+		s.changed(ctx, eventTodoUpdated, *after)
+	}
+
+	if _, err := s.history.UpdateByID(ctx, entry.ID, bson.M{"$set": bson.M{"undone": true}}); err != nil {
+		return entry, err
+	}
+	entry.Undone = true
+
+	return entry, s.recordHistory(ctx, todoID, actionUndo, c.actor(), before, diffTodos(before, after))
+}
+
+func newHistoryEntry(e historyModel) historyEntry {
+	return historyEntry{
+		ID:        e.ID.Hex(),
+		TodoID:    e.TodoID.Hex(),
+		Action:    e.Action,
+		Actor:     e.Actor,
+		Changes:   e.Changes,
+		Undone:    e.Undone,
+		CreatedAt: e.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func fetchTodoHistory(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	entries, err := svc.listHistory(ctx, requestCaller(r), objID)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch todo history",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	history := []historyEntry{}
+	for _, e := range entries {
+		history = append(history, newHistoryEntry(e))
+	}
+
+	respond(w, r, http.StatusOK, listResponse[historyEntry]{
+		Data: history,
+	})
+}
+
+func undoTodo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	entry, err := svc.undo(ctx, requestCaller(r), objID)
+	switch {
+	case quotaExceeded(w, "Failed to undo todo change", err):
+		return
+	case errors.Is(err, errForbidden):
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to undo todo change",
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	case errors.Is(err, errNothingToUndo), errors.Is(err, errCannotUndo), errors.Is(err, errTodoNotFound):
+		rnd.JSON(w, http.StatusConflict, errorResponse{
+			Message: "Failed to undo todo change",
+			Error:   err.Error(),
+		})
+		return
+	case err != nil:
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to undo todo change",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, itemResponse[historyEntry]{
+		Message: "Todo change undone",
+		Data:    newHistoryEntry(entry),
+	})
+}
+
+// runHistoryPrune removes edits older than historyRetention now and then
+// every purgeInterval until ctx is done.
+func (s *todoService) runHistoryPrune(ctx context.Context) {
+	if historyRetention <= 0 {
+		return
+	}
+	interval := purgeInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	log.Printf("Pruning history older than %s every %s", historyRetention, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().Add(-historyRetention)
+		pruneCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		res, err := s.history.DeleteMany(pruneCtx, bson.M{
+			"action":     bson.M{"$ne": actionDelete},
+			"created_at": bson.M{"$lt": cutoff},
+		})
+		cancel()
+		if err != nil {
+			log.Printf("History prune failed: %v", err)
+		} else if res.DeletedCount > 0 {
+			log.Printf("Pruned %d history entries from before %s", res.DeletedCount, cutoff.Format(time.RFC3339))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
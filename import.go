@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxImportSize bounds the export a user can upload, in bytes.
+var maxImportSize = envInt64("MAX_IMPORT_SIZE", 20<<20)
+
+// importBatch is what an importer read from another service's export: the
+// lists to create and the todos to create in them.
+type importBatch struct {
+	lists   []string
+	todos   []importedTodo
+	skipped int // items that weren't imported, e.g. archived ones
+}
+
+type importedTodo struct {
+	todo todoModel
+	list int // index into lists, or -1 for none
+}
+
+type (
+	// importResult reports what an import created.
+	importResult struct {
+		Lists   []importedList `json:"lists"`
+		Todos   int            `json:"todos"`
+		Skipped int            `json:"skipped"`
+	}
+
+	importedList struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+)
+
+// importers read an export in the named format.
+var importers = map[string]func(data []byte) (importBatch, error){
+	"todoist": readTodoistExport,
+	"trello":  readTrelloExport,
+}
+
+// importTodos creates the batch's lists and todos for c, all or none as far
+// as the todo quota goes.
+func (s *todoService) importTodos(ctx context.Context, c caller, b importBatch) ([]listModel, error) {
+	if maxTodosPerUser > 0 {
+		n, err := s.todoCount(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if n+int64(len(b.todos)) > maxTodosPerUser {
+			return nil, &quotaError{resource: quotaTodos, limit: maxTodosPerUser, used: n}
+		}
+	}
+
+	lists := make([]listModel, 0, len(b.lists))
+	for _, name := range b.lists {
+		l, err := s.createList(ctx, c, name)
+		if err != nil {
+			return lists, err
+		}
+		lists = append(lists, l)
+	}
+
+	if len(b.todos) == 0 {
+		return lists, nil
+	}
+	docs := make([]interface{}, 0, len(b.todos))
+	history := make([]interface{}, 0, len(b.todos))
+	for i := range b.todos {
+		tm := &b.todos[i].todo
+		tm.OwnerID = c.userID
+		tm.WorkspaceID = c.workspace
+		if l := b.todos[i].list; l >= 0 {
+			tm.ListID = &lists[l].ID
+		}
+		docs = append(docs, *tm)
+		history = append(history, historyModel{
+			ID:        primitive.NewObjectID(),
+			TodoID:    tm.ID,
+			Action:    actionCreate,
+			Actor:     c.actor(),
+			Changes:   diffTodos(nil, tm),
+			CreatedAt: tm.CreatedAt,
+		})
+	}
+	if _, err := s.todos.InsertMany(ctx, docs); err != nil {
+		return lists, err
+	}
+	for _, it := range b.todos {
+		s.changed(ctx, eventTodoCreated, it.todo)
+	}
+	_, err := s.history.InsertMany(ctx, history)
+	return lists, err
+}
+
+// newImportedTodo builds a todo from an imported item, fitting what the
+// other service allows into what todos here allow: titles and descriptions
+// are sanitized and cut short, and tags that are too long or too many are
+// dropped.
+func newImportedTodo(title, description string, tags []string, priority string, due *time.Time, completedAt *time.Time) todoModel {
+	now := time.Now()
+	title = sanitizeTitle(title)
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+	description = sanitizeDescription(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		description = string([]rune(description)[:maxDescriptionLength])
+	}
+
+	var kept []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] || utf8.RuneCountInString(tag) > maxTagLength || len(kept) == maxTags {
+			continue
+		}
+		seen[tag] = true
+		kept = append(kept, tag)
+	}
+
+	return todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       title,
+		Description: description,
+		Completed:   completedAt != nil,
+		CompletedAt: completedAt,
+		Tags:        kept,
+		Priority:    priority,
+		DueDate:     due,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// parseImportTime reads a timestamp in RFC 3339, a YYYY-MM-DD date, or a
+// date and time without a zone, which is taken as UTC.
+func parseImportTime(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	t, err := parseQueryTime(s)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02T15:04:05", s); err != nil {
+			return nil
+		}
+	}
+	return &t
+}
+
+type (
+	// todoistExport is the part of a Todoist sync response or backup we
+	// read.
+	todoistExport struct {
+		Projects []struct {
+			ID           json.RawMessage `json:"id"`
+			Name         string          `json:"name"`
+			InboxProject bool            `json:"inbox_project"`
+			IsDeleted    bool            `json:"is_deleted"`
+		} `json:"projects"`
+		Labels []struct {
+			ID   json.RawMessage `json:"id"`
+			Name string          `json:"name"`
+		} `json:"labels"`
+		Items []struct {
+			Content     string            `json:"content"`
+			Description string            `json:"description"`
+			ProjectID   json.RawMessage   `json:"project_id"`
+			Labels      []json.RawMessage `json:"labels"`
+			Priority    int               `json:"priority"`
+			Checked     bool              `json:"checked"`
+			IsDeleted   bool              `json:"is_deleted"`
+			CompletedAt string            `json:"completed_at"`
+			Due         *struct {
+				Date string `json:"date"`
+			} `json:"due"`
+		} `json:"items"`
+	}
+
+	// trelloExport is the part of a Trello board export we read.
+	trelloExport struct {
+		Name  string `json:"name"`
+		Lists []struct {
+			ID     string `json:"id"`
+			Closed bool   `json:"closed"`
+		} `json:"lists"`
+		Cards []struct {
+			Name        string `json:"name"`
+			Desc        string `json:"desc"`
+			IDList      string `json:"idList"`
+			Closed      bool   `json:"closed"`
+			Due         string `json:"due"`
+			DueComplete bool   `json:"dueComplete"`
+			Labels      []struct {
+				Name  string `json:"name"`
+				Color string `json:"color"`
+			} `json:"labels"`
+		} `json:"cards"`
+	}
+)
+
+// rawID reads a Todoist id, which is a string in current exports and a
+// number in older ones.
+func rawID(raw json.RawMessage) string {
+	return strings.Trim(string(raw), `"`)
+}
+
+// todoistPriorities maps Todoist's priorities, where 4 is the most urgent,
+// onto ours.
+var todoistPriorities = map[int]string{4: priorityHigh, 3: priorityMedium, 2: priorityLow}
+
+// readTodoistExport turns Todoist projects into lists, apart from the
+// Inbox, whose tasks get no list, and labels into tags.
+func readTodoistExport(data []byte) (importBatch, error) {
+	var export todoistExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return importBatch{}, err
+	}
+	if export.Items == nil {
+		return importBatch{}, errors.New("no items found; expected a Todoist sync export")
+	}
+
+	var b importBatch
+	projects := map[string]int{}
+	for _, p := range export.Projects {
+		if p.IsDeleted || p.InboxProject {
+			continue
+		}
+		projects[rawID(p.ID)] = len(b.lists)
+		b.lists = append(b.lists, p.Name)
+	}
+	labels := map[string]string{}
+	for _, l := range export.Labels {
+		labels[rawID(l.ID)] = l.Name
+	}
+
+	for _, item := range export.Items {
+		if item.IsDeleted || strings.TrimSpace(item.Content) == "" {
+			b.skipped++
+			continue
+		}
+		var tags []string
+		for _, raw := range item.Labels {
+			// Older exports list label ids, newer ones label names.
+			if name, ok := labels[rawID(raw)]; ok {
+				tags = append(tags, name)
+			} else {
+				tags = append(tags, rawID(raw))
+			}
+		}
+		var due *time.Time
+		if item.Due != nil {
+			due = parseImportTime(item.Due.Date)
+		}
+		var completedAt *time.Time
+		if item.Checked {
+			if completedAt = parseImportTime(item.CompletedAt); completedAt == nil {
+				now := time.Now()
+				completedAt = &now
+			}
+		}
+
+		list := -1
+		if i, ok := projects[rawID(item.ProjectID)]; ok {
+			list = i
+		}
+		b.todos = append(b.todos, importedTodo{
+			todo: newImportedTodo(item.Content, item.Description, tags, todoistPriorities[item.Priority], due, completedAt),
+			list: list,
+		})
+	}
+	return b, nil
+}
+
+// readTrelloExport turns the board into a list and card labels into tags.
+// Cards with their due date marked complete are completed. Archived cards,
+// and cards on archived lists, are skipped.
+func readTrelloExport(data []byte) (importBatch, error) {
+	var export trelloExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return importBatch{}, err
+	}
+	if export.Cards == nil {
+		return importBatch{}, errors.New("no cards found; expected a Trello board export")
+	}
+
+	b := importBatch{lists: []string{export.Name}}
+	closed := map[string]bool{}
+	for _, l := range export.Lists {
+		closed[l.ID] = l.Closed
+	}
+
+	for _, card := range export.Cards {
+		if card.Closed || closed[card.IDList] || strings.TrimSpace(card.Name) == "" {
+			b.skipped++
+			continue
+		}
+		var tags []string
+		for _, l := range card.Labels {
+			// Labels can be just a colour.
+			if l.Name != "" {
+				tags = append(tags, l.Name)
+			} else {
+				tags = append(tags, l.Color)
+			}
+		}
+		var completedAt *time.Time
+		if card.DueComplete {
+			now := time.Now()
+			completedAt = &now
+		}
+		b.todos = append(b.todos, importedTodo{
+			todo: newImportedTodo(card.Name, card.Desc, tags, "", parseImportTime(card.Due), completedAt),
+			list: 0,
+		})
+	}
+	return b, nil
+}
+
+// importTodos creates todos from the JSON export of another service, named
+// in the URL, and reports what it created.
+func importTodos(w http.ResponseWriter, r *http.Request) {
+	source := chi.URLParam(r, "source")
+	read, ok := importers[source]
+	if !ok {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Unknown import source",
+			Error:   "Imports are available from todoist and trello",
+		})
+		return
+	}
+
+	var data json.RawMessage
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&data)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, errorResponse{
+			Message: "Failed to import todos",
+			Error:   fmt.Sprintf("Exports must be at most %d bytes", maxImportSize),
+		})
+		return
+	}
+	var b importBatch
+	if err == nil {
+		b, err = read(data)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to import todos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	lists, err := svc.importTodos(ctx, requestCaller(r), b)
+	if quotaExceeded(w, "Failed to import todos", err) {
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to import todos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	res := importResult{Lists: make([]importedList, 0, len(lists)), Todos: len(b.todos), Skipped: b.skipped}
+	for _, l := range lists {
+		res.Lists = append(res.Lists, importedList{ID: l.ID.Hex(), Name: l.Name})
+	}
+	rnd.JSON(w, http.StatusCreated, itemResponse[importResult]{
+		Message: fmt.Sprintf("Imported %d todos", len(b.todos)),
+		Data:    res,
+	})
+}
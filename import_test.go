@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReadTodoistExport(t *testing.T) {
+	b, err := readTodoistExport([]byte(`{
+		"projects": [
+			{"id": "1", "name": "Inbox", "inbox_project": true},
+			{"id": "2", "name": "Home"},
+			{"id": 3, "name": "Gone", "is_deleted": true}
+		],
+		"labels": [{"id": "10", "name": "Errands"}],
+		"items": [
+			{"content": "Buy milk", "project_id": "1", "labels": ["Errands"], "priority": 4},
+			{"content": "Fix door", "project_id": "2", "labels": [10], "due": {"date": "2026-10-20"}},
+			{"content": "Old", "project_id": "2", "checked": true, "completed_at": "2026-01-02T03:04:05Z"},
+			{"content": "Deleted", "is_deleted": true},
+			{"content": "  "}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(b.lists, []string{"Home"}) {
+		t.Errorf("lists = %v, want [Home]", b.lists)
+	}
+	if len(b.todos) != 3 || b.skipped != 2 {
+		t.Fatalf("got %d todos and %d skipped, want 3 and 2", len(b.todos), b.skipped)
+	}
+
+	milk, door, old := b.todos[0], b.todos[1], b.todos[2]
+	if milk.list != -1 || milk.todo.Priority != priorityHigh || !slices.Equal(milk.todo.Tags, []string{"errands"}) {
+		t.Errorf("Buy milk = list %d, %+v", milk.list, milk.todo)
+	}
+	if door.list != 0 || door.todo.DueDate == nil || door.todo.DueDate.Format("2006-01-02") != "2026-10-20" ||
+		!slices.Equal(door.todo.Tags, []string{"errands"}) {
+		t.Errorf("Fix door = list %d, %+v", door.list, door.todo)
+	}
+	if !old.todo.Completed || old.todo.CompletedAt == nil || old.todo.CompletedAt.Year() != 2026 {
+		t.Errorf("Old = %+v", old.todo)
+	}
+}
+
+func TestReadTrelloExport(t *testing.T) {
+	b, err := readTrelloExport([]byte(`{
+		"name": "Sprint",
+		"lists": [{"id": "a"}, {"id": "b", "closed": true}],
+		"cards": [
+			{"name": "Write docs", "idList": "a", "labels": [{"name": "Docs"}, {"color": "red"}], "due": "2026-10-20T12:00:00.000Z"},
+			{"name": "Ship", "idList": "a", "dueComplete": true},
+			{"name": "Archived", "idList": "a", "closed": true},
+			{"name": "On closed list", "idList": "b"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(b.lists, []string{"Sprint"}) || len(b.todos) != 2 || b.skipped != 2 {
+		t.Fatalf("lists %v, %d todos, %d skipped", b.lists, len(b.todos), b.skipped)
+	}
+	docs := b.todos[0].todo
+	if !slices.Equal(docs.Tags, []string{"docs", "red"}) || docs.DueDate == nil || docs.DueDate.Hour() != 12 {
+		t.Errorf("Write docs = %+v", docs)
+	}
+	if !b.todos[1].todo.Completed {
+		t.Errorf("Ship isn't completed")
+	}
+}
+
+func TestReadExportRejectsOtherFormats(t *testing.T) {
+	if _, err := readTodoistExport([]byte(`{"cards": []}`)); err == nil {
+		t.Error("readTodoistExport accepted a Trello export")
+	}
+	if _, err := readTrelloExport([]byte(`{"items": []}`)); err == nil {
+		t.Error("readTrelloExport accepted a Todoist export")
+	}
+}
@@ -0,0 +1,693 @@
+//go:build integration
+
+package main
+
+// The tests in this file run the handlers against a real MongoDB:
+//
+//	go test -tags integration ./...
+//
+// They use MONGO_TEST_URI when it is set and otherwise start a throwaway
+// mongo container with testcontainers, which needs docker. Each test gets a database of its own, which
+// is dropped afterwards.
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var testClient *mongo.Client
+
+func TestMain(m *testing.M) {
+	os.Exit(runWithMongo(m))
+}
+
+func runWithMongo(m *testing.M) int {
+	if err := setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		container, err := mongodb.Run(context.Background(), "mongo:7")
+		defer func() {
+			if err := testcontainers.TerminateContainer(container); err != nil {
+				fmt.Fprintf(os.Stderr, "Removing the MongoDB container failed: %v\n", err)
+			}
+		}()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Starting MongoDB failed, set MONGO_TEST_URI or install docker: %v\n", err)
+			return 1
+		}
+		if uri, err = container.ConnectionString(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "MongoDB container has no address: %v\n", err)
+			return 1
+		}
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "MongoDB client setup failed: %v\n", err)
+		return 1
+	}
+	defer client.Disconnect(context.Background())
+
+	// A server given in MONGO_TEST_URI may still be starting up.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err = client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintf(os.Stderr, "MongoDB at %s didn't answer: %v\n", uri, err)
+			return 1
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	testClient = client
+	return m.Run()
+}
+
+// integrationService points the handlers at an empty database of the test's
+// own.
+func integrationService(t *testing.T) {
+	t.Helper()
+
+	name := make([]byte, 6)
+	rand.Read(name)
+	db := testClient.Database("todo_test_" + hex.EncodeToString(name))
+	t.Cleanup(func() { db.Drop(context.Background()) })
+
+	old := svc
+	svc = newTodoService(db, &gridFSStore{db: db}, nil, newEventBus(nil))
+	store = svc
+	t.Cleanup(func() { svc, store = old, old })
+
+	if err := svc.ensureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestTodo creates a todo as user and returns it.
+func createTestTodo(t *testing.T, user, body string) todo {
+	t.Helper()
+	rec := serve(t, http.MethodPost, "/todo/", user, body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating %s: status = %d; body %s", body, rec.Code, rec.Body)
+	}
+	var resp todoResponse
+	decodeBody(t, rec, &resp)
+	return resp.Data
+}
+
+func TestIntegrationCRUD(t *testing.T) {
+	integrationService(t)
+
+	created := createTestTodo(t, "ann", `{"title":"Buy milk","tags":["Errands"],"priority":"high"}`)
+	if created.ID == "" || created.Title != "Buy milk" || created.OwnerID != "ann" || created.Priority != priorityHigh {
+		t.Fatalf("created %+v", created)
+	}
+
+	rec := serve(t, http.MethodGet, "/todo/"+created.ID, "ann", "")
+	var fetched todoResponse
+	decodeBody(t, rec, &fetched)
+	if rec.Code != http.StatusOK || fetched.Data.Title != "Buy milk" || len(fetched.Data.Tags) != 1 || fetched.Data.Tags[0] != "errands" {
+		t.Fatalf("fetch: status %d, %+v", rec.Code, fetched.Data)
+	}
+
+	rec = serve(t, http.MethodPut, "/todo/"+created.ID, "ann", `{"title":"Buy oat milk","completed":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status %d; body %s", rec.Code, rec.Body)
+	}
+	rec = serve(t, http.MethodGet, "/todo/"+created.ID, "ann", "")
+	decodeBody(t, rec, &fetched)
+	if fetched.Data.Title != "Buy oat milk" || !fetched.Data.Completed {
+		t.Fatalf("after update: %+v", fetched.Data)
+	}
+
+	// Someone else can't see or change it.
+	if rec := serve(t, http.MethodGet, "/todo/"+created.ID, "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("fetch as another user: status %d", rec.Code)
+	}
+	if rec := serve(t, http.MethodDelete, "/todo/"+created.ID, "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("delete as another user: status %d", rec.Code)
+	}
+
+	if rec := serve(t, http.MethodDelete, "/todo/"+created.ID, "ann", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d; body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, http.MethodGet, "/todo/"+created.ID, "ann", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("fetch after delete: status %d", rec.Code)
+	}
+	if rec := serve(t, http.MethodDelete, "/todo/"+created.ID, "ann", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d", rec.Code)
+	}
+}
+
+func TestIntegrationListing(t *testing.T) {
+	integrationService(t)
+
+	createTestTodo(t, "ann", `{"title":"Cherry"}`)
+	createTestTodo(t, "ann", `{"title":"apple","completed":true}`)
+	createTestTodo(t, "ann", `{"title":"Banana"}`)
+	createTestTodo(t, "bob", `{"title":"Bob's"}`)
+
+	titles := func(target, user string) []string {
+		t.Helper()
+		rec := serve(t, http.MethodGet, target, user, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d; body %s", target, rec.Code, rec.Body)
+		}
+		var resp listResponse[todo]
+		decodeBody(t, rec, &resp)
+		var out []string
+		for _, td := range resp.Data {
+			out = append(out, td.Title)
+		}
+		return out
+	}
+
+	tests := []struct {
+		target, user string
+		want         string
+	}{
+		{"/todo/", "ann", "Cherry,apple,Banana"},
+		{"/todo/?sort=title", "ann", "apple,Banana,Cherry"},
+		{"/todo/?sort=title&order=desc", "ann", "Cherry,Banana,apple"},
+		{"/todo/?completed=false&sort=title", "ann", "Banana,Cherry"},
+		{"/todo/?completed=true", "ann", "apple"},
+		{"/todo/", "bob", "Bob's"},
+		{"/todo/", "", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(titles(tt.target, tt.user), ","); got != tt.want {
+			t.Errorf("%s as %q = %s, want %s", tt.target, tt.user, got, tt.want)
+		}
+	}
+
+	rec := serve(t, http.MethodGet, "/todo/?fields=title&sort=title", "ann", "")
+	if want := `{"data":[{"title":"apple"}`; !strings.HasPrefix(rec.Body.String(), want) {
+		t.Errorf("fields=title: body %s", rec.Body)
+	}
+}
+
+func TestIntegrationErrors(t *testing.T) {
+	integrationService(t)
+
+	missing := "000000000000000000000001"
+	if rec := serve(t, http.MethodGet, "/todo/"+missing, "ann", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("fetch missing: status %d", rec.Code)
+	}
+	if rec := serve(t, http.MethodPut, "/todo/"+missing, "ann", `{"title":"x"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("update missing: status %d", rec.Code)
+	}
+	if rec := serve(t, http.MethodPost, "/todo/", "ann", `{"title":"x","list_id":"`+missing+`"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("create in missing list: status %d", rec.Code)
+	}
+
+	createTestTodo(t, "ann", `{"title":"Water plants"}`)
+
+	oldDuplicates := duplicateTodos
+	duplicateTodos = duplicatesReject
+	t.Cleanup(func() { duplicateTodos = oldDuplicates })
+	rec := serve(t, http.MethodPost, "/todo/", "ann", `{"title":"water PLANTS"}`)
+	var dup duplicateResponse
+	decodeBody(t, rec, &dup)
+	if rec.Code != http.StatusConflict || dup.DuplicateOf == "" {
+		t.Errorf("duplicate: status %d; body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, http.MethodPost, "/todo/?allow_duplicate=true", "ann", `{"title":"water PLANTS"}`); rec.Code != http.StatusCreated {
+		t.Errorf("allowed duplicate: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	oldMax := maxTodosPerUser
+	maxTodosPerUser = 2
+	t.Cleanup(func() { maxTodosPerUser = oldMax })
+	rec = serve(t, http.MethodPost, "/todo/", "ann", `{"title":"One too many"}`)
+	var quota quotaResponse
+	decodeBody(t, rec, &quota)
+	if rec.Code != http.StatusForbidden || quota.Quota.Limit != 2 || quota.Quota.Used != 2 {
+		t.Errorf("over quota: status %d; body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, http.MethodPost, "/todo/", "", `{"title":"Anonymous"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous while quotas are on: status %d; body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIntegrationWorkspaces(t *testing.T) {
+	integrationService(t)
+
+	serveIn := func(method, target, workspace, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, target, r)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set(authUserHeader, "ann")
+		req.Header.Set(workspaceHeader, workspace)
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serveIn(http.MethodPost, "/todo/", "acme", `{"title":"Acme roadmap"}`)
+	var created todoResponse
+	decodeBody(t, rec, &created)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d; body %s", rec.Code, rec.Body)
+	}
+	createTestTodo(t, "ann", `{"title":"Default inbox"}`)
+
+	if rec := serveIn(http.MethodGet, "/todo/"+created.Data.ID, "acme", ""); rec.Code != http.StatusOK {
+		t.Errorf("fetch in acme: status %d", rec.Code)
+	}
+	for _, workspace := range []string{"globex", ""} {
+		if rec := serveIn(http.MethodGet, "/todo/"+created.Data.ID, workspace, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("fetch in %q: status %d", workspace, rec.Code)
+		}
+		if rec := serveIn(http.MethodDelete, "/todo/"+created.Data.ID, workspace, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("delete in %q: status %d", workspace, rec.Code)
+		}
+	}
+
+	var listed listResponse[todo]
+	decodeBody(t, serveIn(http.MethodGet, "/todo/", "acme", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].Title != "Acme roadmap" {
+		t.Errorf("acme lists %+v", listed.Data)
+	}
+	decodeBody(t, serve(t, http.MethodGet, "/todo/", "ann", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].Title != "Default inbox" {
+		t.Errorf("default workspace lists %+v", listed.Data)
+	}
+	// Usage, and so quotas, only count the workspace's own todos.
+	createTestTodo(t, "ann", `{"title":"Second inbox"}`)
+	var used itemResponse[usage]
+	decodeBody(t, serveIn(http.MethodGet, "/me/usage", "acme", ""), &used)
+	if used.Data.Todos != 1 {
+		t.Errorf("acme usage = %+v", used.Data)
+	}
+}
+
+func TestIntegrationCompletion(t *testing.T) {
+	integrationService(t)
+
+	created := createTestTodo(t, "ann", `{"title":"Water plants"}`)
+
+	rec := serve(t, http.MethodPost, "/todo/"+created.ID+"/complete", "ann", "")
+	var resp todoResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || !resp.Data.Completed || resp.Data.CompletedAt == "" {
+		t.Fatalf("complete: status %d; body %s", rec.Code, rec.Body)
+	}
+	completedAt := resp.Data.CompletedAt
+
+	// Completing it again keeps the first completion time.
+	rec = serve(t, http.MethodPost, "/todo/"+created.ID+"/complete", "ann", "")
+	decodeBody(t, rec, &resp)
+	if resp.Data.CompletedAt != completedAt {
+		t.Errorf("completed_at moved from %s to %s", completedAt, resp.Data.CompletedAt)
+	}
+
+	var listed listResponse[todo]
+	decodeBody(t, serve(t, http.MethodGet, "/todo/?completed_on=today", "ann", ""), &listed)
+	if len(listed.Data) != 1 {
+		t.Errorf("completed today: %+v", listed.Data)
+	}
+	var stats itemResponse[todoStats]
+	decodeBody(t, serve(t, http.MethodGet, "/todo/stats", "ann", ""), &stats)
+	if stats.Data.CompletedToday != 1 || stats.Data.AvgTimeToComplete < 0 {
+		t.Errorf("stats = %+v", stats.Data)
+	}
+
+	if rec := serve(t, http.MethodPost, "/todo/"+created.ID+"/complete", "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("complete as another user: status %d", rec.Code)
+	}
+
+	rec = serve(t, http.MethodPost, "/todo/"+created.ID+"/uncomplete", "ann", "")
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.Data.Completed || resp.Data.CompletedAt != "" {
+		t.Errorf("uncomplete: status %d; body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIntegrationStarring(t *testing.T) {
+	integrationService(t)
+
+	first := createTestTodo(t, "ann", `{"title":"Sweep"}`)
+	second := createTestTodo(t, "ann", `{"title":"Renew passport"}`)
+
+	rec := serve(t, http.MethodPost, "/todo/"+second.ID+"/star", "ann", "")
+	var resp todoResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || !resp.Data.Starred {
+		t.Fatalf("star: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	// Starred todos come first in the default order.
+	var listed listResponse[todo]
+	decodeBody(t, serve(t, http.MethodGet, "/todo/", "ann", ""), &listed)
+	if len(listed.Data) != 2 || listed.Data[0].ID != second.ID || listed.Data[1].ID != first.ID {
+		t.Errorf("listing = %+v", listed.Data)
+	}
+	decodeBody(t, serve(t, http.MethodGet, "/todo/?starred=true", "ann", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].ID != second.ID {
+		t.Errorf("starred = %+v", listed.Data)
+	}
+	decodeBody(t, serve(t, http.MethodGet, "/todo/?starred=false", "ann", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].ID != first.ID {
+		t.Errorf("unstarred = %+v", listed.Data)
+	}
+
+	if rec := serve(t, http.MethodPost, "/todo/"+second.ID+"/star", "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("star as another user: status %d", rec.Code)
+	}
+
+	rec = serve(t, http.MethodPost, "/todo/"+second.ID+"/unstar", "ann", "")
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.Data.Starred {
+		t.Errorf("unstar: status %d; body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIntegrationClone(t *testing.T) {
+	integrationService(t)
+
+	src := createTestTodo(t, "ann", `{"title":"Weekly review","description":"- [x] Inbox\n- [ ] Calendar","tags":["work"],"priority":"high","due_date":"2026-10-20"}`)
+	serve(t, http.MethodPost, "/todo/"+src.ID+"/complete", "ann", "")
+
+	rec := serve(t, http.MethodPost, "/todo/"+src.ID+"/clone", "ann", "")
+	var resp todoResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("clone: status %d; body %s", rec.Code, rec.Body)
+	}
+	got := resp.Data
+	if got.ID == src.ID || got.Title != "Weekly review" || got.Priority != "high" ||
+		len(got.Tags) != 1 || got.Completed || got.DueDate != "" ||
+		got.Description != "- [ ] Inbox\n- [ ] Calendar" {
+		t.Errorf("clone = %+v", got)
+	}
+
+	if rec := serve(t, http.MethodPost, "/todo/"+src.ID+"/clone", "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("clone as another user: status %d", rec.Code)
+	}
+	if rec := serve(t, http.MethodPost, "/todo/"+src.ID+"/clone", "ann", `{"list_id":"000000000000000000000001"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("clone into a missing list: status %d", rec.Code)
+	}
+}
+
+func TestIntegrationAssignment(t *testing.T) {
+	integrationService(t)
+
+	var list itemResponse[sharedList]
+	decodeBody(t, serve(t, http.MethodPost, "/lists/", "ann", `{"name":"Chores"}`), &list)
+	if rec := serve(t, http.MethodPut, "/lists/"+list.Data.ID+"/members/bob", "ann", `{"role":"editor"}`); rec.Code != http.StatusOK {
+		t.Fatalf("add member: status %d; body %s", rec.Code, rec.Body)
+	}
+	shared := createTestTodo(t, "ann", `{"title":"Take out bins","list_id":"`+list.Data.ID+`"}`)
+	personal := createTestTodo(t, "ann", `{"title":"Dentist"}`)
+
+	events, unsubscribe := svc.events.subscribe()
+	defer unsubscribe()
+
+	rec := serve(t, http.MethodPatch, "/todo/"+shared.ID, "ann", `{"assignee_id":"bob"}`)
+	var resp todoResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp.Data.AssigneeID != "bob" {
+		t.Fatalf("assign: status %d; body %s", rec.Code, rec.Body)
+	}
+	assigned := false
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == eventTodoAssigned && ev.AssigneeID == "bob" {
+			assigned = true
+		}
+	}
+	if !assigned {
+		t.Error("no todo.assigned event")
+	}
+
+	var listed listResponse[todo]
+	decodeBody(t, serve(t, http.MethodGet, "/todo/?assignee=me", "bob", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].ID != shared.ID {
+		t.Errorf("bob's assignments = %+v", listed.Data)
+	}
+	decodeBody(t, serve(t, http.MethodGet, "/todo/?assignee=me", "ann", ""), &listed)
+	if len(listed.Data) != 0 {
+		t.Errorf("ann's assignments = %+v", listed.Data)
+	}
+
+	for _, tc := range []struct{ id, body string }{
+		{shared.ID, `{"assignee_id":"carol"}`},
+		{personal.ID, `{"assignee_id":"bob"}`},
+	} {
+		if rec := serve(t, http.MethodPatch, "/todo/"+tc.id, "ann", tc.body); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("PATCH %s %s: status %d", tc.id, tc.body, rec.Code)
+		}
+	}
+
+	// Leaving the list drops the assignment.
+	if rec := serve(t, http.MethodDelete, "/lists/"+list.Data.ID+"/members/bob", "bob", ""); rec.Code >= 300 {
+		t.Fatalf("leave: status %d; body %s", rec.Code, rec.Body)
+	}
+	decodeBody(t, serve(t, http.MethodGet, "/todo/"+shared.ID, "ann", ""), &resp)
+	if resp.Data.AssigneeID != "" {
+		t.Errorf("assignee after leaving = %q", resp.Data.AssigneeID)
+	}
+}
+
+func TestIntegrationUndoReassignment(t *testing.T) {
+	integrationService(t)
+
+	var list itemResponse[sharedList]
+	decodeBody(t, serve(t, http.MethodPost, "/lists/", "ann", `{"name":"Chores"}`), &list)
+	for _, member := range []string{"bob", "carol"} {
+		if rec := serve(t, http.MethodPut, "/lists/"+list.Data.ID+"/members/"+member, "ann", `{"role":"editor"}`); rec.Code != http.StatusOK {
+			t.Fatalf("add %s: status %d; body %s", member, rec.Code, rec.Body)
+		}
+	}
+	created := createTestTodo(t, "ann", `{"title":"Mow the lawn","list_id":"`+list.Data.ID+`"}`)
+	for _, assignee := range []string{"bob", "carol"} {
+		if rec := serve(t, http.MethodPatch, "/todo/"+created.ID, "ann", `{"assignee_id":"`+assignee+`"}`); rec.Code != http.StatusOK {
+			t.Fatalf("assign %s: status %d; body %s", assignee, rec.Code, rec.Body)
+		}
+	}
+
+	events, unsubscribe := svc.events.subscribe()
+	defer unsubscribe()
+
+	if rec := serve(t, http.MethodPost, "/todo/"+created.ID+"/undo", "ann", ""); rec.Code != http.StatusOK {
+		t.Fatalf("undo: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	var resp todoResponse
+	decodeBody(t, serve(t, http.MethodGet, "/todo/"+created.ID, "ann", ""), &resp)
+	if resp.Data.AssigneeID != "bob" {
+		t.Errorf("assignee after undo = %q", resp.Data.AssigneeID)
+	}
+
+	updated := false
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == eventTodoUpdated {
+			updated = true
+			if ev.AssigneeID != "bob" {
+				t.Errorf("todo.updated event assignee = %q", ev.AssigneeID)
+			}
+		}
+	}
+	if !updated {
+		t.Error("no todo.updated event")
+	}
+
+	var history listResponse[historyEntry]
+	decodeBody(t, serve(t, http.MethodGet, "/todo/"+created.ID+"/history", "ann", ""), &history)
+	found := false
+	for _, e := range history.Data {
+		if e.Action != actionUndo {
+			continue
+		}
+		found = true
+		if len(e.Changes) != 1 || e.Changes[0].Field != "assignee_id" || e.Changes[0].From != "carol" || e.Changes[0].To != "bob" {
+			t.Errorf("undo changes = %+v", e.Changes)
+		}
+	}
+	if !found {
+		t.Errorf("no undo in history %+v", history.Data)
+	}
+}
+
+func TestIntegrationUndoStar(t *testing.T) {
+	integrationService(t)
+
+	created := createTestTodo(t, "ann", `{"title":"Water the plants"}`)
+	if rec := serve(t, http.MethodPost, "/todo/"+created.ID+"/star", "ann", ""); rec.Code != http.StatusOK {
+		t.Fatalf("star: status %d; body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, http.MethodPost, "/todo/"+created.ID+"/undo", "ann", ""); rec.Code != http.StatusOK {
+		t.Fatalf("undo: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	var resp todoResponse
+	decodeBody(t, serve(t, http.MethodGet, "/todo/"+created.ID, "ann", ""), &resp)
+	if resp.Data.Starred {
+		t.Error("todo still starred after undo")
+	}
+}
+
+func TestIntegrationUndoDeleteChecksQuota(t *testing.T) {
+	integrationService(t)
+	old := maxTodosPerUser
+	maxTodosPerUser = 1
+	t.Cleanup(func() { maxTodosPerUser = old })
+
+	deleted := createTestTodo(t, "ann", `{"title":"First"}`)
+	if rec := serve(t, http.MethodDelete, "/todo/"+deleted.ID, "ann", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d; body %s", rec.Code, rec.Body)
+	}
+	createTestTodo(t, "ann", `{"title":"Second"}`)
+
+	rec := serve(t, http.MethodPost, "/todo/"+deleted.ID+"/undo", "ann", "")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), quotaTodos) {
+		t.Errorf("undo over quota: status %d; body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIntegrationBackupRestore(t *testing.T) {
+	integrationService(t)
+	old := adminUsers
+	adminUsers = []string{"root"}
+	t.Cleanup(func() { adminUsers = old })
+
+	kept := createTestTodo(t, "ann", `{"title":"Keep me","due_date":"2026-10-20"}`)
+
+	rec := serve(t, http.MethodGet, "/admin/backup", "root", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("backup: status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	backup := rec.Body.String()
+
+	createTestTodo(t, "ann", `{"title":"Made after the backup"}`)
+	serve(t, http.MethodDelete, "/todo/"+kept.ID, "ann", "")
+
+	restore := func(target string) restoreResult {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(backup))
+		req.Header.Set(authUserHeader, "root")
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, req)
+		var resp itemResponse[restoreResult]
+		decodeBody(t, rec, &resp)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d; body %s", target, rec.Code, rec.Body)
+		}
+		return resp.Data
+	}
+
+	if res := restore("/admin/restore?dry_run=true"); !res.DryRun || res.Collections[collName] != 1 {
+		t.Errorf("dry run = %+v", res)
+	}
+	var listed listResponse[todo]
+	decodeBody(t, serve(t, http.MethodGet, "/todo/", "ann", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].Title != "Made after the backup" {
+		t.Fatalf("after dry run: %+v", listed.Data)
+	}
+
+	restore("/admin/restore")
+	decodeBody(t, serve(t, http.MethodGet, "/todo/", "ann", ""), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].ID != kept.ID || listed.Data[0].DueDate != kept.DueDate {
+		t.Errorf("after restore: %+v", listed.Data)
+	}
+}
+
+func TestIntegrationAccount(t *testing.T) {
+	integrationService(t)
+
+	var list itemResponse[sharedList]
+	decodeBody(t, serve(t, http.MethodPost, "/lists/", "ann", `{"name":"Chores"}`), &list)
+	if rec := serve(t, http.MethodPut, "/lists/"+list.Data.ID+"/members/bob", "ann", `{"role":"editor"}`); rec.Code != http.StatusOK {
+		t.Fatalf("add member: status %d; body %s", rec.Code, rec.Body)
+	}
+	mine := createTestTodo(t, "ann", `{"title":"Dentist"}`)
+	bobs := createTestTodo(t, "bob", `{"title":"Take out bins","list_id":"`+list.Data.ID+`"}`)
+	other := createTestTodo(t, "bob", `{"title":"Water plants"}`)
+
+	rec := serve(t, http.MethodGet, "/me/export", "ann", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("export isn't a zip: %v", err)
+	}
+	files := map[string]bool{}
+	for _, f := range zr.File {
+		files[f.Name] = true
+	}
+	for _, name := range []string{"account.json", "todos.json", "lists.json", "history.json", "api_keys.json", "attachments.json"} {
+		if !files[name] {
+			t.Errorf("export has no %s", name)
+		}
+	}
+
+	rec = serve(t, http.MethodDelete, "/me", "ann", "")
+	var resp itemResponse[accountDeletion]
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusAccepted || resp.Data.Status != deletionPending {
+		t.Fatalf("delete: status %d; body %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var d accountDeletionModel
+		id, _ := primitive.ObjectIDFromHex(resp.Data.ID)
+		if err := svc.deletions.FindOne(context.Background(), bson.M{"_id": id}).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Status == deletionDone {
+			break
+		}
+		if d.Status == deletionFailed || time.Now().After(deadline) {
+			t.Fatalf("deletion %s: %s", d.Status, d.Error)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if rec := serve(t, http.MethodGet, "/todo/"+mine.ID, "ann", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("ann's todo after deletion: status %d", rec.Code)
+	}
+	// Bob's todos stay his; the one on ann's list is no longer on a list.
+	var got todoResponse
+	decodeBody(t, serve(t, http.MethodGet, "/todo/"+bobs.ID, "bob", ""), &got)
+	if got.Data.ID != bobs.ID || got.Data.ListID != "" {
+		t.Errorf("bob's list todo after deletion = %+v", got.Data)
+	}
+	if rec := serve(t, http.MethodGet, "/todo/"+other.ID, "bob", ""); rec.Code != http.StatusOK {
+		t.Errorf("bob's todo after deletion: status %d", rec.Code)
+	}
+	if rec := serve(t, http.MethodGet, "/lists/"+list.Data.ID, "bob", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("ann's list after deletion: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const listsCollName = "lists"
+
+var (
+	errListNotFound  = errors.New("list not found")
+	errInvalidMember = errors.New("the list owner cannot be changed")
+)
+
+// memberRole is what a user may do with a shared list and its todos.
+// Owners manage members, editors change todos and viewers only read them.
+type memberRole string
+
+const (
+	roleOwner  memberRole = "owner"
+	roleEditor memberRole = "editor"
+	roleViewer memberRole = "viewer"
+)
+
+func (r memberRole) canWrite() bool {
+	return r == roleOwner || r == roleEditor
+}
+
+type (
+	listModel struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		Name        string             `bson:"name"`
+		OwnerID     string             `bson:"owner_id"`
+		WorkspaceID string             `bson:"workspace_id,omitempty"`
+		Members     []listMember       `bson:"members"`
+		CreatedAt   time.Time          `bson:"created_at"`
+		UpdatedAt   time.Time          `bson:"updated_at"`
+	}
+
+	listMember struct {
+		UserID string     `bson:"user_id" json:"user_id"`
+		Role   memberRole `bson:"role" json:"role"`
+	}
+
+	sharedList struct {
+		ID        string       `json:"id"`
+		Name      string       `json:"name"`
+		OwnerID   string       `json:"owner_id"`
+		Role      memberRole   `json:"role"`
+		Members   []listMember `json:"members"`
+		CreatedAt string       `json:"created_at"`
+		UpdatedAt string       `json:"updated_at"`
+	}
+)
+
+func (l listModel) roleOf(userID string) memberRole {
+	if userID == "" {
+		return ""
+	}
+	if l.OwnerID == userID {
+		return roleOwner
+	}
+	for _, m := range l.Members {
+		if m.UserID == userID {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+func newSharedList(l listModel, userID string) sharedList {
+	members := l.Members
+	if members == nil {
+		members = []listMember{}
+	}
+	return sharedList{
+		ID:        l.ID.Hex(),
+		Name:      l.Name,
+		OwnerID:   l.OwnerID,
+		Role:      l.roleOf(userID),
+		Members:   members,
+		CreatedAt: l.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: l.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// memberFilter matches the lists userID owns or was invited to.
+func memberFilter(userID string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"owner_id": userID},
+		bson.M{"members.user_id": userID},
+	}}
+}
+
+// visibleFilter matches the todos c may read: todos in lists c is a member
+// of, c's own todos outside any list, and todos created anonymously, all in
+// c's workspace.
+func (s *todoService) visibleFilter(ctx context.Context, c caller) (bson.M, error) {
+	or := bson.A{bson.M{"list_id": nil, "owner_id": nil}}
+	if c.userID != "" {
+		or = append(or, bson.M{"list_id": nil, "owner_id": c.userID})
+
+		cursor, err := s.lists.Find(ctx, c.scoped(memberFilter(c.userID)), options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var lists []listModel
+		if err := cursor.All(ctx, &lists); err != nil {
+			return nil, err
+		}
+		if len(lists) > 0 {
+			ids := make(bson.A, 0, len(lists))
+			for _, l := range lists {
+				ids = append(ids, l.ID)
+			}
+			or = append(or, bson.M{"list_id": bson.M{"$in": ids}})
+		}
+	}
+	return c.scoped(bson.M{"$or": or}), nil
+}
+
+// authorize checks that c may read, or with write set change, t. Callers
+// who cannot see a todo at all, including everyone outside its workspace,
+// get errTodoNotFound rather than errForbidden so its existence isn't
+// leaked. Todos outside any list that have no owner are read-only, to all
+// but admins, once users can sign in; until then every request is
+// anonymous and may change them.
+func (s *todoService) authorize(ctx context.Context, c caller, t todoModel, write bool) error {
+	if t.WorkspaceID != c.workspace {
+		return errTodoNotFound
+	}
+	if t.ListID != nil {
+		role, err := s.listRole(ctx, c, *t.ListID)
+		if errors.Is(err, errListNotFound) {
+			return errTodoNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if write && !role.canWrite() {
+			return errForbidden
+		}
+		return nil
+	}
+	if t.OwnerID == "" {
+		if write && !c.isAdmin() && (signInEnabled() || c.userID != "") {
+			return errForbidden
+		}
+		return nil
+	}
+	if t.OwnerID != c.userID {
+		return errTodoNotFound
+	}
+	return nil
+}
+
+// listRole returns c's role in the list, or errListNotFound if c is not a
+// member.
+func (s *todoService) listRole(ctx context.Context, c caller, listID primitive.ObjectID) (memberRole, error) {
+	l, err := s.getList(ctx, c, listID)
+	if err != nil {
+		return "", err
+	}
+	return l.roleOf(c.userID), nil
+}
+
+func (s *todoService) getList(ctx context.Context, c caller, id primitive.ObjectID) (listModel, error) {
+	var l listModel
+	if c.userID == "" {
+		return l, errListNotFound
+	}
+	// Lists aren't cached.
+	if err := storeMissed(ctx); err != nil {
+		return l, err
+	}
+	err := s.lists.FindOne(ctx, c.scoped(bson.M{"$and": bson.A{bson.M{"_id": id}, memberFilter(c.userID)}})).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return l, errListNotFound
+	}
+	return l, err
+}
+
+func (s *todoService) createList(ctx context.Context, c caller, name string) (listModel, error) {
+	l := listModel{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		OwnerID:     c.userID,
+		WorkspaceID: c.workspace,
+		Members:     []listMember{},
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	_, err := s.lists.InsertOne(ctx, l)
+	return l, err
+}
+
+func (s *todoService) listsFor(ctx context.Context, c caller) ([]listModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := s.lists.Find(ctx, c.scoped(memberFilter(c.userID)), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var lists []listModel
+	if err := cursor.All(ctx, &lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// setListMember invites userID to the list, or changes their role if they
+// are already a member. Only the owner may manage members.
+func (s *todoService) setListMember(ctx context.Context, c caller, listID primitive.ObjectID, userID string, role memberRole) (listModel, error) {
+	l, err := s.getList(ctx, c, listID)
+	if err != nil {
+		return l, err
+	}
+	if l.roleOf(c.userID) != roleOwner {
+		return l, errForbidden
+	}
+	if userID == l.OwnerID {
+		return l, errInvalidMember
+	}
+
+	now := time.Now()
+	res, err := s.lists.UpdateOne(ctx,
+		bson.M{"_id": listID, "members.user_id": userID},
+		bson.M{"$set": bson.M{"members.$.role": role, "updated_at": now}},
+	)
+	if err != nil {
+		return l, err
+	}
+	if res.MatchedCount == 0 {
+		_, err = s.lists.UpdateByID(ctx, listID, bson.M{
+			"$push": bson.M{"members": listMember{UserID: userID, Role: role}},
+			"$set":  bson.M{"updated_at": now},
+		})
+		if err != nil {
+			return l, err
+		}
+	}
+	s.membersChanged(ctx, listID)
+	return s.getList(ctx, c, listID)
+}
+
+// removeListMember removes userID from the list. The owner may remove
+// anyone; other members may only remove themselves.
+func (s *todoService) removeListMember(ctx context.Context, c caller, listID primitive.ObjectID, userID string) error {
+	l, err := s.getList(ctx, c, listID)
+	if err != nil {
+		return err
+	}
+	if userID == l.OwnerID {
+		return errInvalidMember
+	}
+	if l.roleOf(c.userID) != roleOwner && userID != c.userID {
+		return errForbidden
+	}
+
+	_, err = s.lists.UpdateByID(ctx, listID, bson.M{
+		"$pull": bson.M{"members": bson.M{"user_id": userID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.unassignMember(ctx, listID, userID); err != nil {
+		return err
+	}
+	s.membersChanged(ctx, listID)
+	return nil
+}
+
+// membersChanged drops cached todo lists after a membership change, which
+// changes whose lists include the list's todos, and tells subscribers.
+func (s *todoService) membersChanged(ctx context.Context, listID primitive.ObjectID) {
+	if s.cache != nil {
+		s.cache.invalidateLists(context.WithoutCancel(ctx))
+	}
+	s.events.publish(todoEvent{Type: eventListMembers, ListID: listID.Hex(), At: time.Now()})
+}
+
+func writeListError(w http.ResponseWriter, message string, err error) {
+	switch {
+	case errors.Is(err, errListNotFound):
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "List not found",
+		})
+	case errors.Is(err, errForbidden):
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: message,
+			Error:   "Only the list owner can manage members",
+		})
+	case errors.Is(err, errInvalidMember):
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+	default:
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+	}
+}
+
+func fetchLists(w http.ResponseWriter, r *http.Request) {
+	c := requestCaller(r)
+
+	ctx := r.Context()
+
+	lists, err := svc.listsFor(ctx, c)
+	if err != nil {
+		writeListError(w, "Failed to fetch lists", err)
+		return
+	}
+
+	data := []sharedList{}
+	for _, l := range lists {
+		data = append(data, newSharedList(l, c.userID))
+	}
+
+	respond(w, r, http.StatusOK, listResponse[sharedList]{
+		Data: data,
+	})
+}
+
+func createList(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create list",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create list",
+			Error:   "Name is required",
+		})
+		return
+	}
+
+	c := requestCaller(r)
+
+	ctx := r.Context()
+
+	l, err := svc.createList(ctx, c, body.Name)
+	if err != nil {
+		writeListError(w, "Failed to create list", err)
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, itemResponse[sharedList]{
+		Message: "List created successfully",
+		Data:    newSharedList(l, c.userID),
+	})
+}
+
+func fetchList(w http.ResponseWriter, r *http.Request) {
+	listID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	c := requestCaller(r)
+
+	ctx := r.Context()
+
+	l, err := svc.getList(ctx, c, listID)
+	if err != nil {
+		writeListError(w, "Failed to fetch list", err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, itemResponse[sharedList]{
+		Data: newSharedList(l, c.userID),
+	})
+}
+
+func putListMember(w http.ResponseWriter, r *http.Request) {
+	listID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+	userID := strings.TrimSpace(chi.URLParam(r, "userID"))
+
+	var body struct {
+		Role memberRole `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update list member",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if body.Role != roleEditor && body.Role != roleViewer {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update list member",
+			Error:   "Role must be editor or viewer",
+		})
+		return
+	}
+
+	c := requestCaller(r)
+
+	ctx := r.Context()
+
+	l, err := svc.setListMember(ctx, c, listID, userID, body.Role)
+	if err != nil {
+		writeListError(w, "Failed to update list member", err)
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, itemResponse[sharedList]{
+		Message: "List member updated successfully",
+		Data:    newSharedList(l, c.userID),
+	})
+}
+
+func deleteListMember(w http.ResponseWriter, r *http.Request) {
+	listID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+	userID := strings.TrimSpace(chi.URLParam(r, "userID"))
+
+	ctx := r.Context()
+
+	if err := svc.removeListMember(ctx, requestCaller(r), listID, userID); err != nil {
+		writeListError(w, "Failed to remove list member", err)
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "List member removed successfully",
+	})
+}
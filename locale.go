@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// timezoneHeader names the header a client sends its IANA time zone in,
+// such as "Europe/Berlin".
+const timezoneHeader = "X-Timezone"
+
+// defaultLocale is the language messages are written in.
+const defaultLocale = "en"
+
+var (
+	errInvalidTimezone = errors.New("timezone must be an IANA time zone such as Europe/Berlin")
+	errInvalidLocale   = errors.New("locale must be one of " + strings.Join(locales(), ", "))
+)
+
+// preferences are how a signed-in user wants times and messages shown.
+// They are kept with the user and copied into their sessions.
+type preferences struct {
+	Timezone string `bson:"timezone,omitempty" json:"timezone"`
+	Locale   string `bson:"locale,omitempty" json:"locale"`
+}
+
+// catalog translates messages, by locale and English text. Messages missing
+// from a locale, such as those naming a value, stay in English.
+var catalog = map[string]map[string]string{
+	"es": {
+		"Admin access required":           "Se requiere acceso de administrador",
+		"API key not found":               "Clave de API no encontrada",
+		"api key not found":               "clave de API no encontrada",
+		"Attachment not found":            "Adjunto no encontrado",
+		"attachment not found":            "adjunto no encontrado",
+		"Authentication required":         "Se requiere autenticación",
+		"change cannot be undone":         "el cambio no se puede deshacer",
+		"completed must be true or false": "completed debe ser true o false",
+		"due_date must be an RFC 3339 timestamp or a YYYY-MM-DD date": "due_date debe ser una marca de tiempo RFC 3339 o una fecha AAAA-MM-DD",
+		"Failed to create API key":                                    "No se pudo crear la clave de API",
+		"Failed to create list":                                       "No se pudo crear la lista",
+		"Failed to create todo":                                       "No se pudo crear la tarea",
+		"Failed to delete attachment":                                 "No se pudo eliminar el adjunto",
+		"Failed to delete todo":                                       "No se pudo eliminar la tarea",
+		"Failed to download attachment":                               "No se pudo descargar el adjunto",
+		"Failed to fetch attachments":                                 "No se pudieron obtener los adjuntos",
+		"Failed to fetch changes":                                     "No se pudieron obtener los cambios",
+		"Failed to fetch todo history":                                "No se pudo obtener el historial de la tarea",
+		"Failed to fetch todo lists":                                  "No se pudieron obtener las listas",
+		"Failed to fetch todo stats":                                  "No se pudieron obtener las estadísticas",
+		"Failed to fetch todo":                                        "No se pudo obtener la tarea",
+		"Failed to import todos":                                      "No se pudieron importar las tareas",
+		"Failed to save preferences":                                  "No se pudieron guardar las preferencias",
+		"Failed to undo todo change":                                  "No se pudo deshacer el cambio",
+		"Failed to update list member":                                "No se pudo actualizar el miembro de la lista",
+		"Failed to update todo":                                       "No se pudo actualizar la tarea",
+		"Failed to upload attachment":                                 "No se pudo subir el adjunto",
+		"Internal server error":                                       "Error interno del servidor",
+		"Invalid API key":                                             "Clave de API no válida",
+		"Invalid id":                                                  "Identificador no válido",
+		"Invalid list_id":                                             "list_id no válido",
+		"Invalid or missing CSRF token":                               "Token CSRF no válido o ausente",
+		"Invalid query":                                               "Consulta no válida",
+		"Invalid time zone":                                           "Zona horaria no válida",
+		"List not found":                                              "Lista no encontrada",
+		"list not found":                                              "lista no encontrada",
+		"Login failed":                                                "No se pudo iniciar sesión",
+		"not allowed":                                                 "no permitido",
+		"nothing to undo":                                             "no hay nada que deshacer",
+		"order must be asc or desc":                                   "order debe ser asc o desc",
+		"Preferences are kept for signed-in users":                    "Las preferencias solo se guardan para usuarios con sesión iniciada",
+		"Priority must be low, medium or high":                        "La prioridad debe ser low, medium o high",
+		"sort must be one of created_at, updated_at, title or due_date": "sort debe ser created_at, updated_at, title o due_date",
+		"the list owner cannot be changed":                              "el propietario de la lista no se puede cambiar",
+		"This API key is read-only":                                     "Esta clave de API es de solo lectura",
+		"timezone must be an IANA time zone such as Europe/Berlin":      "timezone debe ser una zona horaria IANA como Europe/Berlin",
+		"Title is required":                                             "El título es obligatorio",
+		"Todo not found":                                                "Tarea no encontrada",
+		"todo not found":                                                "tarea no encontrada",
+		"Unknown import source":                                         "Origen de importación desconocido",
+	},
+}
+
+// locales lists the supported locales, English first.
+func locales() []string {
+	names := make([]string, 0, len(catalog))
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{defaultLocale}, names...)
+}
+
+func validLocale(locale string) bool {
+	_, ok := catalog[locale]
+	return ok || locale == defaultLocale
+}
+
+// translate returns msg in locale, or msg itself when there is no
+// translation.
+func translate(locale, msg string) string {
+	if t, ok := catalog[locale][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// acceptedLocale picks the supported locale the Accept-Language header
+// prefers, matching on the language alone ("es-MX" is "es").
+func acceptedLocale(header string) string {
+	best, bestQ := defaultLocale, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if validLocale(lang) && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+func loadTimezone(name string) (*time.Location, error) {
+	// LoadLocation reads "" and "Local" as UTC and the server's zone.
+	if name == "" || name == "Local" {
+		return nil, errInvalidTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errInvalidTimezone
+	}
+	return loc, nil
+}
+
+// location is the time zone times are shown to c in.
+func (c caller) location() *time.Location {
+	if c.tz == nil {
+		return time.UTC
+	}
+	return c.tz
+}
+
+// requestLocale is the locale the request's messages are written in.
+func requestLocale(r *http.Request) string {
+	if locale, ok := r.Context().Value(localeContextKey).(string); ok {
+		return locale
+	}
+	return defaultLocale
+}
+
+// localize picks the time zone and locale of the request: the X-Timezone
+// header, then the signed-in user's preference, then UTC for the former,
+// and the user's preference, then Accept-Language, then English for the
+// latter. Error messages are translated on their way out.
+func localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var prefs preferences
+		if s, ok := r.Context().Value(sessionContextKey).(session); ok {
+			prefs = s.Preferences
+		}
+
+		locale := prefs.Locale
+		if !validLocale(locale) {
+			locale = acceptedLocale(r.Header.Get("Accept-Language"))
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		if locale != defaultLocale {
+			tw := &translatingResponseWriter{ResponseWriter: w, locale: locale}
+			defer tw.close()
+			w = tw
+		}
+
+		tzName := strings.TrimSpace(r.Header.Get(timezoneHeader))
+		if tzName == "" {
+			tzName = prefs.Timezone
+		}
+		tz := time.UTC
+		if tzName != "" {
+			var err error
+			if tz, err = loadTimezone(tzName); err != nil {
+				rnd.JSON(w, http.StatusBadRequest, errorResponse{
+					Message: "Invalid time zone",
+					Error:   err.Error(),
+				})
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), timezoneContextKey, tz)
+		ctx = context.WithValue(ctx, localeContextKey, locale)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// translatingResponseWriter translates the message and error of JSON error
+// responses. Other responses pass through untouched.
+type translatingResponseWriter struct {
+	http.ResponseWriter
+	locale string
+
+	status int
+	buf    *bytes.Buffer // nil unless the response is being translated
+}
+
+func (tw *translatingResponseWriter) WriteHeader(status int) {
+	if tw.status != 0 {
+		return
+	}
+	tw.status = status
+	mediaType, _, _ := mime.ParseMediaType(tw.Header().Get("Content-Type"))
+	if status >= http.StatusBadRequest && mediaType == "application/json" {
+		tw.buf = &bytes.Buffer{}
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *translatingResponseWriter) Write(p []byte) (int, error) {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.buf != nil {
+		return tw.buf.Write(p)
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+func (tw *translatingResponseWriter) Flush() {
+	if tw.buf != nil {
+		return
+	}
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *translatingResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// close sends a held back error response, translated.
+func (tw *translatingResponseWriter) close() {
+	if tw.buf == nil {
+		return
+	}
+	body := tw.buf.Bytes()
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if v, err := readJSONValue(dec); err == nil {
+		if obj, ok := v.(jsonObject); ok {
+			for i, f := range obj {
+				if s, ok := f.value.(string); ok && (f.key == "message" || f.key == "error") {
+					obj[i].value = translate(tw.locale, s)
+				}
+			}
+			if b, err := json.Marshal(obj); err == nil {
+				body = append(b, '\n')
+			}
+		}
+	}
+	tw.Header().Del("Content-Length")
+	tw.ResponseWriter.WriteHeader(tw.status)
+	tw.ResponseWriter.Write(body)
+}
+
+// setPreferences saves a user's preferences.
+func (s *todoService) setPreferences(ctx context.Context, userID primitive.ObjectID, p preferences) error {
+	_, err := s.users.UpdateByID(ctx, userID, bson.M{"$set": bson.M{"preferences": p}})
+	return err
+}
+
+// fetchPreferences answers with the time zone and locale in effect for the
+// request.
+func fetchPreferences(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, itemResponse[preferences]{
+		Data: preferences{
+			Timezone: requestCaller(r).location().String(),
+			Locale:   requestLocale(r),
+		},
+	})
+}
+
+// putPreferences saves the signed-in user's time zone and locale. They
+// apply to the current session at once and to others from their next
+// login.
+func putPreferences(w http.ResponseWriter, r *http.Request) {
+	s, ok := r.Context().Value(sessionContextKey).(session)
+	userID, err := primitive.ObjectIDFromHex(s.UserID)
+	if !ok || err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to save preferences",
+			Error:   "Preferences are kept for signed-in users",
+		})
+		return
+	}
+
+	var p preferences
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to save preferences",
+			Error:   err.Error(),
+		})
+		return
+	}
+	p.Timezone, p.Locale = strings.TrimSpace(p.Timezone), strings.ToLower(strings.TrimSpace(p.Locale))
+	if p.Timezone != "" {
+		if _, err := loadTimezone(p.Timezone); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, errorResponse{
+				Message: "Failed to save preferences",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	if p.Locale != "" && !validLocale(p.Locale) {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to save preferences",
+			Error:   errInvalidLocale.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := svc.setPreferences(ctx, userID, p); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to save preferences",
+			Error:   err.Error(),
+		})
+		return
+	}
+	s.Preferences = p
+	if err := sessions.save(ctx, s); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to save preferences",
+			Error:   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, itemResponse[preferences]{
+		Message: "Preferences saved",
+		Data:    p,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAcceptedLocale(t *testing.T) {
+	tests := map[string]string{
+		"":                        "en",
+		"es":                      "es",
+		"es-MX,es;q=0.9":          "es",
+		"fr-FR,fr;q=0.9":          "en",
+		"en-US,en;q=0.9,es;q=0.8": "en",
+		"fr,es;q=0.5":             "es",
+		"es;q=0":                  "en",
+	}
+	for header, want := range tests {
+		if got := acceptedLocale(header); got != want {
+			t.Errorf("acceptedLocale(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestErrorsAreTranslated(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/todo/", strings.NewReader(`{"description":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9")
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+
+	var resp errorResponse
+	decodeBody(t, rec, &resp)
+	if rec.Code != http.StatusBadRequest || resp.Message != "No se pudo crear la tarea" || resp.Error != "El título es obligatorio" {
+		t.Errorf("status %d; body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTimezoneHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/me/preferences", nil)
+	req.Header.Set(authUserHeader, "ann")
+	req.Header.Set(timezoneHeader, "Mars/Olympus_Mons")
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown zone: status %d; body %s", rec.Code, rec.Body)
+	}
+
+	req.Header.Set(timezoneHeader, "Europe/Berlin")
+	req.Header.Set("Accept-Language", "es")
+	rec = httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	var resp itemResponse[preferences]
+	decodeBody(t, rec, &resp)
+	if resp.Data.Timezone != "Europe/Berlin" || resp.Data.Locale != "es" {
+		t.Errorf("preferences = %+v", resp.Data)
+	}
+}
+
+func TestTodoTimesInTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	due := time.Date(2026, 10, 20, 22, 30, 0, 0, time.UTC)
+	tm := todoModel{ID: primitive.NewObjectID(), DueDate: &due}
+
+	if got := newTodo(tm, berlin).DueDate; got != "2026-10-21T00:30:00+02:00" {
+		t.Errorf("due date in Berlin = %s", got)
+	}
+	if got := newTodo(tm, time.UTC).DueDate; got != "2026-10-20T22:30:00Z" {
+		t.Errorf("due date in UTC = %s", got)
+	}
+
+	// Quick-add reads "tomorrow" on the caller's calendar.
+	now := time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC).In(berlin)
+	if got := parseQuickAdd("Call mum tomorrow 9am", now).DueDate; got != "2026-10-16T09:00:00+02:00" {
+		t.Errorf("quick-add due date = %s", got)
+	}
+}
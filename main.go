@@ -3,24 +3,32 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/redis/go-redis/v9"
 	"github.com/thedevsaddam/renderer"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var rnd *renderer.Render
 var db *mongo.Database
+var svc *todoService
+
+// store serves the todo CRUD handlers. It is svc outside of tests.
+var store todoStore
 
 const (
 	hostName = "mongodb://127.0.0.1:27017"
@@ -31,190 +39,506 @@ const (
 
 type (
 	todoModel struct {
-		ID        primitive.ObjectID `bson:"_id,omitempty"`
-		Title     string             `bson:"title"`
-		Completed    bool             `bson:"completed"`
-		CreatedAt time.Time          `bson:"created_at"`
-		UpdatedAt time.Time          `bson:"updated_at"`
+		ID          primitive.ObjectID  `bson:"_id,omitempty"`
+		Title       string              `bson:"title"`
+		Description string              `bson:"description"`
+		Completed   bool                `bson:"completed"`
+		CompletedAt *time.Time          `bson:"completed_at,omitempty"`
+		Starred     bool                `bson:"starred,omitempty"`
+		Tags        []string            `bson:"tags,omitempty"`
+		Priority    string              `bson:"priority,omitempty"`
+		DueDate     *time.Time          `bson:"due_date,omitempty"`
+		ListID      *primitive.ObjectID `bson:"list_id,omitempty"`
+		OwnerID     string              `bson:"owner_id,omitempty"`
+		AssigneeID  string              `bson:"assignee_id,omitempty"`
+		WorkspaceID string              `bson:"workspace_id,omitempty"`
+		CreatedAt   time.Time           `bson:"created_at"`
+		UpdatedAt   time.Time           `bson:"updated_at"`
 	}
 
 	todo struct {
-		ID        string  `json:"id"`
-		Title     string  `json:"title"`
-		Completed    bool  `json:"completed"`
-		CreatedAt string  `json:"created_at"`
-		UpdatedAt string  `json:"updated_at"`
+		ID              string `json:"id"`
+		Title           string `json:"title"`
+		Description     string `json:"description"`
+		DescriptionHTML string `json:"description_html,omitempty"`
+		// Progress counts the task list items ("- [x] step") in the
+		// description. It is computed, so clients never send it.
+		Progress    *checklistProgress `json:"progress,omitempty"`
+		Completed   bool               `json:"completed"`
+		CompletedAt string             `json:"completed_at,omitempty"`
+		Starred     bool               `json:"starred"`
+		Tags        []string           `json:"tags,omitempty"`
+		Priority    string             `json:"priority,omitempty"`
+		DueDate     string             `json:"due_date,omitempty"`
+		ListID      string             `json:"list_id,omitempty"`
+		OwnerID     string             `json:"owner_id,omitempty"`
+		AssigneeID  string             `json:"assignee_id,omitempty"`
+		CreatedAt   string             `json:"created_at"`
+		UpdatedAt   string             `json:"updated_at"`
 	}
 )
 
-func init() {
+// setup builds the database client, the stores and the services from the
+// configuration, failing on settings it can't use. It doesn't wait for
+// MongoDB: Connect only dials once the first command is sent.
+func setup() error {
 	rnd = renderer.New()
+	setupSentry()
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Use mongo.Connect instead of mongo.NewClient
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(hostName))
-	checkErr(err, "MongoDB connection failed")
+	var err error
+	if assets, err = assetFS(); err != nil {
+		return fmt.Errorf("frontend assets setup failed: %w", err)
+	}
 
-	// Ping the database to verify connection
-	err = client.Ping(ctx, nil)
-	checkErr(err, "MongoDB ping failed")
+	client, err := mongo.Connect(context.Background(), mongoClientOptions())
+	if err != nil {
+		return fmt.Errorf("MongoDB client setup failed: %w", err)
+	}
 
 	// Select the database
 	db = client.Database(dbName)
 
-	log.Println("MongoDB connected!")
-}
+	blobs, err := newBlobStore(db)
+	if err != nil {
+		return fmt.Errorf("attachment storage setup failed: %w", err)
+	}
+
+	var rdb *redis.Client
+	if redisURL := envString("REDIS_URL", ""); redisURL != "" {
+		if rdb, err = newRedisClient(redisURL); err != nil {
+			return fmt.Errorf("Redis setup failed: %w", err)
+		}
+	}
+	cache, err := newReadCache(rdb)
+	if err != nil {
+		return fmt.Errorf("cache setup failed: %w", err)
+	}
+	svc = newTodoService(db, blobs, cache, newEventBus(rdb))
+	store = svc
+
+	if sessions, err = newSessionStore(db); err != nil {
+		return fmt.Errorf("session store setup failed: %w", err)
+	}
+
+	telegram = newTelegramBot(db)
+
+	maintenance = newMaintenanceMode(db)
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	err := rnd.Template(w, http.StatusOK, []string{"static/index.tpl"}, nil)
-	checkErr(err, "Template err")
+	if features, err = newFeatureFlags(db); err != nil {
+		return fmt.Errorf("feature flag setup failed: %w", err)
+	}
+	return nil
 }
 
 func fetchTodos(w http.ResponseWriter, r *http.Request) {
-	var todos []todoModel
-	collection := db.Collection(collName)
+	q, err := parseTodoQuery(r.URL.Query(), requestCaller(r))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid query",
+			Error:   err.Error(),
+		})
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	format, err := negotiate(r)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid query",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	renderHTML := r.URL.Query().Get("render") == "html" || slices.Contains(q.fields, "description_html")
+	loc := requestCaller(r).location()
+	item := func(t todoModel) interface{} {
+		item := newTodo(t, loc)
+		if renderHTML && t.Description != "" {
+			item.DescriptionHTML = renderMarkdown(t.Description)
+		}
+		if q.fields != nil {
+			return selectFields(item, q.fields)
+		}
+		return item
+	}
+
+	ctx := r.Context()
+
+	if format != defaultFormat {
+		items := []interface{}{}
+		err := store.each(ctx, requestCaller(r), q, func(t todoModel) error {
+			items = append(items, item(t))
+			return nil
+		})
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+				Message: "Failed to fetch todo lists",
+				Error:   err.Error(),
+			})
+			return
+		}
+		respond(w, r, http.StatusOK, listResponse[interface{}]{Data: items, page: q.page, perPage: q.perPage})
+		return
+	}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	// JSON todos are encoded as they come off the cursor instead of being
+	// collected first, so the response starts once the first one is read.
+	enc := json.NewEncoder(w)
+	started := false
+	err = store.each(ctx, requestCaller(r), q, func(t todoModel) error {
+		if !started {
+			w.Header().Add("Vary", "Accept")
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			if _, err := io.WriteString(w, `{"data":[`); err != nil {
+				return err
+			}
+			started = true
+		} else if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+		return enc.Encode(item(t))
+	})
+	if err != nil && !started {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch todo lists",
+			Error:   err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Failed to fetch todo lists",
-			"error":   err,
+		// Too late for an error status; cut the response short so the client
+		// doesn't mistake a partial list for the whole one.
+		log.Printf("Failed to stream todo list: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if !started {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(w, `{"data":[`)
+	}
+	io.WriteString(w, "]}")
+}
+
+func fetchTodo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
 		})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var t todoModel
-		if err := cursor.Decode(&t); err != nil {
-			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-				"message": "Failed to decode todo",
-				"error":   err,
+	var fields []string
+	if v := strings.TrimSpace(r.URL.Query().Get("fields")); v != "" {
+		if fields, err = parseFields(v); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, errorResponse{
+				Message: "Invalid query",
+				Error:   err.Error(),
 			})
 			return
 		}
-		todos = append(todos, t)
 	}
 
-	todoList := []todo{}
-	for _, t := range todos {
-		todoList = append(todoList, todo{
-			ID:        t.ID.Hex(),
-			Title:     t.Title,
-			Completed:    t.Completed,
-			CreatedAt: t.CreatedAt.Format(time.RFC3339),
-			UpdatedAt: t.UpdatedAt.Format(time.RFC3339),
+	ctx := r.Context()
+
+	t, err := store.get(ctx, requestCaller(r), objID)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch todo",
+			Error:   err.Error(),
 		})
+		return
 	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{
-		"data": todoList,
+	item := newTodo(t, requestCaller(r).location())
+	renderHTML := r.URL.Query().Get("render") == "html" || slices.Contains(fields, "description_html")
+	if renderHTML && t.Description != "" {
+		item.DescriptionHTML = renderMarkdown(t.Description)
+	}
+	if fields != nil {
+		respond(w, r, http.StatusOK, itemResponse[map[string]interface{}]{
+			Data: selectFields(item, fields),
+		})
+		return
+	}
+	respond(w, r, http.StatusOK, todoResponse{
+		Data: item,
 	})
 }
 
+// normalizeTodo validates the fields of a todo sent by a client and brings
+// them into their stored form. The title may be empty afterwards.
+func normalizeTodo(t *todo) error {
+	if err := sanitizeTodoText(t); err != nil {
+		return err
+	}
+
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		return err
+	}
+	t.Tags = tags
+
+	t.Priority = strings.ToLower(strings.TrimSpace(t.Priority))
+	if !validPriority(t.Priority) {
+		return errInvalidPriority
+	}
+
+	if t.DueDate = strings.TrimSpace(t.DueDate); t.DueDate != "" {
+		due, err := parseQueryTime(t.DueDate)
+		if err != nil {
+			return errors.New("due_date must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		}
+		t.DueDate = due.Format(time.RFC3339)
+	}
+	return nil
+}
+
+// dueDate returns the due date of a todo that went through normalizeTodo.
+func (t todo) dueDate() *time.Time {
+	if t.DueDate == "" {
+		return nil
+	}
+	due, err := time.Parse(time.RFC3339, t.DueDate)
+	if err != nil {
+		return nil
+	}
+	return &due
+}
+
+// newTodoModel builds a new todo, apart from its list, from one a client
+// sent that went through normalizeTodo.
+func newTodoModel(t todo) todoModel {
+	now := time.Now()
+	tm := todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       t.Title,
+		Description: t.Description,
+		Completed:   t.Completed,
+		Starred:     t.Starred,
+		Tags:        t.Tags,
+		Priority:    t.Priority,
+		DueDate:     t.dueDate(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if tm.Completed {
+		tm.CompletedAt = &tm.CreatedAt
+	}
+	return tm
+}
+
+// newTodo builds the todo sent to clients, with its times in loc.
+func newTodo(t todoModel, loc *time.Location) todo {
+	item := todo{
+		ID:          t.ID.Hex(),
+		Title:       t.Title,
+		Description: t.Description,
+		Completed:   t.Completed,
+		Starred:     t.Starred,
+		Tags:        t.Tags,
+		Priority:    t.Priority,
+		OwnerID:     t.OwnerID,
+		AssigneeID:  t.AssigneeID,
+		Progress:    progressOf(t.Description),
+		CreatedAt:   t.CreatedAt.In(loc).Format(time.RFC3339),
+		UpdatedAt:   t.UpdatedAt.In(loc).Format(time.RFC3339),
+	}
+	if t.CompletedAt != nil {
+		item.CompletedAt = t.CompletedAt.In(loc).Format(time.RFC3339)
+	}
+	if t.DueDate != nil {
+		item.DueDate = t.DueDate.In(loc).Format(time.RFC3339)
+	}
+	if t.ListID != nil {
+		item.ListID = t.ListID.Hex()
+	}
+	return item
+}
+
 func createTodo(w http.ResponseWriter, r *http.Request) {
 	var t todo
 	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to create todo",
-			"error":   err,
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create todo",
+			Error:   err.Error(),
 		})
 		return
 	}
+	insertTodo(w, r, t, nil)
+}
+
+// insertTodo validates and creates a todo sent by a client, answering with
+// the created todo and, for quick-add, what was parsed.
+func insertTodo(w http.ResponseWriter, r *http.Request, t todo, parsed *parsedTodo) {
+	if err := normalizeTodo(&t); err != nil {
+		invalidTodo(w, "Failed to create todo", err)
+		return
+	}
 
 	if t.Title == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Failed to create todo",
-			"error":   "Title is required",
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create todo",
+			Error:   "Title is required",
 		})
 		return
 	}
 
-	tm := todoModel{
-		ID:        primitive.NewObjectID(),
-		Title:     t.Title,
-		Completed:    t.Completed,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	tm := newTodoModel(t)
+	if t.ListID != "" {
+		listID, err := primitive.ObjectIDFromHex(t.ListID)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, errorResponse{
+				Message: "Failed to create todo",
+				Error:   "Invalid list_id",
+			})
+			return
+		}
+		tm.ListID = &listID
 	}
 
-	collection := db.Collection(collName)
+	ctx := r.Context()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	c := requestCaller(r)
+	var duplicateOf *primitive.ObjectID
+	if duplicateTodos != duplicatesAllow && !tm.Completed && r.URL.Query().Get("allow_duplicate") != "true" {
+		id, found, err := store.duplicateOf(ctx, c, tm.Title)
+		if storeUnavailable(w, "Failed to create todo", err) {
+			return
+		}
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+				Message: "Failed to create todo",
+				Error:   err.Error(),
+			})
+			return
+		}
+		if found && duplicateTodos == duplicatesReject {
+			rnd.JSON(w, http.StatusConflict, duplicateResponse{
+				errorResponse: errorResponse{
+					Message: "Failed to create todo",
+					Error:   "An open todo with this title already exists; pass allow_duplicate=true to create it anyway",
+				},
+				DuplicateOf: id.Hex(),
+			})
+			return
+		}
+		if found {
+			duplicateOf = &id
+		}
+	}
 
-	_, err := collection.InsertOne(ctx, tm)
+	err := store.create(ctx, c, tm)
+	if quotaExceeded(w, "Failed to create todo", err) {
+		return
+	}
+	if errors.Is(err, errListNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "List not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to create todo",
+			Error:   "Viewers cannot add todos to this list",
+		})
+		return
+	}
+	if storeUnavailable(w, "Failed to create todo", err) {
+		return
+	}
 	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Failed to create todo",
-			"error":   err.Error(),
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to create todo",
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	rnd.JSON(w, http.StatusCreated, renderer.M{
-		"message": "Todo created successfully",
-		"data":    tm,
-	})
+	tm.OwnerID = c.userID
+	resp := todoResponse{
+		Message: "Todo created successfully",
+		Data:    newTodo(tm, c.location()),
+		Parsed:  parsed,
+	}
+	if duplicateOf != nil {
+		resp.Warning = "An open todo with this title already exists"
+		resp.DuplicateOf = duplicateOf.Hex()
+	}
+	rnd.JSON(w, http.StatusCreated, resp)
 }
 
 func updateTodo(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimSpace(chi.URLParam(r, "id"))
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Invalid id",
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
 		})
 		return
 	}
 
 	var t todo
 	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to update todo",
-			"error":   err,
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update todo",
+			Error:   err.Error(),
 		})
 		return
 	}
 
+	if err := normalizeTodo(&t); err != nil {
+		invalidTodo(w, "Failed to update todo", err)
+		return
+	}
+
 	if t.Title == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Failed to update todo",
-			"error":   "Title is required",
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to update todo",
+			Error:   "Title is required",
 		})
 		return
 	}
 
-	collection := db.Collection(collName)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
-	update := bson.M{
-		"$set": bson.M{
-			"title":      t.Title,
-			"completed":     t.Completed,
-			"updated_at": time.Now(),
-		},
+	err = store.update(ctx, requestCaller(r), objID, t)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to update todo",
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	}
+	if storeUnavailable(w, "Failed to update todo", err) {
+		return
 	}
-
-	_, err = collection.UpdateByID(ctx, objID, update)
 	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Failed to update todo",
-			"error":   err,
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to update todo",
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{
-		"message": "Todo updated successfully",
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "Todo updated successfully",
 	})
 }
 
@@ -222,60 +546,250 @@ func deleteTodo(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimSpace(chi.URLParam(r, "id"))
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Invalid id",
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
 		})
 		return
 	}
 
-	collection := db.Collection(collName)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
-	_, err = collection.DeleteOne(ctx, bson.M{"_id": objID})
+	err = store.delete(ctx, requestCaller(r), objID)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: "Failed to delete todo",
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	}
+	if storeUnavailable(w, "Failed to delete todo", err) {
+		return
+	}
 	if err != nil {
-		rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to delete todo",
-			"error":   err,
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to delete todo",
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{
-		"message": "Todo deleted successfully",
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "Todo deleted successfully",
 	})
 }
 
-func main() {
-	stopCh := make(chan os.Signal, 1)
-	signal.Notify(stopCh, os.Interrupt)
+// requestTimeout bounds how long a request may take, including the
+// database calls made for it. Attachment transfers get transferTimeout.
+var (
+	requestTimeout  = envDuration("REQUEST_TIMEOUT", 10*time.Second)
+	transferTimeout = envDuration("TRANSFER_TIMEOUT", 30*time.Second)
+)
+
+// timeout cancels the request's context after d. Handlers pass that context
+// to every store call, so a request that takes too long, or whose client
+// went away, stops querying the database. The request body may take as long
+// to arrive, so uploads get as long as their route allows rather than the
+// server's ReadTimeout.
+func timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(d))
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				log.Printf("Setting the read deadline failed: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// newServer returns the server for h. Headers must arrive within 5 seconds
+// and bodies within requestTimeout, except on routes that extend it with
+// timeout.
+func newServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              port,
+		Handler:           h,
+		IdleTimeout:       60 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       requestTimeout,
+		// Long enough for the handlers' own timeouts to end a request
+		// first, with an error response rather than a dropped connection.
+		WriteTimeout: transferTimeout + 5*time.Second,
+	}
+}
 
+func newRouter() http.Handler {
 	r := chi.NewRouter()
+	r.Use(traceRequests)
 	r.Use(middleware.Logger)
-	r.Get("/", homeHandler)
+	r.Use(compress)
+	r.Use(logBodies)
+	r.Use(identify)
+	r.Use(apiKeyAuth)
+	r.Use(reportToSentry)
+	r.Use(recoverer)
+	r.Use(localize)
+	r.Use(readOnly)
+	r.Use(guardStore)
+	r.Handle("/static/*", staticHandler())
+	if envBool("DEBUG", false) {
+		r.Route("/debug", debugRoutes)
+	}
+	// The event stream stays open for as long as the client listens.
+	r.Get("/events", streamEvents)
+
+	r.Group(func(r chi.Router) {
+		r.Use(timeout(requestTimeout))
+		r.Get("/", homeHandler)
+		r.Get("/version", fetchVersion)
+		r.Route("/ui/todos", func(r chi.Router) {
+			r.Get("/", viewTodos)
+			r.Post("/", viewCreateTodo)
+			r.Post("/{id}/toggle", viewToggleTodo)
+			r.Delete("/{id}", viewDeleteTodo)
+		})
+		r.Route("/auth", func(r chi.Router) {
+			r.Get("/{provider}/login", oauthLogin)
+			r.Get("/{provider}/callback", oauthCallback)
+			r.Post("/logout", logout)
+		})
+		r.Route("/lists", func(r chi.Router) {
+			r.Use(requireUser)
+			r.Get("/", fetchLists)
+			r.Post("/", createList)
+			r.Get("/{id}", fetchList)
+			r.Put("/{id}/members/{userID}", putListMember)
+			r.Delete("/{id}/members/{userID}", deleteListMember)
+		})
+		if slackSigningSecret != "" {
+			r.Post("/integrations/slack", slackCommand)
+		}
+		r.Route("/me", func(r chi.Router) {
+			r.Use(requireUser)
+			r.Get("/usage", fetchUsage)
+			r.Get("/preferences", fetchPreferences)
+			r.Put("/preferences", putPreferences)
+			r.Delete("/", deleteAccount)
+			if telegram != nil {
+				r.Post("/telegram/link", createTelegramLinkCode)
+			}
+		})
+		r.Get("/features", fetchFeatures)
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireAdmin)
+			r.Get("/flags", fetchFeatureFlags)
+			r.Put("/flags/{name}", putFeatureFlag)
+			r.Delete("/flags/{name}", deleteFeatureFlag)
+			r.Get("/maintenance", fetchMaintenance)
+			r.Put("/maintenance", putMaintenance)
+			if seedEndpoint {
+				r.Post("/seed", seedTodos)
+			}
+		})
+		r.Route("/apikeys", func(r chi.Router) {
+			r.Use(requireUser)
+			r.Get("/", fetchAPIKeys)
+			r.Post("/", createAPIKey)
+			r.Delete("/{id}", revokeAPIKey)
+		})
+	})
+
+	// Backups move the whole database and exports all of a user's data, so
+	// they get as long as uploads.
+	r.Group(func(r chi.Router) {
+		r.Use(timeout(transferTimeout))
+		r.Use(requireAdmin)
+		r.Get("/admin/backup", backupData)
+		r.Post("/admin/restore", restoreData)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(timeout(transferTimeout))
+		r.Use(requireUser)
+		r.Get("/me/export", exportAccount)
+	})
+
 	r.Route("/todo", func(r chi.Router) {
-		r.Get("/", fetchTodos)
-		r.Post("/", createTodo)
-		r.Put("/{id}", updateTodo)
-		r.Delete("/{id}", deleteTodo)
+		r.Group(func(r chi.Router) {
+			r.Use(timeout(requestTimeout))
+			r.Get("/", fetchTodos)
+			r.Post("/", createTodo)
+			r.Post("/quickadd", quickAddTodo)
+			r.Get("/stats", fetchTodoStats)
+			r.Get("/changes", fetchChanges)
+			r.Get("/{id}", fetchTodo)
+			r.Put("/{id}", updateTodo)
+			r.Patch("/{id}", patchTodo)
+			r.Delete("/{id}", deleteTodo)
+			r.Post("/{id}/complete", completeTodo)
+			r.Post("/{id}/uncomplete", uncompleteTodo)
+			r.Post("/{id}/star", starTodo)
+			r.Post("/{id}/unstar", unstarTodo)
+			r.Post("/{id}/clone", cloneTodo)
+			r.Get("/{id}/history", fetchTodoHistory)
+			r.Post("/{id}/undo", undoTodo)
+			r.Get("/{id}/attachments", fetchAttachments)
+			r.Delete("/{id}/attachments/{attachmentID}", deleteAttachment)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(timeout(transferTimeout))
+			r.Post("/{id}/attachments", uploadAttachment)
+			r.With(requireUser).Post("/import/{source}", importTodos)
+			r.Get("/{id}/attachments/{attachmentID}", downloadAttachment)
+		})
 	})
+	return r
+}
 
-	srv := &http.Server{
-		Addr: port,
-		Handler: r,
-		IdleTimeout: 60 * time.Second,
-		ReadTimeout: 5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+func main() {
+	checkErr(setup(), "Startup failed")
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate()
+		return
 	}
+	var check bool
+	flag.StringVar(&seedFile, "seed", seedFile, "load todos from this JSON or YAML `fixture` once the database is up, if it has none")
+	flag.BoolVar(&waitForDB, "wait-for-db", waitForDB, "wait for MongoDB and set up the database before listening")
+	flag.BoolVar(&check, "check", false, "check the configuration and that MongoDB and Redis answer, then exit")
+	flag.Parse()
+
+	if check {
+		checkErr(checkDependencies(context.Background()), "Check failed")
+		log.Println("Configuration is valid and the database answers")
+		return
+	}
+
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, os.Interrupt)
+
+	srv := newServer(newRouter())
 
-	go func(){
+	log.Printf("todo-go %s (commit %s, built %s)", version, commit, buildTime)
+
+	if waitForDB {
+		checkErr(startDatabase(db.Client()), "Database setup failed")
+	} else {
+		go func() {
+			checkErr(startDatabase(db.Client()), "Database setup failed")
+		}()
+	}
+	go svc.events.run(context.Background())
+
+	go func() {
 		log.Println("Listening on port ", port)
 		err := srv.ListenAndServe()
 		checkErr(err, "Listen and serve err")
 	}()
 
-	<- stopCh
+	<-stopCh
 	log.Println("Shutting down server......")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	srv.Shutdown(ctx)
@@ -284,18 +798,19 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		checkErr(err, "Server forced to shutdown")
 	}
+	shutdownTracing(ctx)
+	flushSentry()
 
 	log.Println("Server stopped gracefully!")
 
 }
 
-
-func checkErr(err error, message ...string){
+func checkErr(err error, message ...string) {
 	if err != nil {
 		if len(message) > 0 {
 			log.Fatalf("%s: %v", message[0], err)
 		} else {
-			log.Fatal(err)	
+			log.Fatal(err)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,101 @@
+//go:build !integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	if err := setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPingMongoGivesUp(t *testing.T) {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	start := time.Now()
+	err = pingMongo(client, 300*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "didn't answer within 300ms") {
+		t.Fatalf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
+
+func TestCheckFailsOnInvalidSettings(t *testing.T) {
+	old := configErrors
+	t.Cleanup(func() { configErrors = old })
+	configErrors = nil
+
+	t.Setenv("CHECK_TEST_TIMEOUT", "soon")
+	t.Setenv("SENTRY_DSN", "not a dsn")
+	if d := envDuration("CHECK_TEST_TIMEOUT", time.Second); d != time.Second {
+		t.Errorf("invalid duration gave %s, want the default", d)
+	}
+	setupSentry()
+
+	err := checkDependencies(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `CHECK_TEST_TIMEOUT="soon"`) || !strings.Contains(err.Error(), "SENTRY_DSN") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestMongoPoolSizesValidated(t *testing.T) {
+	old := configErrors
+	t.Cleanup(func() { configErrors = old })
+
+	tests := []struct {
+		maxSize, minSize string
+		wantMax, wantMin uint64
+		invalid          bool
+	}{
+		{"", "", 100, 0, false},
+		{"20", "5", 20, 5, false},
+		{"0", "5", 0, 5, false},
+		{"-1", "", 100, 0, true},
+		{"", "-5", 100, 0, true},
+		{"10", "20", 10, 0, true},
+	}
+	for _, tt := range tests {
+		configErrors = nil
+		t.Setenv("MONGO_MAX_POOL_SIZE", tt.maxSize)
+		t.Setenv("MONGO_MIN_POOL_SIZE", tt.minSize)
+		maxPool, minPool := mongoPoolSizes()
+		if maxPool != tt.wantMax || minPool != tt.wantMin || (len(configErrors) > 0) != tt.invalid {
+			t.Errorf("max %q, min %q: got %d, %d (errors %v)", tt.maxSize, tt.minSize, maxPool, minPool, configErrors)
+		}
+	}
+}
+
+func TestAssetsDirMustHoldFrontend(t *testing.T) {
+	old := assetsDir
+	t.Cleanup(func() { assetsDir = old })
+
+	assetsDir = t.TempDir()
+	if _, err := assetFS(); err == nil {
+		t.Error("an empty ASSETS_DIR was accepted")
+	}
+	assetsDir = "static"
+	if _, err := assetFS(); err != nil {
+		t.Error(err)
+	}
+}
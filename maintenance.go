@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	settingsCollName  = "settings"
+	maintenanceDocID  = "maintenance"
+	maintenanceNotice = "Writes are paused for maintenance, please try again later"
+)
+
+var (
+	// maintenanceRetryAfter is what the Retry-After header of refused
+	// writes says.
+	maintenanceRetryAfter = envDuration("MAINTENANCE_RETRY_AFTER", time.Minute)
+	// maintenanceRefresh is how often a change made through another
+	// instance is picked up.
+	maintenanceRefresh = envDuration("MAINTENANCE_REFRESH", 10*time.Second)
+)
+
+var maintenance *maintenanceMode
+
+type (
+	maintenanceModel struct {
+		ID        string    `bson:"_id"`
+		Enabled   bool      `bson:"enabled"`
+		Message   string    `bson:"message,omitempty"`
+		UpdatedAt time.Time `bson:"updated_at"`
+	}
+
+	maintenanceStatus struct {
+		Enabled    bool   `json:"enabled"`
+		Message    string `json:"message,omitempty"`
+		Source     string `json:"source"` // "config" or "database"
+		RetryAfter int    `json:"retry_after"`
+		UpdatedAt  string `json:"updated_at,omitempty"`
+	}
+)
+
+// maintenanceMode is the read-only switch. MAINTENANCE_MODE=true keeps it
+// on whatever is stored, so it can be used while the database itself is
+// unavailable; otherwise admins turn it on and off through the settings
+// collection.
+type maintenanceMode struct {
+	coll   *mongo.Collection
+	config bool
+
+	mu     sync.RWMutex
+	stored maintenanceModel
+}
+
+func newMaintenanceMode(db *mongo.Database) *maintenanceMode {
+	return &maintenanceMode{
+		coll:   db.Collection(settingsCollName),
+		config: envBool("MAINTENANCE_MODE", false),
+	}
+}
+
+// status reports whether writes are paused and why.
+func (m *maintenanceMode) status() maintenanceStatus {
+	st := maintenanceStatus{
+		Enabled:    m.config,
+		Source:     "config",
+		RetryAfter: int(maintenanceRetryAfter.Seconds()),
+	}
+	if m.config {
+		return st
+	}
+	m.mu.RLock()
+	stored := m.stored
+	m.mu.RUnlock()
+	if !stored.UpdatedAt.IsZero() {
+		st.Enabled = stored.Enabled
+		st.Message = stored.Message
+		st.Source = "database"
+		st.UpdatedAt = stored.UpdatedAt.Format(time.RFC3339)
+	}
+	return st
+}
+
+func (m *maintenanceMode) enabled() bool {
+	return m.status().Enabled
+}
+
+// refresh reloads the stored setting. Failing to, as during a failover,
+// keeps the last one.
+func (m *maintenanceMode) refresh(ctx context.Context) error {
+	var stored maintenanceModel
+	err := m.coll.FindOne(ctx, bson.M{"_id": maintenanceDocID}).Decode(&stored)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	m.mu.Lock()
+	m.stored = stored
+	m.mu.Unlock()
+	return nil
+}
+
+// run refreshes the stored setting now and then every maintenanceRefresh
+// until ctx is done.
+func (m *maintenanceMode) run(ctx context.Context) {
+	interval := maintenanceRefresh
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if err := m.refresh(refreshCtx); err != nil {
+			log.Printf("Maintenance mode refresh failed: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// set stores the setting. It takes effect on this instance at once and on
+// the others at their next refresh.
+func (m *maintenanceMode) set(ctx context.Context, enabled bool, message string) error {
+	stored := maintenanceModel{ID: maintenanceDocID, Enabled: enabled, Message: message, UpdatedAt: time.Now()}
+	_, err := m.coll.ReplaceOne(ctx, bson.M{"_id": maintenanceDocID}, stored, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.stored = stored
+	m.mu.Unlock()
+	return nil
+}
+
+// readOnly answers writes with 503 and a Retry-After header while
+// maintenance mode is on. Reads go on as usual, and so do the admin
+// endpoints, so maintenance mode can be turned off again.
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if safeMethod(r.Method) || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		st := maintenance.status()
+		if !st.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		message := st.Message
+		if message == "" {
+			message = maintenanceNotice
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfter))
+		rnd.JSON(w, http.StatusServiceUnavailable, errorResponse{
+			Message: "Maintenance in progress",
+			Error:   message,
+		})
+	})
+}
+
+func fetchMaintenance(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, itemResponse[maintenanceStatus]{
+		Data: maintenance.status(),
+	})
+}
+
+func putMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceStatus
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to change maintenance mode",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := maintenance.set(ctx, req.Enabled, strings.TrimSpace(req.Message)); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to change maintenance mode",
+			Error:   err.Error(),
+		})
+		return
+	}
+	st := maintenance.status()
+	message := "Maintenance mode turned off"
+	switch {
+	case st.Enabled && st.Source == "config":
+		message = "Maintenance mode stays on while MAINTENANCE_MODE is set"
+	case st.Enabled:
+		message = "Maintenance mode turned on"
+	}
+	rnd.JSON(w, http.StatusOK, itemResponse[maintenanceStatus]{
+		Message: message,
+		Data:    st,
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceModeRefusesWrites(t *testing.T) {
+	old := maintenance
+	maintenance = &maintenanceMode{config: true}
+	t.Cleanup(func() { maintenance = old })
+
+	rec := serve(t, http.MethodPost, "/todo/", "ann", `{"title":"Paint the fence"}`)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("create: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve(t, http.MethodGet, "/version", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("read: status %d", rec.Code)
+	}
+	// The admin endpoints stay reachable to turn it off.
+	if rec := serve(t, http.MethodPut, "/admin/maintenance", "", `{"enabled":false}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("admin: status %d", rec.Code)
+	}
+}
+
+func TestMaintenanceStatus(t *testing.T) {
+	m := &maintenanceMode{}
+	if st := m.status(); st.Enabled || st.Source != "config" {
+		t.Errorf("default status = %+v", st)
+	}
+
+	m.stored = maintenanceModel{Enabled: true, Message: "Upgrading", UpdatedAt: time.Now()}
+	if st := m.status(); !st.Enabled || st.Source != "database" || st.Message != "Upgrading" {
+		t.Errorf("stored status = %+v", st)
+	}
+
+	m.config = true
+	m.stored.Enabled = false
+	if st := m.status(); !st.Enabled || st.Source != "config" {
+		t.Errorf("config overrides stored: %+v", st)
+	}
+}
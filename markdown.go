@@ -0,0 +1,136 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// renderMarkdown converts the small Markdown subset that todo descriptions
+// support into HTML: headings, paragraphs, lists, block quotes, fenced code
+// blocks, inline code, emphasis and links. All input is escaped before any
+// markup is added, so raw HTML in a description is never passed through and
+// links are limited to http, https and mailto URLs.
+func renderMarkdown(src string) string {
+	var b strings.Builder
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var paragraph []string
+	listTag := ""
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + renderInline(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	closeList := func() {
+		if listTag != "" {
+			b.WriteString("</" + listTag + ">\n")
+			listTag = ""
+		}
+	}
+	openList := func(tag string) {
+		if listTag != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			listTag = tag
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flushParagraph()
+			closeList()
+
+		case strings.HasPrefix(trimmed, "```"):
+			flushParagraph()
+			closeList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case headingRe.MatchString(trimmed):
+			flushParagraph()
+			closeList()
+			m := headingRe.FindStringSubmatch(trimmed)
+			tag := "h" + strconv.Itoa(len(m[1]))
+			b.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flushParagraph()
+			closeList()
+			b.WriteString("<blockquote>" + renderInline(strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))) + "</blockquote>\n")
+
+		case unorderedItemRe.MatchString(trimmed):
+			flushParagraph()
+			openList("ul")
+			b.WriteString("<li>" + renderInline(unorderedItemRe.FindStringSubmatch(trimmed)[1]) + "</li>\n")
+
+		case orderedItemRe.MatchString(trimmed):
+			flushParagraph()
+			openList("ol")
+			b.WriteString("<li>" + renderInline(orderedItemRe.FindStringSubmatch(trimmed)[1]) + "</li>\n")
+
+		default:
+			closeList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flushParagraph()
+	closeList()
+
+	return b.String()
+}
+
+var (
+	headingRe       = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	unorderedItemRe = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	orderedItemRe   = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+
+	linkRe   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongRe = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emRe     = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// renderInline escapes text and applies the inline Markdown rules to it.
+// Code spans are split out first so their contents are left untouched.
+func renderInline(text string) string {
+	var b strings.Builder
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		if i%2 == 1 && i == len(parts)-1 {
+			// An unterminated code span is just a literal backtick.
+			part = "`" + part
+		} else if i%2 == 1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		s := html.EscapeString(part)
+		s = linkRe.ReplaceAllStringFunc(s, func(m string) string {
+			sub := linkRe.FindStringSubmatch(m)
+			if !safeLinkURL(html.UnescapeString(sub[2])) {
+				return sub[1]
+			}
+			return `<a href="` + sub[2] + `" rel="nofollow noopener">` + sub[1] + "</a>"
+		})
+		s = strongRe.ReplaceAllString(s, "<strong>$1$2</strong>")
+		s = emRe.ReplaceAllString(s, "<em>$1$2</em>")
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+func safeLinkURL(u string) bool {
+	lower := strings.ToLower(u)
+	return strings.HasPrefix(lower, "http://") ||
+		strings.HasPrefix(lower, "https://") ||
+		strings.HasPrefix(lower, "mailto:")
+}
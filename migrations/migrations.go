@@ -0,0 +1,76 @@
+// Package migrations applies ordered, one-off changes to the data in the
+// todo database, such as back-filling a field added to existing documents.
+//
+// Each migration runs once per database. Applied migrations are recorded in
+// the migrations collection, so a migration that has been released must
+// never be renamed or changed; add a new one instead.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const collName = "migrations"
+
+// Migration is a single change to the database. Up should be safe to run
+// again if it fails half way.
+type Migration struct {
+	// ID orders the migrations and identifies them in the migrations
+	// collection, e.g. "0001_backfill_description".
+	ID string
+	Up func(ctx context.Context, db *mongo.Database) error
+}
+
+type record struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"applied_at"`
+	Done      bool      `bson:"done"`
+}
+
+// Run applies the migrations in ms that have not been applied to db yet, in
+// order, and returns the IDs of the ones it applied. A migration is claimed
+// before it runs, so instances starting at the same time don't run it twice.
+func Run(ctx context.Context, db *mongo.Database, ms []Migration) ([]string, error) {
+	coll := db.Collection(collName)
+
+	var applied []string
+	for i, m := range ms {
+		if i > 0 && m.ID <= ms[i-1].ID {
+			return applied, fmt.Errorf("migration %q is out of order", m.ID)
+		}
+
+		_, err := coll.InsertOne(ctx, record{ID: m.ID, AppliedAt: time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			var r record
+			if err := coll.FindOne(ctx, bson.M{"_id": m.ID}).Decode(&r); err != nil {
+				return applied, err
+			}
+			if !r.Done {
+				return applied, fmt.Errorf("migration %s is being applied by another instance or failed half way", m.ID)
+			}
+			continue
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		log.Printf("Applying migration %s", m.ID)
+		if err := m.Up(ctx, db); err != nil {
+			// Release the claim so the migration is retried next time.
+			_, delErr := coll.DeleteOne(context.Background(), bson.M{"_id": m.ID})
+			return applied, fmt.Errorf("migration %s: %w", m.ID, errors.Join(err, delErr))
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": m.ID}, bson.M{"$set": bson.M{"done": true, "applied_at": time.Now()}}); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.ID)
+	}
+	return applied, nil
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// All lists every migration, oldest first. Collection and field names are
+// spelled out rather than shared with the app, since a migration describes
+// the schema as it was when it was written.
+var All = []Migration{
+	{ID: "0001_backfill_description", Up: backfillDescription},
+	{ID: "0002_backfill_attachment_owner", Up: backfillAttachmentOwner},
+	{ID: "0003_backfill_attachment_workspace", Up: backfillAttachmentWorkspace},
+}
+
+// backfillDescription gives todos created before descriptions existed an
+// empty one, so filters on the field see every todo.
+func backfillDescription(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("todo").UpdateMany(ctx,
+		bson.M{"description": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"description": ""}},
+	)
+	return err
+}
+
+// backfillAttachmentOwner copies each todo's owner onto its attachments, so
+// storage quotas count attachments uploaded before they existed.
+func backfillAttachmentOwner(ctx context.Context, db *mongo.Database) error {
+	todoIDs, err := db.Collection("attachments").Distinct(ctx, "todo_id",
+		bson.M{"owner_id": bson.M{"$exists": false}},
+	)
+	if err != nil {
+		return err
+	}
+	for _, todoID := range todoIDs {
+		var todo struct {
+			OwnerID string `bson:"owner_id"`
+		}
+		err := db.Collection("todo").FindOne(ctx, bson.M{"_id": todoID}).Decode(&todo)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		_, err = db.Collection("attachments").UpdateMany(ctx,
+			bson.M{"todo_id": todoID, "owner_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"owner_id": todo.OwnerID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillAttachmentWorkspace copies each todo's workspace onto its
+// attachments, so storage quotas only count a workspace's own attachments.
+// Attachments of todos in the default workspace have none to copy.
+func backfillAttachmentWorkspace(ctx context.Context, db *mongo.Database) error {
+	todoIDs, err := db.Collection("attachments").Distinct(ctx, "todo_id",
+		bson.M{"workspace_id": bson.M{"$exists": false}},
+	)
+	if err != nil {
+		return err
+	}
+	for _, todoID := range todoIDs {
+		var todo struct {
+			WorkspaceID string `bson:"workspace_id"`
+		}
+		err := db.Collection("todo").FindOne(ctx, bson.M{"_id": todoID}).Decode(&todo)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		if todo.WorkspaceID == "" {
+			continue
+		}
+		_, err = db.Collection("attachments").UpdateMany(ctx,
+			bson.M{"todo_id": todoID, "workspace_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"workspace_id": todo.WorkspaceID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gitnoober/todo-go/migrations"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+// mongoClientOptions builds the client options from the environment. The
+// pool and timeout defaults are the driver's own.
+func mongoClientOptions() *options.ClientOptions {
+	maxPool, minPool := mongoPoolSizes()
+	opts := options.Client().
+		ApplyURI(envString("MONGO_URI", hostName)).
+		SetMaxPoolSize(maxPool).
+		SetMinPoolSize(minPool).
+		SetServerSelectionTimeout(envDuration("MONGO_SERVER_SELECTION_TIMEOUT", 30*time.Second)).
+		SetRetryWrites(envBool("MONGO_RETRY_WRITES", true)).
+		SetPoolMonitor(breaker.poolMonitor(poolMonitor()))
+	var commands *event.CommandMonitor
+	if tracerProvider != nil {
+		commands = otelmongo.NewMonitor()
+	}
+	if commands = breaker.commandMonitor(commands); commands != nil {
+		opts.SetMonitor(commands)
+	}
+	if servers := breaker.serverMonitor(); servers != nil {
+		opts.SetServerMonitor(servers)
+	}
+	return opts
+}
+
+// mongoPoolSizes reads MONGO_MAX_POOL_SIZE and MONGO_MIN_POOL_SIZE.
+// Negative sizes, and a minimum above the maximum, fall back to the
+// defaults. A maximum of 0 means no limit.
+func mongoPoolSizes() (maxPool, minPool uint64) {
+	maxSize := envInt64("MONGO_MAX_POOL_SIZE", 100)
+	if maxSize < 0 {
+		invalidSetting(fmt.Sprintf("MONGO_MAX_POOL_SIZE=%d", maxSize), errors.New("must not be negative"))
+		maxSize = 100
+	}
+	minSize := envInt64("MONGO_MIN_POOL_SIZE", 0)
+	if minSize < 0 {
+		invalidSetting(fmt.Sprintf("MONGO_MIN_POOL_SIZE=%d", minSize), errors.New("must not be negative"))
+		minSize = 0
+	}
+	if maxSize > 0 && minSize > maxSize {
+		invalidSetting(fmt.Sprintf("MONGO_MIN_POOL_SIZE=%d", minSize), fmt.Errorf("must not exceed MONGO_MAX_POOL_SIZE=%d", maxSize))
+		minSize = 0
+	}
+	return uint64(maxSize), uint64(minSize)
+}
+
+var (
+	// mongoWait is how long startup keeps trying to reach MongoDB before
+	// giving up, e.g. while docker-compose is still starting it.
+	mongoWait = envDuration("MONGO_WAIT", 2*time.Minute)
+
+	// waitForDB holds off listening until the database is set up. Without
+	// it the server listens at once and sets the database up in the
+	// background.
+	waitForDB = envBool("WAIT_FOR_DB", false)
+)
+
+// startDatabase waits for MongoDB to answer, then migrates the database
+// unless MIGRATE_ON_START is off, creates the indexes and starts the
+// background jobs. Unless waitForDB is set the server is listening by
+// then, so it can come up before the database does; requests made in the
+// meantime fail like any other request to an unreachable database.
+func startDatabase(client *mongo.Client) error {
+	if err := pingMongo(client, mongoWait); err != nil {
+		return err
+	}
+
+	if envBool("MIGRATE_ON_START", true) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		_, err := migrations.Run(ctx, db, migrations.All)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := svc.ensureIndexes(ctx); err != nil {
+		return fmt.Errorf("MongoDB index creation failed: %w", err)
+	}
+
+	if s, ok := sessions.(interface{ ensureIndexes(context.Context) error }); ok {
+		if err := s.ensureIndexes(ctx); err != nil {
+			return fmt.Errorf("session index creation failed: %w", err)
+		}
+	}
+
+	if telegram != nil {
+		if err := telegram.ensureIndexes(ctx); err != nil {
+			return fmt.Errorf("Telegram index creation failed: %w", err)
+		}
+	}
+
+	log.Println("MongoDB connected!")
+
+	if seedFile != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), seedTimeout)
+		err := seedFromFile(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("seeding failed: %w", err)
+		}
+	}
+
+	go svc.runDeletedTodoPurge(context.Background())
+	go svc.runHistoryPrune(context.Background())
+	go svc.resumeDeletions(context.Background())
+	go svc.watchChanges(context.Background())
+	go features.run(context.Background())
+	go maintenance.run(context.Background())
+	if telegram != nil {
+		go telegram.run(context.Background())
+	}
+	return nil
+}
+
+// pingMongo pings MongoDB until it answers, for up to wait, backing off
+// exponentially between attempts.
+func pingMongo(client *mongo.Client, wait time.Duration) error {
+	backoff := envDuration("MONGO_CONNECT_BACKOFF", 500*time.Millisecond)
+	const maxBackoff = 30 * time.Second
+
+	deadline := time.Now().Add(wait)
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("MongoDB didn't answer within %s (%d attempts): %w", wait, attempt, err)
+		}
+		delay := min(backoff, left).Round(time.Millisecond)
+		log.Printf("MongoDB not reachable (attempt %d), retrying in %s: %v", attempt, delay, err)
+		time.Sleep(delay)
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// checkDependencies is the -check mode: it fails on any setting setup had to
+// ignore, then checks that MongoDB, and Redis when it is configured,
+// answer.
+func checkDependencies(ctx context.Context) error {
+	if err := errors.Join(configErrors...); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := pingMongo(db.Client(), mongoWait); err != nil {
+		return err
+	}
+	if svc.events.redis != nil {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := svc.events.redis.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("Redis didn't answer: %w", err)
+		}
+	}
+	return nil
+}
+
+// migrate is the "migrate" subcommand: it applies pending migrations and
+// exits.
+func migrate() {
+	checkErr(pingMongo(db.Client(), mongoWait), "MongoDB unreachable")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	applied, err := migrations.Run(ctx, db, migrations.All)
+	checkErr(err, "Migration failed")
+	log.Printf("Applied %d migrations", len(applied))
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const oauthStateCookie = "todo_oauth_state"
+
+// oauthProfile is what we learn about a user from their OAuth provider.
+type oauthProfile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type oauthProvider struct {
+	authURL      string
+	tokenURL     string
+	scope        string
+	clientID     string
+	clientSecret string
+	profile      func(ctx context.Context, token string) (oauthProfile, error)
+}
+
+// oauthProviders are the enabled login providers. A provider is enabled by
+// setting its <NAME>_CLIENT_ID and <NAME>_CLIENT_SECRET variables.
+var oauthProviders = map[string]*oauthProvider{}
+
+// oauthRedirectBase is the public URL of the app, used to build the
+// callback URLs registered with the providers.
+var oauthRedirectBase = strings.TrimRight(envString("OAUTH_REDIRECT_BASE_URL", "http://localhost"+port), "/")
+
+var oauthClient = &http.Client{Timeout: 10 * time.Second, Transport: tracedTransport()}
+
+func init() {
+	if id, secret := envString("GOOGLE_CLIENT_ID", ""), envString("GOOGLE_CLIENT_SECRET", ""); id != "" && secret != "" {
+		oauthProviders["google"] = &oauthProvider{
+			authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL:     "https://oauth2.googleapis.com/token",
+			scope:        "openid email profile",
+			clientID:     id,
+			clientSecret: secret,
+			profile:      googleProfile,
+		}
+	}
+	if id, secret := envString("GITHUB_CLIENT_ID", ""), envString("GITHUB_CLIENT_SECRET", ""); id != "" && secret != "" {
+		oauthProviders["github"] = &oauthProvider{
+			authURL:      "https://github.com/login/oauth/authorize",
+			tokenURL:     "https://github.com/login/oauth/access_token",
+			scope:        "read:user user:email",
+			clientID:     id,
+			clientSecret: secret,
+			profile:      githubProfile,
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func oauthCallbackURL(name string) string {
+	return oauthRedirectBase + "/auth/" + name + "/callback"
+}
+
+func oauthLogin(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "provider")
+	p, ok := oauthProviders[name]
+	if !ok {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Unknown login provider",
+		})
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to start login",
+			Error:   err.Error(),
+		})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/auth/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"client_id":     {p.clientID},
+		"redirect_uri":  {oauthCallbackURL(name)},
+		"response_type": {"code"},
+		"scope":         {p.scope},
+		"state":         {state},
+	}
+	http.Redirect(w, r, p.authURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func oauthCallback(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "provider")
+	p, ok := oauthProviders[name]
+	if !ok {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Unknown login provider",
+		})
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Login failed",
+			Error:   "Invalid login state, please try again",
+		})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/auth/", MaxAge: -1})
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		rnd.JSON(w, http.StatusUnauthorized, errorResponse{
+			Message: "Login failed",
+			Error:   e,
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	token, err := p.exchange(ctx, r.URL.Query().Get("code"), oauthCallbackURL(name))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadGateway, errorResponse{
+			Message: "Login failed",
+			Error:   err.Error(),
+		})
+		return
+	}
+	profile, err := p.profile(ctx, token)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadGateway, errorResponse{
+			Message: "Login failed",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	u, err := svc.loginUser(ctx, name, profile)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Login failed",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := signIn(ctx, w, u); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Login failed",
+			Error:   err.Error(),
+		})
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := signOut(ctx, w, r); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to log out",
+			Error:   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, messageResponse{
+		Message: "Logged out",
+	})
+}
+
+// exchange trades an authorization code for an access token.
+func (p *oauthProvider) exchange(ctx context.Context, code, redirectURI string) (string, error) {
+	form := url.Values{
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"grant_type":    {"authorization_code"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := oauthGetJSON(req, &body); err != nil {
+		return "", err
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("token exchange failed: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("token exchange returned no access token")
+	}
+	return body.AccessToken, nil
+}
+
+func oauthGetJSON(req *http.Request, v interface{}) error {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func bearerRequest(ctx context.Context, rawURL, token string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func googleProfile(ctx context.Context, token string) (oauthProfile, error) {
+	req, err := bearerRequest(ctx, "https://openidconnect.googleapis.com/v1/userinfo", token)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := oauthGetJSON(req, &info); err != nil {
+		return oauthProfile{}, err
+	}
+	return oauthProfile{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified, Name: info.Name}, nil
+}
+
+func githubProfile(ctx context.Context, token string) (oauthProfile, error) {
+	req, err := bearerRequest(ctx, "https://api.github.com/user", token)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	var info struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := oauthGetJSON(req, &info); err != nil {
+		return oauthProfile{}, err
+	}
+	profile := oauthProfile{Subject: strconv.FormatInt(info.ID, 10), Name: info.Name}
+	if profile.Name == "" {
+		profile.Name = info.Login
+	}
+
+	// The public profile email may be empty or unverified, so ask for the
+	// primary address explicitly.
+	req, err = bearerRequest(ctx, "https://api.github.com/user/emails", token)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := oauthGetJSON(req, &emails); err != nil {
+		return oauthProfile{}, err
+	}
+	for _, e := range emails {
+		if e.Primary {
+			profile.Email, profile.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return profile, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Deleting a todo removes its document at once. Its history, whose delete
+// entry holds a copy of the todo for undo, and its attachments are kept for
+// DELETED_TODO_RETENTION. After that a job that runs every PURGE_INTERVAL
+// removes them, and with them the last chance of restoring the todo. It is
+// a job rather than a TTL index because the attachments' blobs have to go
+// too. A retention of 0 keeps them forever.
+var (
+	deletedTodoRetention = envDuration("DELETED_TODO_RETENTION", 30*24*time.Hour)
+	purgeInterval        = envDuration("PURGE_INTERVAL", time.Hour)
+)
+
+func (s *todoService) ensurePurgeIndexes(ctx context.Context) error {
+	_, err := s.history.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
+
+// runDeletedTodoPurge purges what is left of deleted todos now and then
+// every purgeInterval until ctx is done. Every instance runs it; purging is
+// idempotent.
+func (s *todoService) runDeletedTodoPurge(ctx context.Context) {
+	if deletedTodoRetention <= 0 {
+		log.Println("Deleted todo purge disabled, their history and attachments are kept")
+		return
+	}
+	interval := purgeInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	log.Printf("Purging the history and attachments of todos deleted more than %s ago every %s", deletedTodoRetention, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().Add(-deletedTodoRetention)
+		purgeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		n, err := s.purgeDeletedTodos(purgeCtx, cutoff)
+		cancel()
+		if err != nil {
+			log.Printf("Deleted todo purge failed after %d todos: %v", n, err)
+		} else if n > 0 {
+			log.Printf("Purged %d todos deleted before %s", n, cutoff.Format(time.RFC3339))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedTodos purges the todos deleted before cutoff and not restored
+// since, and reports how many it purged.
+func (s *todoService) purgeDeletedTodos(ctx context.Context, cutoff time.Time) (int, error) {
+	ids, err := s.history.Distinct(ctx, "todo_id", bson.M{
+		"action":     actionDelete,
+		"undone":     false,
+		"created_at": bson.M{"$lt": cutoff},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, v := range ids {
+		id, ok := v.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		ok, err := s.purgeTodo(ctx, id)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purgeTodo removes what is left of a deleted todo: its attachments and
+// their blobs, then its history. It does nothing if the todo was restored.
+// The blobs go first so that a purge cut short leaves the history behind
+// and is picked up again on the next run.
+func (s *todoService) purgeTodo(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	n, err := s.todos.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil || n > 0 {
+		return false, err
+	}
+
+	cursor, err := s.attachments.Find(ctx, bson.M{"todo_id": id})
+	if err != nil {
+		return false, err
+	}
+	var attachments []attachmentModel
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return false, err
+	}
+	for _, a := range attachments {
+		if err := s.blobs.delete(ctx, a.ID.Hex()); err != nil && !errors.Is(err, errBlobNotFound) {
+			return false, err
+		}
+	}
+	if _, err := s.attachments.DeleteMany(ctx, bson.M{"todo_id": id}); err != nil {
+		return false, err
+	}
+	if _, err := s.history.DeleteMany(ctx, bson.M{"todo_id": id}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// todoQuery narrows down which of the visible todos a listing returns.
+// Nil fields don't filter.
+type todoQuery struct {
+	listID          *primitive.ObjectID
+	completed       *bool
+	starred         *bool
+	assignee        *string // a user ID, or empty for unassigned todos
+	createdAfter    *time.Time
+	createdBefore   *time.Time
+	updatedAfter    *time.Time
+	updatedBefore   *time.Time
+	completedAfter  *time.Time
+	completedBefore *time.Time
+	completedOn     *time.Time // the start of the day, in the caller's time zone
+
+	sortField string // one of sortableFields, or empty for starred first, then creation order
+	sortDesc  bool
+
+	fields []string // names from selectableFields, or nil for all fields
+
+	page    int // from 1
+	perPage int // 0 lists every todo at once
+}
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// selectableFields maps the fields a response can be limited to onto the
+// stored fields they are built from.
+var selectableFields = map[string][]string{
+	"id":               {"_id"},
+	"title":            {"title"},
+	"description":      {"description"},
+	"description_html": {"description"},
+	"progress":         {"description"},
+	"completed":        {"completed"},
+	"completed_at":     {"completed_at"},
+	"starred":          {"starred"},
+	"tags":             {"tags"},
+	"priority":         {"priority"},
+	"due_date":         {"due_date"},
+	"list_id":          {"list_id"},
+	"owner_id":         {"owner_id"},
+	"assignee_id":      {"assignee_id"},
+	"created_at":       {"created_at"},
+	"updated_at":       {"updated_at"},
+}
+
+// sortableFields are the fields a listing can be sorted by.
+var sortableFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"title":      true,
+	"due_date":   true,
+}
+
+// parseTodoQuery reads c's todoQuery from the request's query string. Dates
+// are RFC 3339 timestamps or plain YYYY-MM-DD dates, which mean midnight
+// UTC. The day of completed_on, a date or "today", is the day in c's time
+// zone.
+func parseTodoQuery(q url.Values, c caller) (todoQuery, error) {
+	var tq todoQuery
+	loc := c.location()
+
+	if v := strings.TrimSpace(q.Get("list_id")); v != "" {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return tq, errors.New("Invalid list_id")
+		}
+		tq.listID = &id
+	}
+
+	if v := strings.TrimSpace(q.Get("completed")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return tq, errors.New("completed must be true or false")
+		}
+		tq.completed = &b
+	}
+
+	if v := strings.TrimSpace(q.Get("starred")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return tq, errors.New("starred must be true or false")
+		}
+		tq.starred = &b
+	}
+
+	switch v := strings.TrimSpace(q.Get("assignee")); v {
+	case "":
+	case "me":
+		if c.userID == "" {
+			return tq, errors.New("assignee=me needs a signed-in user")
+		}
+		tq.assignee = &c.userID
+	case "none":
+		tq.assignee = new(string)
+	default:
+		tq.assignee = &v
+	}
+
+	dates := []struct {
+		param string
+		dst   **time.Time
+	}{
+		{"created_after", &tq.createdAfter},
+		{"created_before", &tq.createdBefore},
+		{"updated_after", &tq.updatedAfter},
+		{"updated_before", &tq.updatedBefore},
+		{"completed_after", &tq.completedAfter},
+		{"completed_before", &tq.completedBefore},
+	}
+	for _, d := range dates {
+		v := strings.TrimSpace(q.Get(d.param))
+		if v == "" {
+			continue
+		}
+		t, err := parseQueryTime(v)
+		if err != nil {
+			return tq, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", d.param)
+		}
+		*d.dst = &t
+	}
+	if v := strings.TrimSpace(q.Get("completed_on")); v != "" {
+		var day time.Time
+		if v == "today" {
+			now := time.Now().In(loc)
+			day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		} else {
+			var err error
+			if day, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+				return tq, errors.New("completed_on must be a YYYY-MM-DD date or today")
+			}
+		}
+		tq.completedOn = &day
+	}
+	if v := strings.TrimSpace(q.Get("fields")); v != "" {
+		fields, err := parseFields(v)
+		if err != nil {
+			return tq, err
+		}
+		tq.fields = fields
+	}
+
+	page, perPage := strings.TrimSpace(q.Get("page")), strings.TrimSpace(q.Get("per_page"))
+	if page != "" || perPage != "" {
+		tq.page, tq.perPage = 1, defaultPerPage
+		if page != "" {
+			n, err := strconv.Atoi(page)
+			if err != nil || n < 1 {
+				return tq, errors.New("page must be a positive number")
+			}
+			tq.page = n
+		}
+		if perPage != "" {
+			n, err := strconv.Atoi(perPage)
+			if err != nil || n < 1 || n > maxPerPage {
+				return tq, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+			}
+			tq.perPage = n
+		}
+	}
+
+	if v := strings.TrimSpace(q.Get("sort")); v != "" {
+		if !sortableFields[v] {
+			return tq, errors.New("sort must be one of created_at, updated_at, title or due_date")
+		}
+		tq.sortField = v
+	}
+	switch order := strings.TrimSpace(q.Get("order")); order {
+	case "", "asc":
+	case "desc":
+		tq.sortDesc = true
+	default:
+		return tq, errors.New("order must be asc or desc")
+	}
+	return tq, nil
+}
+
+// parseFields parses a comma separated list of field names, as in
+// ?fields=id,title,completed.
+func parseFields(v string) ([]string, error) {
+	var fields []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || seen[f] {
+			continue
+		}
+		if _, ok := selectableFields[f]; !ok {
+			return nil, fmt.Errorf("Unknown field %q", f)
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func parseQueryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// filter returns the query as a Mongo filter, to be combined with the
+// visibility filter.
+func (tq todoQuery) filter() bson.M {
+	f := bson.M{}
+	if tq.listID != nil {
+		f["list_id"] = *tq.listID
+	}
+	if tq.completed != nil {
+		f["completed"] = *tq.completed
+	}
+	if tq.assignee != nil {
+		if *tq.assignee != "" {
+			f["assignee_id"] = *tq.assignee
+		} else {
+			f["assignee_id"] = nil
+		}
+	}
+	if tq.starred != nil {
+		if *tq.starred {
+			f["starred"] = true
+		} else {
+			// Unstarred todos have no starred field.
+			f["starred"] = bson.M{"$ne": true}
+		}
+	}
+	addRange(f, "created_at", tq.createdAfter, tq.createdBefore)
+	addRange(f, "updated_at", tq.updatedAfter, tq.updatedBefore)
+	addRange(f, "completed_at", tq.completedAfter, tq.completedBefore)
+	if tq.completedOn != nil {
+		r, _ := f["completed_at"].(bson.M)
+		if r == nil {
+			r = bson.M{}
+		}
+		r["$gte"] = *tq.completedOn
+		r["$lt"] = tq.completedOn.AddDate(0, 0, 1)
+		f["completed_at"] = r
+	}
+	return f
+}
+
+// addRange adds an exclusive range on field to f.
+func addRange(f bson.M, field string, after, before *time.Time) {
+	r := bson.M{}
+	if after != nil {
+		r["$gt"] = *after
+	}
+	if before != nil {
+		r["$lt"] = *before
+	}
+	if len(r) > 0 {
+		f[field] = r
+	}
+}
+
+// sort returns the sort document for the query. Ties are broken by _id,
+// which also orders todos by creation, so paging through a sorted listing
+// never skips or repeats a todo. Unless another order is asked for, starred
+// todos come first.
+func (tq todoQuery) sort() bson.D {
+	dir := 1
+	if tq.sortDesc {
+		dir = -1
+	}
+	if tq.sortField == "" {
+		return bson.D{{Key: "starred", Value: -1}, {Key: "_id", Value: dir}}
+	}
+	return bson.D{{Key: tq.sortField, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+// projection returns the stored fields the selected fields are built from,
+// or nil when all fields were asked for. The fields the visibility checks
+// need are always included.
+func (tq todoQuery) projection() bson.M {
+	if tq.fields == nil {
+		return nil
+	}
+	p := bson.M{"list_id": 1, "owner_id": 1}
+	for _, f := range tq.fields {
+		for _, stored := range selectableFields[f] {
+			p[stored] = 1
+		}
+	}
+	return p
+}
+
+// titleCollation compares titles ignoring case.
+var titleCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// findOptions returns the options to list the query's todos with. Titles
+// are compared case-insensitively.
+func (tq todoQuery) findOptions() *options.FindOptions {
+	opts := options.Find().SetSort(tq.sort())
+	if tq.sortField == "title" {
+		opts.SetCollation(titleCollation)
+	}
+	if p := tq.projection(); p != nil {
+		opts.SetProjection(p)
+	}
+	if tq.perPage > 0 {
+		opts.SetSkip(int64((tq.page - 1) * tq.perPage)).SetLimit(int64(tq.perPage))
+	}
+	return opts
+}
+
+// cacheKey identifies the query's results for the read cache.
+func (tq todoQuery) cacheKey() string {
+	var b strings.Builder
+	if tq.listID != nil {
+		b.WriteString(":list:" + tq.listID.Hex())
+	}
+	if tq.completed != nil {
+		b.WriteString(":completed:" + strconv.FormatBool(*tq.completed))
+	}
+	if tq.starred != nil {
+		b.WriteString(":starred:" + strconv.FormatBool(*tq.starred))
+	}
+	if tq.assignee != nil {
+		b.WriteString(":assignee:" + strconv.Quote(*tq.assignee))
+	}
+	for _, t := range []struct {
+		name string
+		v    *time.Time
+	}{
+		{"created_after", tq.createdAfter},
+		{"created_before", tq.createdBefore},
+		{"updated_after", tq.updatedAfter},
+		{"updated_before", tq.updatedBefore},
+		{"completed_after", tq.completedAfter},
+		{"completed_before", tq.completedBefore},
+		{"completed_on", tq.completedOn},
+	} {
+		if t.v != nil {
+			b.WriteString(":" + t.name + ":" + t.v.UTC().Format(time.RFC3339Nano))
+		}
+	}
+	if tq.sortField != "" {
+		b.WriteString(":sort:" + tq.sortField)
+	}
+	if tq.sortDesc {
+		b.WriteString(":desc")
+	}
+	if tq.fields != nil {
+		b.WriteString(":fields:" + strings.Join(tq.fields, ","))
+	}
+	if tq.perPage > 0 {
+		b.WriteString(fmt.Sprintf(":page:%d:%d", tq.page, tq.perPage))
+	}
+	return b.String()
+}
+
+// selectFields returns only the given fields of t, for sparse responses.
+func selectFields(t todo, fields []string) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		switch f {
+		case "id":
+			out[f] = t.ID
+		case "title":
+			out[f] = t.Title
+		case "description":
+			out[f] = t.Description
+		case "description_html":
+			out[f] = t.DescriptionHTML
+		case "progress":
+			out[f] = t.Progress
+		case "completed":
+			out[f] = t.Completed
+		case "completed_at":
+			out[f] = t.CompletedAt
+		case "starred":
+			out[f] = t.Starred
+		case "tags":
+			out[f] = t.Tags
+		case "priority":
+			out[f] = t.Priority
+		case "due_date":
+			out[f] = t.DueDate
+		case "list_id":
+			out[f] = t.ListID
+		case "owner_id":
+			out[f] = t.OwnerID
+		case "assignee_id":
+			out[f] = t.AssigneeID
+		case "created_at":
+			out[f] = t.CreatedAt
+		case "updated_at":
+			out[f] = t.UpdatedAt
+		}
+	}
+	return out
+}
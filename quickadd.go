@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// quickAddRequest is the body of POST /todo/quickadd.
+	quickAddRequest struct {
+		Text   string `json:"text"`
+		ListID string `json:"list_id,omitempty"`
+	}
+
+	// parsedTodo is what was read from quick-add text.
+	parsedTodo struct {
+		Title    string   `json:"title"`
+		Tags     []string `json:"tags"`
+		Priority string   `json:"priority"`
+		DueDate  string   `json:"due_date"`
+	}
+)
+
+var (
+	clockPattern    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	relativePattern = regexp.MustCompile(`^(day|days|week|weeks)$`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// parseQuickAdd turns a line such as "Pay rent tomorrow 5pm #finance !high"
+// into a todo. It picks out:
+//
+//   - #tag for tags
+//   - !low, !medium or !high for the priority
+//   - today, tomorrow, a weekday ("friday", "next fri"), "in 3 days" or
+//     "in 2 weeks", or a YYYY-MM-DD date for the due date
+//   - 5pm, 5:30pm or 17:00 for the time it is due, on the due date or
+//     today; without a time, the todo is due at midnight
+//
+// optionally after "on", "by", "due" or "at". Whatever is left is the
+// title. Dates and times are in now's time zone, relative to now.
+func parseQuickAdd(text string, now time.Time) todo {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var (
+		t     todo
+		title []string
+		date  *time.Time
+		clock *time.Duration
+	)
+	words := strings.Fields(text)
+	for i := 0; i < len(words); i++ {
+		word := strings.ToLower(words[i])
+
+		switch {
+		case strings.HasPrefix(word, "#") && len(word) > 1:
+			t.Tags = append(t.Tags, word[1:])
+			continue
+		case strings.HasPrefix(word, "!") && len(word) > 1 && validPriority(word[1:]):
+			t.Priority = word[1:]
+			continue
+		}
+
+		// A leading preposition only goes with the date or time after it.
+		start := i
+		switch word {
+		case "on", "by", "due", "at":
+			if i+1 < len(words) {
+				start = i + 1
+			}
+		}
+		if d, n, ok := matchDate(words[start:], today); ok && date == nil {
+			date = &d
+			i = start + n - 1
+			continue
+		}
+		if c, n, ok := matchClock(words[start:]); ok && clock == nil {
+			clock = &c
+			i = start + n - 1
+			continue
+		}
+		title = append(title, words[i])
+	}
+
+	t.Title = strings.Join(title, " ")
+	if date != nil || clock != nil {
+		due := today
+		if date != nil {
+			due = *date
+		}
+		if clock != nil {
+			due = due.Add(*clock)
+		}
+		t.DueDate = due.Format(time.RFC3339)
+	}
+	return t
+}
+
+// matchDate reads a date from the start of words and reports how many words
+// it took.
+func matchDate(words []string, today time.Time) (time.Time, int, bool) {
+	if len(words) == 0 {
+		return time.Time{}, 0, false
+	}
+	word := strings.ToLower(words[0])
+
+	switch word {
+	case "today":
+		return today, 1, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), 1, true
+	case "next":
+		if len(words) > 1 {
+			if d, ok := nextWeekday(strings.ToLower(words[1]), today); ok {
+				return d, 2, true
+			}
+		}
+		return time.Time{}, 0, false
+	case "in":
+		if len(words) > 2 && relativePattern.MatchString(strings.ToLower(words[2])) {
+			n, err := strconv.Atoi(words[1])
+			if err != nil || n < 0 {
+				return time.Time{}, 0, false
+			}
+			if strings.HasPrefix(strings.ToLower(words[2]), "week") {
+				n *= 7
+			}
+			return today.AddDate(0, 0, n), 3, true
+		}
+		return time.Time{}, 0, false
+	}
+
+	if d, ok := nextWeekday(word, today); ok {
+		return d, 1, true
+	}
+	if d, err := time.ParseInLocation("2006-01-02", word, today.Location()); err == nil {
+		return d, 1, true
+	}
+	return time.Time{}, 0, false
+}
+
+// nextWeekday returns the first day after today that falls on the named
+// weekday.
+func nextWeekday(name string, today time.Time) (time.Time, bool) {
+	wd, ok := weekdays[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	days := (int(wd)-int(today.Weekday())+6)%7 + 1
+	return today.AddDate(0, 0, days), true
+}
+
+// matchClock reads a time of day from the start of words as an offset from
+// midnight. A bare number isn't taken as a time, so "Buy 2 apples" keeps its
+// 2.
+func matchClock(words []string) (time.Duration, int, bool) {
+	if len(words) == 0 {
+		return 0, 0, false
+	}
+	word := strings.ToLower(words[0])
+	if word == "noon" {
+		return 12 * time.Hour, 1, true
+	}
+
+	m := clockPattern.FindStringSubmatch(word)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	switch m[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, 1, true
+}
+
+// todoFromText reads one line of quick-add text into a new todo, as the
+// chat integrations do. Its errors are fit to show the user.
+func todoFromText(text string) (todoModel, error) {
+	t := parseQuickAdd(text, time.Now())
+	if err := normalizeTodo(&t); err != nil {
+		return todoModel{}, err
+	}
+	if t.Title == "" {
+		return todoModel{}, errors.New("Title is required")
+	}
+	return newTodoModel(t), nil
+}
+
+// quickAddTodo creates a todo from one line of text, answering with the
+// todo and, as "parsed", the fields read from the text so the client can
+// show what was understood.
+func quickAddTodo(w http.ResponseWriter, r *http.Request) {
+	var req quickAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to create todo",
+			Error:   "A JSON body with a \"text\" field is required",
+		})
+		return
+	}
+
+	t := parseQuickAdd(req.Text, time.Now().In(requestCaller(r).location()))
+	t.ListID = req.ListID
+	if err := normalizeTodo(&t); err != nil {
+		invalidTodo(w, "Failed to create todo", err)
+		return
+	}
+	insertTodo(w, r, t, &parsedTodo{
+		Title:    t.Title,
+		Tags:     t.Tags,
+		Priority: t.Priority,
+		DueDate:  t.DueDate,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseQuickAdd(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		text     string
+		title    string
+		tags     []string
+		priority string
+		due      string
+	}{
+		{"Pay rent tomorrow 5pm #finance !high", "Pay rent", []string{"finance"}, "high", "2026-10-15T17:00:00Z"},
+		{"Call mom on friday", "Call mom", nil, "", "2026-10-16T00:00:00Z"},
+		{"Ship it next mon at noon", "Ship it", nil, "", "2026-10-19T12:00:00Z"},
+		{"Review in 2 weeks", "Review", nil, "", "2026-10-28T00:00:00Z"},
+		{"Dentist 2026-11-03 17:30", "Dentist", nil, "", "2026-11-03T17:30:00Z"},
+		{"Standup at 9am", "Standup", nil, "", "2026-10-14T09:00:00Z"},
+		{"Water plants wednesday", "Water plants", nil, "", "2026-10-21T00:00:00Z"},
+		// A bare number isn't a time and an unknown priority is left alone.
+		{"Buy 2 apples", "Buy 2 apples", nil, "", ""},
+		{"Party !urgent", "Party !urgent", nil, "", ""},
+		{"Meet at the cafe", "Meet at the cafe", nil, "", ""},
+	}
+	for _, tt := range tests {
+		got := parseQuickAdd(tt.text, now)
+		if got.Title != tt.title || got.Priority != tt.priority || got.DueDate != tt.due || !slices.Equal(got.Tags, tt.tags) {
+			t.Errorf("parseQuickAdd(%q) = title %q, tags %v, priority %q, due %q; want %q, %v, %q, %q",
+				tt.text, got.Title, got.Tags, got.Priority, got.DueDate, tt.title, tt.tags, tt.priority, tt.due)
+		}
+	}
+}
+
+func TestTodoFromText(t *testing.T) {
+	tm, err := todoFromText("Plan trip #Travel #travel !low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm.Title != "Plan trip" || tm.Priority != priorityLow || !slices.Equal(tm.Tags, []string{"travel"}) {
+		t.Errorf("todoFromText = %+v", tm)
+	}
+
+	if _, err := todoFromText("#travel tomorrow"); err == nil {
+		t.Error("todoFromText without a title succeeded")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Per-user quotas, configurable through MAX_TODOS_PER_USER and
+// MAX_ATTACHMENT_STORAGE (bytes, across all of a user's todos). Zero means
+// unlimited.
+var (
+	maxTodosPerUser      = envInt64("MAX_TODOS_PER_USER", 0)
+	maxAttachmentStorage = envInt64("MAX_ATTACHMENT_STORAGE", 0)
+)
+
+const (
+	quotaTodos   = "todos"
+	quotaStorage = "attachment_storage"
+)
+
+// errAnonymousQuota refuses writes counted against a quota from anonymous
+// callers, who have no user to count them against.
+var errAnonymousQuota = errors.New("quotas are per user, sign in to add todos or attachments")
+
+// quotaError reports which quota a write would exceed.
+type quotaError struct {
+	resource string
+	limit    int64
+	used     int64
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("%s quota of %d exceeded", e.resource, e.limit)
+}
+
+type (
+	// quotaResponse refuses a write that would exceed a quota.
+	quotaResponse struct {
+		errorResponse
+		Quota quotaInfo `json:"quota"`
+	}
+
+	quotaInfo struct {
+		Resource string `json:"resource"`
+		Limit    int64  `json:"limit"`
+		Used     int64  `json:"used"`
+	}
+)
+
+// usage is how much a user has of each resource a quota applies to.
+type usage struct {
+	Todos             int64 `json:"todos"`
+	MaxTodos          int64 `json:"max_todos,omitempty"`
+	AttachmentStorage int64 `json:"attachment_storage"`
+	MaxStorage        int64 `json:"max_attachment_storage,omitempty"`
+}
+
+// owner is the caller whose quotas t counts against.
+func (t todoModel) owner() caller {
+	return caller{userID: t.OwnerID, workspace: t.WorkspaceID}
+}
+
+// todoCount counts the todos c owns in c's workspace.
+func (s *todoService) todoCount(ctx context.Context, c caller) (int64, error) {
+	return s.todos.CountDocuments(ctx, c.scoped(bson.M{"owner_id": c.userID}))
+}
+
+// attachmentStorage sums the size of the attachments on the todos c owns in
+// c's workspace.
+func (s *todoService) attachmentStorage(ctx context.Context, c caller) (int64, error) {
+	cursor, err := s.attachments.Aggregate(ctx, []bson.M{
+		{"$match": c.scoped(bson.M{"owner_id": c.userID})},
+		{"$group": bson.M{"_id": nil, "size": bson.M{"$sum": "$size"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var rows []struct {
+		Size int64 `bson:"size"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return rows[0].Size, nil
+}
+
+// checkTodoQuota fails with a quotaError if owner can't have another todo,
+// and with errAnonymousQuota if there is no owner. The check and the insert
+// that follows aren't atomic, so concurrent creates can overshoot the limit
+// by a few.
+func (s *todoService) checkTodoQuota(ctx context.Context, owner caller) error {
+	if maxTodosPerUser <= 0 {
+		return nil
+	}
+	if owner.userID == "" {
+		return errAnonymousQuota
+	}
+	n, err := s.todoCount(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if n >= maxTodosPerUser {
+		return &quotaError{resource: quotaTodos, limit: maxTodosPerUser, used: n}
+	}
+	return nil
+}
+
+// checkStorageQuota fails with a quotaError if size more bytes of
+// attachments would take owner over their storage quota, and with
+// errAnonymousQuota if there is no owner.
+func (s *todoService) checkStorageQuota(ctx context.Context, owner caller, size int64) error {
+	if maxAttachmentStorage <= 0 {
+		return nil
+	}
+	if owner.userID == "" {
+		return errAnonymousQuota
+	}
+	used, err := s.attachmentStorage(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if used+size > maxAttachmentStorage {
+		return &quotaError{resource: quotaStorage, limit: maxAttachmentStorage, used: used}
+	}
+	return nil
+}
+
+func (s *todoService) usage(ctx context.Context, c caller) (usage, error) {
+	u := usage{MaxTodos: maxTodosPerUser, MaxStorage: maxAttachmentStorage}
+	var err error
+	if u.Todos, err = s.todoCount(ctx, c); err != nil {
+		return u, err
+	}
+	u.AttachmentStorage, err = s.attachmentStorage(ctx, c)
+	return u, err
+}
+
+// quotaExceeded writes a 403 response describing the quota if err is a
+// quotaError, or a 401 if it is errAnonymousQuota, and reports whether it
+// did.
+func quotaExceeded(w http.ResponseWriter, message string, err error) bool {
+	if errors.Is(err, errAnonymousQuota) {
+		rnd.JSON(w, http.StatusUnauthorized, errorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+		return true
+	}
+	var qe *quotaError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	rnd.JSON(w, http.StatusForbidden, quotaResponse{
+		errorResponse: errorResponse{
+			Message: message,
+			Error:   qe.Error(),
+		},
+		Quota: quotaInfo{
+			Resource: qe.resource,
+			Limit:    qe.limit,
+			Used:     qe.used,
+		},
+	})
+	return true
+}
+
+func fetchUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	u, err := svc.usage(ctx, requestCaller(r))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch usage",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	respond(w, r, http.StatusOK, itemResponse[usage]{
+		Data: u,
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// panicReporter is told about every recovered panic, e.g. to forward it to
+// an error tracker. It is nil unless one is configured.
+var panicReporter func(r *http.Request, v interface{}, stack []byte)
+
+// recoverer turns a panic in a handler into a 500 response and logs it with
+// its stack trace, rather than letting it drop the connection silently. It
+// runs after the request's user is known, so reports name them. A response
+// that has already started can't become a 500 any more; it is cut short
+// instead, so the client doesn't take it for a complete one.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Handlers panic with ErrAbortHandler on purpose to cut a
+			// response short; net/http deals with it quietly.
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			stack := debug.Stack()
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, stack)
+			if panicReporter != nil {
+				panicReporter(r, v, stack)
+			}
+
+			if ww.Status() != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+				Message: "Internal server error",
+			})
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
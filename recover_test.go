@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// panickingStore panics while listing todos, after handing fn the todos it
+// has.
+type panickingStore struct {
+	todoStore
+	todos []todoModel
+}
+
+func (p panickingStore) each(ctx context.Context, c caller, q todoQuery, fn func(todoModel) error) error {
+	for _, t := range p.todos {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	panic("boom")
+}
+
+func TestRecovererReportsUser(t *testing.T) {
+	old, oldReporter := store, panicReporter
+	t.Cleanup(func() { store, panicReporter = old, oldReporter })
+	store = panickingStore{}
+
+	var reported caller
+	panicReporter = func(r *http.Request, v interface{}, stack []byte) {
+		reported = requestCaller(r)
+	}
+
+	rec := serve(t, http.MethodGet, "/todo/", "ann", "")
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "Internal server error") {
+		t.Errorf("status %d; body %s", rec.Code, rec.Body)
+	}
+	if reported.userID != "ann" {
+		t.Errorf("reported caller = %+v", reported)
+	}
+}
+
+func TestRecovererCutsStartedResponses(t *testing.T) {
+	old := store
+	t.Cleanup(func() { store = old })
+	store = panickingStore{todos: []todoModel{{Title: "Buy milk"}}}
+
+	rec := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want ErrAbortHandler", v)
+			}
+		}()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todo/", nil))
+	}()
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Internal server error") {
+		t.Errorf("status %d; body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient parses a URL such as redis://:password@host:6379/0, or
+// rediss:// for TLS. It doesn't connect until the first command.
+func newRedisClient(rawURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	opts.PoolSize = int(envInt64("REDIS_POOL_SIZE", 8))
+	client := redis.NewClient(opts)
+	if tracerProvider != nil {
+		// Without the commands' arguments, which hold cached todos.
+		if err := redisotel.InstrumentTracing(client, redisotel.WithDBStatement(false)); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// redisSubscribe calls fn with every message published to channel until ctx
+// is done. The client reconnects when the connection drops; messages
+// published while disconnected are lost.
+func redisSubscribe(ctx context.Context, client *redis.Client, channel string, fn func(message string)) {
+	sub := client.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("Redis subscription to %s failed, retrying in the background: %v", channel, err)
+	}
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			fn(msg.Payload)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+type (
+	// errorResponse is the body of every failed request. Error, when
+	// present, says what was wrong.
+	errorResponse struct {
+		Message string `json:"message"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	// messageResponse confirms a request that has nothing else to return.
+	messageResponse struct {
+		Message string `json:"message"`
+	}
+
+	// todoResponse carries a single todo.
+	todoResponse struct {
+		Message string `json:"message,omitempty"`
+		Data    todo   `json:"data"`
+
+		// Warning and DuplicateOf are set when a new todo has the same
+		// title as one that is already open.
+		Warning     string `json:"warning,omitempty"`
+		DuplicateOf string `json:"duplicate_of,omitempty"`
+
+		// Parsed is what POST /todo/quickadd read from its text.
+		Parsed *parsedTodo `json:"parsed,omitempty"`
+	}
+
+	// itemResponse carries any other single item.
+	itemResponse[T any] struct {
+		Message string `json:"message,omitempty"`
+		Data    T      `json:"data"`
+	}
+
+	// listResponse carries a collection. Data is never null, so handlers
+	// pass an empty slice rather than a nil one.
+	listResponse[T any] struct {
+		Data []T `json:"data"`
+
+		// page and perPage are set when a listing is read a page at a
+		// time, for formats that link to the other pages.
+		page, perPage int
+	}
+)
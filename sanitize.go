@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// maxTitleLength and maxDescriptionLength cap, in characters, what
+	// clients may store in a todo's title and description.
+	maxTitleLength       = int(envInt64("MAX_TITLE_LENGTH", 200))
+	maxDescriptionLength = int(envInt64("MAX_DESCRIPTION_LENGTH", 10000))
+)
+
+// htmlTagRe matches HTML tags and comments, including a tag or comment left
+// open at the end of the text, which browsers would still act on. Markdown
+// autolinks such as <https://example.com> aren't tags and are kept.
+var htmlTagRe = regexp.MustCompile(`(?s)<!--.*?(?:-->|$)|</?[a-zA-Z][a-zA-Z0-9-]*(?:\s[^<>]*)?(?:/?>|$)`)
+
+// fieldError is a value a client sent that can't be stored, naming the
+// field it was sent in.
+type fieldError struct {
+	field   string
+	message string
+}
+
+func (e *fieldError) Error() string {
+	return e.message
+}
+
+// fieldErrorResponse answers a request with a fieldError.
+type fieldErrorResponse struct {
+	errorResponse
+	Field string `json:"field"`
+}
+
+// invalidTodo answers a todo that failed normalizeTodo: 422 naming the
+// field for values that can't be stored, 400 for the rest.
+func invalidTodo(w http.ResponseWriter, message string, err error) {
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		rnd.JSON(w, http.StatusUnprocessableEntity, fieldErrorResponse{
+			errorResponse: errorResponse{Message: message, Error: fe.message},
+			Field:         fe.field,
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusBadRequest, errorResponse{
+		Message: message,
+		Error:   err.Error(),
+	})
+}
+
+// sanitizeTodoText brings a todo's title and description into their stored
+// form, failing when either is too long.
+func sanitizeTodoText(t *todo) error {
+	t.Title = sanitizeTitle(t.Title)
+	if utf8.RuneCountInString(t.Title) > maxTitleLength {
+		return &fieldError{"title", fmt.Sprintf("Title must be at most %d characters", maxTitleLength)}
+	}
+	t.Description = sanitizeDescription(t.Description)
+	if utf8.RuneCountInString(t.Description) > maxDescriptionLength {
+		return &fieldError{"description", fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength)}
+	}
+	return nil
+}
+
+// sanitizeTitle strips HTML and control characters from a title, making
+// line breaks and tabs spaces, and trims it.
+func sanitizeTitle(s string) string {
+	s = stripHTML(s)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// sanitizeDescription strips HTML, outside code blocks, and control
+// characters other than line breaks and tabs from a description, and trims
+// it.
+func sanitizeDescription(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	var parts, text []string
+	flush := func() {
+		if text != nil {
+			parts = append(parts, stripHTML(strings.Join(text, "\n")))
+			text = nil
+		}
+	}
+	inCode := false
+	for _, line := range strings.Split(s, "\n") {
+		fence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		if inCode || fence {
+			flush()
+			parts = append(parts, line)
+			if fence {
+				inCode = !inCode
+			}
+			continue
+		}
+		text = append(text, line)
+	}
+	flush()
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// stripHTML removes tags until none are left, since removing one can join
+// the text around it into another, as in "<scr<b>ipt>".
+func stripHTML(s string) string {
+	for {
+		stripped := htmlTagRe.ReplaceAllString(s, "")
+		if stripped == s {
+			return s
+		}
+		s = stripped
+	}
+}
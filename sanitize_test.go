@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeTitle(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"  Buy milk  ", "Buy milk"},
+		{"Buy\tmilk\r\nand eggs", "Buy milk  and eggs"},
+		{"Buy\x00 milk\x1b", "Buy milk"},
+		{`<script>alert(1)</script>Buy <b>milk</b>`, "alert(1)Buy milk"},
+		{"a < b and c > d", "a < b and c > d"},
+		{"<!-- hidden -->Call mum", "Call mum"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeTitle(tt.in); got != tt.want {
+			t.Errorf("sanitizeTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeDescription(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"\n  Steps:\r\n- [ ] one\x07\n\n", "Steps:\n- [ ] one"},
+		{`See <a href="x" onclick="steal()">this</a> and <https://example.com>`, "See this and <https://example.com>"},
+		{"<div>\nIntro\n</div>\n```html\n<div>kept</div>\n```\n<i>gone</i>", "Intro\n\n```html\n<div>kept</div>\n```\ngone"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeDescription(tt.in); got != tt.want {
+			t.Errorf("sanitizeDescription(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"<scr<script>ipt>alert(1)</scr</script>ipt>", "alert(1)"},
+		{"<<b>script>alert(1)<</b>/script>", "alert(1)"},
+		{"<<script>script>alert(1)<</script>/script>", "alert(1)"},
+		{"<im<!-- x -->g src=x onerror=alert(1)>", ""},
+		{"Buy milk <img src=x onerror=alert(1)", "Buy milk "},
+		{"Buy milk <img src=x onerror=alert(1) <b>eggs</b>", "Buy milk "},
+		{"Buy milk <!-- never closed <script>", "Buy milk "},
+		{"<b\n>bold</b\t>", "bold"},
+		{"a < b, b > c and <https://example.com>", "a < b, b > c and <https://example.com>"},
+		{"x<3 and 2>1", "x<3 and 2>1"},
+	}
+	for _, tt := range tests {
+		if got := stripHTML(tt.in); got != tt.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTooLongTodoIsUnprocessable(t *testing.T) {
+	unreachableService(t)
+
+	tests := []struct {
+		name, method, path, body string
+		field                    string
+	}{
+		{"create title", http.MethodPost, "/todo/", `{"title":"` + strings.Repeat("a", maxTitleLength+1) + `"}`, "title"},
+		{"create description", http.MethodPost, "/todo/", `{"title":"a","description":"` + strings.Repeat("a", maxDescriptionLength+1) + `"}`, "description"},
+		{"update title", http.MethodPut, "/todo/000000000000000000000001", `{"title":"` + strings.Repeat("a", maxTitleLength+1) + `"}`, "title"},
+		{"quickadd title", http.MethodPost, "/todo/quickadd", `{"text":"` + strings.Repeat("a", maxTitleLength+1) + `"}`, "title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.method, tt.path, "", tt.body)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want 422; body %s", rec.Code, rec.Body)
+			}
+			var resp fieldErrorResponse
+			decodeBody(t, rec, &resp)
+			if resp.Field != tt.field || !strings.Contains(resp.Error, "at most") {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+
+	// Markup alone leaves no title.
+	rec := serve(t, http.MethodPost, "/todo/", "", `{"title":"<b></b>"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("markup-only title: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+)
+
+// seedFile is a fixture loaded at startup, once the database is up, when
+// the database has no todos yet. The --seed flag overrides it.
+var seedFile = envString("SEED_FILE", "")
+
+// seedEndpoint serves POST /admin/seed, which loads a fixture on demand.
+// It is meant for test environments.
+var seedEndpoint = envBool("SEED_ENDPOINT", false)
+
+// seedTimeout bounds loading the startup fixture.
+const seedTimeout = 5 * time.Minute
+
+type (
+	// seedFixture is a set of lists and todos to start from, in JSON or
+	// YAML:
+	//
+	//	lists:
+	//	  - name: Home
+	//	    owner: ann
+	//	todos:
+	//	  - title: Buy milk
+	//	    tags: [errands]
+	//	    owner: ann
+	//	    list: Home
+	//	  - title: Read the docs
+	//
+	// Todos without an owner are anonymous. A todo's list, named by its
+	// name, must belong to the todo's owner. Lists and todos without a
+	// workspace go in the default one.
+	seedFixture struct {
+		Lists []seedList `json:"lists" yaml:"lists"`
+		Todos []seedTodo `json:"todos" yaml:"todos"`
+	}
+
+	seedList struct {
+		Name      string `json:"name" yaml:"name"`
+		Owner     string `json:"owner" yaml:"owner"`
+		Workspace string `json:"workspace" yaml:"workspace"`
+	}
+
+	seedTodo struct {
+		Title       string   `json:"title" yaml:"title"`
+		Description string   `json:"description" yaml:"description"`
+		Completed   bool     `json:"completed" yaml:"completed"`
+		Tags        []string `json:"tags" yaml:"tags"`
+		Priority    string   `json:"priority" yaml:"priority"`
+		DueDate     string   `json:"due_date" yaml:"due_date"`
+		Owner       string   `json:"owner" yaml:"owner"`
+		List        string   `json:"list" yaml:"list"`
+		Workspace   string   `json:"workspace" yaml:"workspace"`
+	}
+
+	// seedOwner is whose todos and lists a batch of the fixture holds.
+	seedOwner struct {
+		workspace string
+		user      string
+	}
+
+	// seedResult reports what a fixture created.
+	seedResult struct {
+		Lists int `json:"lists"`
+		Todos int `json:"todos"`
+	}
+)
+
+// parseFixture reads a fixture in YAML, or in JSON unless isYAML is set.
+func parseFixture(data []byte, isYAML bool) (seedFixture, error) {
+	var f seedFixture
+	var err error
+	if isYAML {
+		err = yaml.UnmarshalStrict(data, &f)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&f)
+	}
+	return f, err
+}
+
+// batches validates the fixture and splits it by owner into batches for
+// importTodos.
+func (f seedFixture) batches() (map[seedOwner]*importBatch, error) {
+	batches := map[seedOwner]*importBatch{}
+	batch := func(owner seedOwner) *importBatch {
+		if batches[owner] == nil {
+			batches[owner] = &importBatch{}
+		}
+		return batches[owner]
+	}
+
+	type listKey struct {
+		owner seedOwner
+		name  string
+	}
+	lists := map[listKey]int{}
+	for i, l := range f.Lists {
+		name := strings.TrimSpace(l.Name)
+		if name == "" || l.Owner == "" {
+			return nil, fmt.Errorf("list %d: a name and an owner are required", i+1)
+		}
+		owner := seedOwner{workspace: l.Workspace, user: l.Owner}
+		key := listKey{owner, name}
+		if _, ok := lists[key]; ok {
+			return nil, fmt.Errorf("list %d: %s already has a list named %q", i+1, l.Owner, name)
+		}
+		b := batch(owner)
+		lists[key] = len(b.lists)
+		b.lists = append(b.lists, name)
+	}
+
+	for i, st := range f.Todos {
+		t := todo{
+			Title:       st.Title,
+			Description: st.Description,
+			Completed:   st.Completed,
+			Tags:        st.Tags,
+			Priority:    st.Priority,
+			DueDate:     st.DueDate,
+		}
+		if err := normalizeTodo(&t); err != nil {
+			return nil, fmt.Errorf("todo %d: %w", i+1, err)
+		}
+		if t.Title == "" {
+			return nil, fmt.Errorf("todo %d: Title is required", i+1)
+		}
+
+		owner := seedOwner{workspace: st.Workspace, user: st.Owner}
+		list := -1
+		if st.List != "" {
+			var ok bool
+			if list, ok = lists[listKey{owner, strings.TrimSpace(st.List)}]; !ok {
+				return nil, fmt.Errorf("todo %d: %q isn't a list of %q in the fixture", i+1, st.List, st.Owner)
+			}
+		}
+		b := batch(owner)
+		b.todos = append(b.todos, importedTodo{todo: newTodoModel(t), list: list})
+	}
+	return batches, nil
+}
+
+// seed creates the fixture's lists and todos, each owner's in one go.
+func (s *todoService) seed(ctx context.Context, f seedFixture) (seedResult, error) {
+	batches, err := f.batches()
+	if err != nil {
+		return seedResult{}, err
+	}
+
+	var res seedResult
+	for owner, b := range batches {
+		c := caller{userID: owner.user, workspace: owner.workspace, addr: "seed"}
+		lists, err := s.importTodos(ctx, c, *b)
+		res.Lists += len(lists)
+		if err != nil {
+			return res, err
+		}
+		res.Todos += len(b.todos)
+	}
+	return res, nil
+}
+
+// resetData deletes every todo and list, with their history and
+// attachments. Sync clients are told the todos were deleted.
+func (s *todoService) resetData(ctx context.Context) error {
+	cursor, err := s.todos.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var todos []todoModel
+	if err := cursor.All(ctx, &todos); err != nil {
+		return err
+	}
+	for _, t := range todos {
+		if _, err := s.todos.DeleteOne(ctx, bson.M{"_id": t.ID}); err != nil {
+			return err
+		}
+		s.changed(ctx, eventTodoDeleted, t)
+		if err := s.bury(ctx, t); err != nil {
+			return err
+		}
+		if _, err := s.purgeTodo(ctx, t.ID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.lists.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.cache.invalidateLists(context.WithoutCancel(ctx))
+	}
+	return nil
+}
+
+// seedFromFile loads seedFile unless the database already has todos, so a
+// development server can be restarted without piling up copies.
+func seedFromFile(ctx context.Context) error {
+	data, err := os.ReadFile(seedFile)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(seedFile))
+	f, err := parseFixture(data, ext == ".yaml" || ext == ".yml")
+	if err != nil {
+		return fmt.Errorf("%s: %w", seedFile, err)
+	}
+
+	n, err := svc.todos.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Database has todos already, not seeding from %s", seedFile)
+		return nil
+	}
+
+	res, err := svc.seed(ctx, f)
+	if err != nil {
+		return err
+	}
+	log.Printf("Seeded %d lists and %d todos from %s", res.Lists, res.Todos, seedFile)
+	return nil
+}
+
+// seedTodos loads the fixture in the body, JSON or, with a YAML content
+// type, YAML. With ?reset=true every todo and list is deleted first, so
+// the data is exactly the fixture's.
+func seedTodos(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, errorResponse{
+			Message: "Failed to seed todos",
+			Error:   fmt.Sprintf("Fixtures must be at most %d bytes", maxImportSize),
+		})
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var f seedFixture
+	if err == nil {
+		f, err = parseFixture(data, strings.HasSuffix(mediaType, "yaml"))
+	}
+	if err == nil {
+		_, err = f.batches()
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Failed to seed todos",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+
+	if r.URL.Query().Get("reset") == "true" {
+		if err := svc.resetData(ctx); err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+				Message: "Failed to reset data",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	res, err := svc.seed(ctx, f)
+	if quotaExceeded(w, "Failed to seed todos", err) {
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to seed todos",
+			Error:   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, itemResponse[seedResult]{
+		Message: fmt.Sprintf("Seeded %d lists and %d todos", res.Lists, res.Todos),
+		Data:    res,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFixture(t *testing.T) {
+	yamlFixture := `
+lists:
+  - name: Home
+    owner: ann
+todos:
+  - title: Buy milk
+    tags: [Errands]
+    priority: high
+    due_date: 2026-10-20
+    owner: ann
+    list: Home
+  - title: Read the docs
+`
+	jsonFixture := `{
+		"lists": [{"name": "Home", "owner": "ann"}],
+		"todos": [
+			{"title": "Buy milk", "tags": ["Errands"], "priority": "high", "due_date": "2026-10-20", "owner": "ann", "list": "Home"},
+			{"title": "Read the docs"}
+		]
+	}`
+
+	for _, tt := range []struct {
+		data   string
+		isYAML bool
+	}{{yamlFixture, true}, {jsonFixture, false}} {
+		f, err := parseFixture([]byte(tt.data), tt.isYAML)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches, err := f.batches()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ann, anon := batches[seedOwner{user: "ann"}], batches[seedOwner{}]
+		if ann == nil || anon == nil || len(ann.lists) != 1 || len(ann.todos) != 1 || len(anon.todos) != 1 {
+			t.Fatalf("batches = %+v", batches)
+		}
+		milk := ann.todos[0]
+		if milk.list != 0 || milk.todo.Tags[0] != "errands" || milk.todo.DueDate == nil || milk.todo.DueDate.Day() != 20 {
+			t.Errorf("Buy milk = list %d, %+v", milk.list, milk.todo)
+		}
+		if anon.todos[0].list != -1 {
+			t.Errorf("Read the docs is in list %d", anon.todos[0].list)
+		}
+	}
+}
+
+func TestFixtureErrors(t *testing.T) {
+	tests := []struct {
+		fixture string
+		err     string
+	}{
+		{`{"todos": [{"description": "x"}]}`, "todo 1: Title is required"},
+		{`{"todos": [{"title": "x", "priority": "urgent"}]}`, "todo 1: Priority must be"},
+		{`{"todos": [{"title": "x", "list": "Work", "owner": "ann"}]}`, `"Work" isn't a list of "ann"`},
+		{`{"lists": [{"name": "Work"}]}`, "list 1: a name and an owner are required"},
+		{`{"lists": [{"name": "Work", "owner": "ann"}, {"name": "Work", "owner": "ann"}]}`, "list 2: ann already has"},
+		{`{"todo": []}`, "unknown field"},
+	}
+	for _, tt := range tests {
+		f, err := parseFixture([]byte(tt.fixture), false)
+		if err == nil {
+			_, err = f.batches()
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.fixture, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	sentryhttp "github.com/getsentry/sentry-go/http"
+)
+
+// sentryHandler gives each request its own Sentry hub carrying the request's
+// details. It is nil unless SENTRY_DSN is set.
+var sentryHandler *sentryhttp.Handler
+
+// setupSentry reports panics to SENTRY_DSN, if set. An invalid DSN is
+// ignored and recorded in configErrors.
+func setupSentry() {
+	dsn := envString("SENTRY_DSN", "")
+	if dsn == "" {
+		return
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: envString("SENTRY_ENVIRONMENT", "production"),
+		BeforeSend:  dropAbortedResponses,
+	})
+	if err != nil {
+		invalidSetting("SENTRY_DSN", err)
+		return
+	}
+	sentryHandler = sentryhttp.New(sentryhttp.Options{Repanic: true})
+	panicReporter = reportPanic
+}
+
+// reportToSentry sets up the request's hub for reportPanic. It goes in front
+// of recoverer, which answers the panics itself.
+func reportToSentry(next http.Handler) http.Handler {
+	if sentryHandler == nil {
+		return next
+	}
+	return sentryHandler.Handle(next)
+}
+
+// reportPanic sends the panic through the request's hub, naming the user.
+// The SDK sends it in the background so the response isn't held up.
+func reportPanic(r *http.Request, v interface{}, stack []byte) {
+	hub := sentry.GetHubFromContext(r.Context())
+	if hub == nil {
+		hub = sentry.CurrentHub().Clone()
+	}
+	if c := requestCaller(r); c.userID != "" {
+		hub.Scope().SetUser(sentry.User{ID: c.userID})
+	}
+	hub.Recover(v)
+}
+
+// dropAbortedResponses skips the ErrAbortHandler that recoverer panics with
+// after reporting a panic in a response that had already started, which
+// sentryhttp would otherwise report a second time.
+func dropAbortedResponses(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+	if hint != nil && hint.RecoveredException == http.ErrAbortHandler {
+		return nil
+	}
+	return event
+}
+
+// flushSentry waits briefly for reports still being sent at shutdown.
+func flushSentry() {
+	if sentryHandler != nil {
+		sentry.Flush(2 * time.Second)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errTodoNotFound = errors.New("todo not found")
+	errForbidden    = errors.New("not allowed")
+)
+
+// todoService holds the todo operations shared by the HTTP handlers. Every
+// write goes through it so that side effects such as the audit log are
+// recorded in one place.
+type todoService struct {
+	todos       *mongo.Collection
+	history     *mongo.Collection
+	attachments *mongo.Collection
+	lists       *mongo.Collection
+	apiKeys     *mongo.Collection
+	users       *mongo.Collection
+	tombstones  *mongo.Collection
+	deletions   *mongo.Collection
+	blobs       blobStore
+	cache       readCache // nil when caching is disabled
+	events      *eventBus
+}
+
+// todoStore is what the todo CRUD handlers need from todoService, so their
+// tests can run against todos kept in memory instead of MongoDB. Each call
+// to todoService is bounded by storeTimeout.
+type todoStore interface {
+	each(ctx context.Context, c caller, q todoQuery, fn func(todoModel) error) error
+	get(ctx context.Context, c caller, id primitive.ObjectID) (todoModel, error)
+	create(ctx context.Context, c caller, tm todoModel) error
+	update(ctx context.Context, c caller, id primitive.ObjectID, t todo) error
+	delete(ctx context.Context, c caller, id primitive.ObjectID) error
+	duplicateOf(ctx context.Context, c caller, title string) (primitive.ObjectID, bool, error)
+}
+
+func newTodoService(db *mongo.Database, blobs blobStore, cache readCache, events *eventBus) *todoService {
+	s := &todoService{
+		todos:       db.Collection(collName),
+		history:     db.Collection(historyCollName),
+		attachments: db.Collection(attachmentsCollName),
+		lists:       db.Collection(listsCollName),
+		apiKeys:     db.Collection(apiKeysCollName),
+		users:       db.Collection(usersCollName),
+		tombstones:  db.Collection(tombstonesCollName),
+		deletions:   db.Collection(accountDeletionsCollName),
+		blobs:       blobs,
+		cache:       cache,
+		events:      events,
+	}
+	events.remote = s.remoteChange
+	return s
+}
+
+func (s *todoService) ensureIndexes(ctx context.Context) error {
+	_, err := s.history.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "todo_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.attachments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "todo_id", Value: 1}}},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.lists.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+		{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.todos.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "list_id", Value: 1}}},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+		{Keys: bson.D{{Key: "workspace_id", Value: 1}}},
+		// Serves duplicateOf.
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "title", Value: 1}},
+			Options: options.Index().
+				SetCollation(titleCollation).
+				SetPartialFilterExpression(bson.M{"completed": false}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.apiKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.ensureTombstoneIndexes(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureAccountIndexes(ctx); err != nil {
+		return err
+	}
+	return s.ensurePurgeIndexes(ctx)
+}
+
+// list returns the todos c can see that match q.
+func (s *todoService) list(ctx context.Context, c caller, q todoQuery) ([]todoModel, error) {
+	var todos []todoModel
+	err := s.each(ctx, c, q, func(t todoModel) error {
+		todos = append(todos, t)
+		return nil
+	})
+	return todos, err
+}
+
+// each calls fn with every todo c can see that matches q, as they are read
+// from the cursor. It stops at the first error fn returns.
+func (s *todoService) each(ctx context.Context, c caller, q todoQuery, fn func(todoModel) error) error {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	var (
+		key    string
+		gen    uint64
+		cached []todoModel
+	)
+	if s.cache != nil {
+		key = listCacheKey(c, q)
+		if todos, ok := s.cache.list(ctx, key); ok {
+			for _, t := range todos {
+				if err := fn(t); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		gen = s.cache.generation(ctx)
+	}
+	if err := storeMissed(ctx); err != nil {
+		return err
+	}
+
+	filter, err := s.visibleFilter(ctx, c)
+	if err != nil {
+		return err
+	}
+	if qf := q.filter(); len(qf) > 0 {
+		filter = bson.M{"$and": bson.A{filter, qf}}
+	}
+
+	cursor, err := s.todos.Find(ctx, filter, q.findOptions())
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var t todoModel
+		if err := cursor.Decode(&t); err != nil {
+			return err
+		}
+		if s.cache != nil {
+			cached = append(cached, t)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if s.cache != nil {
+		s.cache.putList(ctx, key, cached, gen)
+	}
+	return nil
+}
+
+// get returns the todo with the given id if c may read it.
+func (s *todoService) get(ctx context.Context, c caller, id primitive.ObjectID) (todoModel, error) {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+	return s.getForAccess(ctx, c, id, false)
+}
+
+func (s *todoService) getForAccess(ctx context.Context, c caller, id primitive.ObjectID, write bool) (todoModel, error) {
+	t, err := s.find(ctx, id)
+	if err != nil {
+		return t, err
+	}
+	return t, s.authorize(ctx, c, t, write)
+}
+
+// find reads a todo from the cache or the database, without checking
+// access.
+func (s *todoService) find(ctx context.Context, id primitive.ObjectID) (todoModel, error) {
+	var gen uint64
+	if s.cache != nil {
+		if t, ok := s.cache.todo(ctx, id); ok {
+			return t, nil
+		}
+		gen = s.cache.generation(ctx)
+	}
+	if err := storeMissed(ctx); err != nil {
+		return todoModel{}, err
+	}
+
+	var t todoModel
+	err := s.todos.FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return t, errTodoNotFound
+	}
+	if err != nil {
+		return t, err
+	}
+	if s.cache != nil {
+		s.cache.putTodo(ctx, t, gen)
+	}
+	return t, nil
+}
+
+// changed drops the cached reads a write to t affects and tells subscribers
+// about it. The write has happened by now, so this goes ahead even if the
+// request is cancelled.
+func (s *todoService) changed(ctx context.Context, typ string, t todoModel) {
+	if s.cache != nil {
+		s.cache.invalidateTodo(context.WithoutCancel(ctx), t.ID)
+	}
+	s.events.publish(newTodoEvent(typ, t))
+}
+
+// remoteChange keeps this instance's in-memory cache in step with writes
+// made through other instances. A shared cache was invalidated by the
+// instance that made the write.
+func (s *todoService) remoteChange(ev todoEvent) {
+	if _, ok := s.cache.(*memoryCache); !ok {
+		return
+	}
+	switch ev.Type {
+	case eventListMembers:
+		s.cache.invalidateLists(context.Background())
+	default:
+		if id, err := primitive.ObjectIDFromHex(ev.TodoID); err == nil {
+			s.cache.invalidateTodo(context.Background(), id)
+		}
+	}
+}
+
+func (s *todoService) create(ctx context.Context, c caller, tm todoModel) error {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if tm.ListID != nil {
+		role, err := s.listRole(ctx, c, *tm.ListID)
+		if err != nil {
+			return err
+		}
+		if !role.canWrite() {
+			return errForbidden
+		}
+	}
+	tm.OwnerID = c.userID
+	tm.WorkspaceID = c.workspace
+	if err := s.checkTodoQuota(ctx, c); err != nil {
+		return err
+	}
+
+	if _, err := s.todos.InsertOne(ctx, tm); err != nil {
+		return err
+	}
+	s.changed(ctx, eventTodoCreated, tm)
+	return s.recordHistory(ctx, tm.ID, actionCreate, c.actor(), nil, diffTodos(nil, &tm))
+}
+
+func (s *todoService) update(ctx context.Context, c caller, id primitive.ObjectID, t todo) error {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	current, err := s.getForAccess(ctx, c, id, true)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"title":       t.Title,
+		"description": t.Description,
+		"completed":   t.Completed,
+		"updated_at":  now,
+	}
+	unset := bson.M{}
+	switch {
+	case t.Completed && !current.Completed:
+		set["completed_at"] = now
+	case !t.Completed:
+		unset["completed_at"] = ""
+	}
+	if len(t.Tags) > 0 {
+		set["tags"] = t.Tags
+	} else {
+		unset["tags"] = ""
+	}
+	if t.Priority != "" {
+		set["priority"] = t.Priority
+	} else {
+		unset["priority"] = ""
+	}
+	if due := t.dueDate(); due != nil {
+		set["due_date"] = *due
+	} else {
+		unset["due_date"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		// Older servers reject an empty $unset.
+		update["$unset"] = unset
+	}
+
+	var before todoModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = s.todos.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errTodoNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	after := before
+	after.Title = t.Title
+	after.Description = t.Description
+	after.Completed = t.Completed
+	after.Tags = t.Tags
+	after.Priority = t.Priority
+	after.DueDate = t.dueDate()
+	after.UpdatedAt = now
+	switch {
+	case t.Completed && !before.Completed:
+		after.CompletedAt = &now
+	case !t.Completed:
+		after.CompletedAt = nil
+	}
+	s.changed(ctx, eventTodoUpdated, after)
+
+	changes := diffTodos(&before, &after)
+	if len(changes) == 0 {
+		return nil
+	}
+	return s.recordHistory(ctx, id, actionUpdate, c.actor(), &before, changes)
+}
+
+func (s *todoService) delete(ctx context.Context, c caller, id primitive.ObjectID) error {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if _, err := s.getForAccess(ctx, c, id, true); err != nil {
+		return err
+	}
+
+	var before todoModel
+	err := s.todos.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errTodoNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.changed(ctx, eventTodoDeleted, before)
+	if err := s.bury(ctx, before); err != nil {
+		return err
+	}
+	return s.recordHistory(ctx, id, actionDelete, c.actor(), &before, diffTodos(&before, nil))
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	sessionsCollName = "sessions"
+	sessionCookie    = "todo_session"
+	sessionMaxAge    = 30 * 24 * time.Hour
+	csrfHeader       = "X-CSRF-Token"
+	csrfFormField    = "csrf_token"
+)
+
+var errSessionNotFound = errors.New("session not found")
+
+// sessions holds the web UI's login sessions.
+var sessions sessionStore
+
+// cookieSecure marks the session cookie Secure. It defaults to on when the
+// app's public URL is https, since TLS is usually terminated by a proxy and
+// r.TLS can't tell.
+var cookieSecure = envBool("COOKIE_SECURE", strings.HasPrefix(oauthRedirectBase, "https://"))
+
+type session struct {
+	ID          string      `bson:"-"`
+	UserID      string      `bson:"user_id"`
+	WorkspaceID string      `bson:"workspace_id,omitempty"`
+	Preferences preferences `bson:"preferences,omitempty"`
+	CSRFToken   string      `bson:"csrf_token"`
+	CreatedAt   time.Time   `bson:"created_at"`
+	ExpiresAt   time.Time   `bson:"expires_at"`
+}
+
+// sessionStore keeps sessions by their id, which is the secret held in the
+// session cookie. Expired sessions must not be returned.
+type sessionStore interface {
+	get(ctx context.Context, id string) (session, error)
+	save(ctx context.Context, s session) error
+	delete(ctx context.Context, id string) error
+	// deleteUser deletes every session of a user, e.g. when their
+	// account is erased.
+	deleteUser(ctx context.Context, userID string) error
+}
+
+// newSessionStore picks the store from SESSION_STORE: "mongo" (the default)
+// shares sessions between instances and survives restarts, "memory" is
+// handy for development and tests.
+func newSessionStore(db *mongo.Database) (sessionStore, error) {
+	switch store := envString("SESSION_STORE", "mongo"); store {
+	case "mongo":
+		return &mongoSessionStore{coll: db.Collection(sessionsCollName)}, nil
+	case "memory":
+		return newMemorySessionStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown SESSION_STORE %q", store)
+	}
+}
+
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]session{}}
+}
+
+func (m *memorySessionStore) get(ctx context.Context, id string) (session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return s, errSessionNotFound
+	}
+	if time.Now().After(s.ExpiresAt) {
+		delete(m.sessions, id)
+		return s, errSessionNotFound
+	}
+	return s, nil
+}
+
+func (m *memorySessionStore) save(ctx context.Context, s session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *memorySessionStore) delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessionStore) deleteUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// mongoSessionStore stores sessions under a hash of their id, so a leaked
+// database doesn't leak usable cookies. A TTL index removes expired ones.
+type mongoSessionStore struct {
+	coll *mongo.Collection
+}
+
+// ensureIndexes creates the TTL index that expires sessions.
+func (m *mongoSessionStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *mongoSessionStore) get(ctx context.Context, id string) (session, error) {
+	var s session
+	err := m.coll.FindOne(ctx, bson.M{
+		"_id":        hashSessionID(id),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return s, errSessionNotFound
+	}
+	s.ID = id
+	return s, err
+}
+
+func (m *mongoSessionStore) save(ctx context.Context, s session) error {
+	_, err := m.coll.ReplaceOne(ctx, bson.M{"_id": hashSessionID(s.ID)}, s, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoSessionStore) delete(ctx context.Context, id string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": hashSessionID(id)})
+	return err
+}
+
+func (m *mongoSessionStore) deleteUser(ctx context.Context, userID string) error {
+	_, err := m.coll.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// signIn starts a new session for userID and sets the session cookie.
+func signIn(ctx context.Context, w http.ResponseWriter, u userModel) error {
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s := session{
+		ID:          id,
+		UserID:      u.ID.Hex(),
+		WorkspaceID: u.WorkspaceID,
+		Preferences: u.Preferences,
+		CSRFToken:   csrf,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(sessionMaxAge),
+	}
+	if err := sessions.save(ctx, s); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  s.ExpiresAt,
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// signOut ends the request's session, if any, and clears the cookie.
+func signOut(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return sessions.delete(ctx, cookie.Value)
+	}
+	return nil
+}
+
+// requestSession returns the session the request's cookie refers to.
+func requestSession(r *http.Request) (session, bool) {
+	if s, ok := r.Context().Value(sessionContextKey).(session); ok {
+		return s, true
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return session{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	s, err := sessions.get(ctx, cookie.Value)
+	return s, err == nil
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// validCSRF checks the CSRF token of a cookie-authenticated request. Scripts
+// send it in the X-CSRF-Token header, plain HTML forms in a csrf_token field.
+func validCSRF(r *http.Request, s session) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+			token = r.PostFormValue(csrfFormField)
+		}
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+func csrfFailed(w http.ResponseWriter) {
+	rnd.JSON(w, http.StatusForbidden, errorResponse{
+		Message: "Invalid or missing CSRF token",
+	})
+}
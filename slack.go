@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// slackSigningSecret verifies that slash commands come from Slack. The
+// /integrations/slack endpoint is only served when it is set.
+var slackSigningSecret = envString("SLACK_SIGNING_SECRET", "")
+
+const (
+	// slackMaxSkew is how old a request may be, to stop replays.
+	slackMaxSkew = 5 * time.Minute
+
+	// slackListLimit is how many todos "list" shows.
+	slackListLimit = 20
+)
+
+// verifySlackSignature checks the request signature Slack computes from the
+// signing secret, the timestamp and the raw body.
+func verifySlackSignature(secret string, h http.Header, body []byte, now time.Time) bool {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(h.Get("X-Slack-Signature")))
+}
+
+// slackCaller is who a slash command acts for. Slack users get todos of
+// their own, kept apart from any account they have in the app.
+func slackCaller(r *http.Request, form url.Values) caller {
+	c := requestCaller(r)
+	c.userID = "slack:" + form.Get("team_id") + ":" + form.Get("user_id")
+	return c
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as markup.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func slackSection(text string) renderer.M {
+	return renderer.M{
+		"type": "section",
+		"text": renderer.M{"type": "mrkdwn", "text": text},
+	}
+}
+
+// slackTodoLine formats a todo as a line of mrkdwn.
+func slackTodoLine(t todoModel) string {
+	line := "*" + slackEscape(t.Title) + "*"
+	if t.DueDate != nil {
+		line += " · due " + t.DueDate.Format("Mon Jan 2 15:04")
+	}
+	if t.Priority != "" {
+		line += " · " + t.Priority + " priority"
+	}
+	for _, tag := range t.Tags {
+		line += " `#" + slackEscape(tag) + "`"
+	}
+	return line
+}
+
+// slackReply answers a slash command with a message only the user who ran
+// it sees.
+func slackReply(w http.ResponseWriter, blocks ...renderer.M) {
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"response_type": "ephemeral",
+		"blocks":        blocks,
+	})
+}
+
+// slackCommand handles a slash command such as
+//
+//	/todo add Pay rent tomorrow 5pm #finance !high
+//	/todo list
+//
+// where the text after "add" is read like POST /todo/quickadd reads it.
+func slackCommand(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !verifySlackSignature(slackSigningSecret, r.Header, body, time.Now()) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	c := slackCaller(r, form)
+	command, text, _ := strings.Cut(strings.TrimSpace(form.Get("text")), " ")
+
+	switch strings.ToLower(command) {
+	case "add":
+		tm, err := todoFromText(text)
+		if err != nil {
+			slackReply(w, slackSection(slackEscape(err.Error())+". Try `add Pay rent tomorrow 5pm #finance !high`."))
+			return
+		}
+		err = svc.create(ctx, c, tm)
+		var qe *quotaError
+		if errors.As(err, &qe) {
+			slackReply(w, slackSection(fmt.Sprintf("You have reached the limit of %d todos.", qe.limit)))
+			return
+		}
+		if err != nil {
+			log.Printf("Slack add failed: %v", err)
+			slackReply(w, slackSection("Sorry, the todo couldn't be added. Please try again."))
+			return
+		}
+		slackReply(w, slackSection(":white_check_mark: Added "+slackTodoLine(tm)))
+
+	case "", "list":
+		open := false
+		todos, err := svc.list(ctx, c, todoQuery{completed: &open})
+		if err != nil {
+			log.Printf("Slack list failed: %v", err)
+			slackReply(w, slackSection("Sorry, your todos couldn't be fetched. Please try again."))
+			return
+		}
+		if len(todos) == 0 {
+			slackReply(w, slackSection("You have no open todos."))
+			return
+		}
+		blocks := []renderer.M{slackSection(fmt.Sprintf("*You have %d open todos*", len(todos)))}
+		for i, t := range todos {
+			if i == slackListLimit {
+				blocks = append(blocks, renderer.M{
+					"type":     "context",
+					"elements": []renderer.M{{"type": "mrkdwn", "text": fmt.Sprintf("and %d more", len(todos)-i)}},
+				})
+				break
+			}
+			blocks = append(blocks, slackSection("• "+slackTodoLine(t)))
+		}
+		slackReply(w, blocks...)
+
+	default:
+		slackReply(w, slackSection("Try `add <todo>` to add a todo, e.g. `add Pay rent tomorrow 5pm #finance !high`, or `list` to see your open todos."))
+	}
+}
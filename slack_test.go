@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySlackSignature(t *testing.T) {
+	const secret = "8f742231b10e8888abcd99yyyzzz85a5"
+	body := []byte("token=x&team_id=T1&user_id=U1&command=%2Ftodo&text=list")
+	now := time.Unix(1760000000, 0)
+
+	sign := func(secret string, ts time.Time, body []byte) http.Header {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + strconv.FormatInt(ts.Unix(), 10) + ":"))
+		mac.Write(body)
+		h := http.Header{}
+		h.Set("X-Slack-Request-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+		h.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+		return h
+	}
+
+	if !verifySlackSignature(secret, sign(secret, now, body), body, now) {
+		t.Error("valid signature rejected")
+	}
+	if verifySlackSignature(secret, sign("other", now, body), body, now) {
+		t.Error("signature made with another secret accepted")
+	}
+	if verifySlackSignature(secret, sign(secret, now, body), []byte("text=add"), now) {
+		t.Error("signature of another body accepted")
+	}
+	old := now.Add(-slackMaxSkew - time.Second)
+	if verifySlackSignature(secret, sign(secret, old, body), body, now) {
+		t.Error("replayed request accepted")
+	}
+	if verifySlackSignature(secret, http.Header{}, body, now) {
+		t.Error("unsigned request accepted")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setStarred stars or unstars a todo. Like other edits it is recorded in
+// the todo's history, so it can be undone.
+func (s *todoService) setStarred(ctx context.Context, c caller, id primitive.ObjectID, starred bool) (todoModel, error) {
+	current, err := s.getForAccess(ctx, c, id, true)
+	if err != nil || current.Starred == starred {
+		return current, err
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"updated_at": now}}
+	if starred {
+		update["$set"].(bson.M)["starred"] = true
+	} else {
+		update["$unset"] = bson.M{"starred": ""}
+	}
+
+	var after todoModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.todos.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&after)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return current, errTodoNotFound
+	}
+	if err != nil {
+		return current, err
+	}
+	s.changed(ctx, eventTodoUpdated, after)
+	return after, s.recordHistory(ctx, id, actionUpdate, c.actor(), &current, diffTodos(&current, &after))
+}
+
+func starTodo(w http.ResponseWriter, r *http.Request) {
+	setTodoStarred(w, r, true)
+}
+
+func unstarTodo(w http.ResponseWriter, r *http.Request) {
+	setTodoStarred(w, r, false)
+}
+
+// setTodoStarred answers POST /todo/{id}/star and /unstar with the todo as
+// it now is.
+func setTodoStarred(w http.ResponseWriter, r *http.Request, starred bool) {
+	failed, done := "Failed to star todo", "Todo starred"
+	if !starred {
+		failed, done = "Failed to unstar todo", "Todo unstarred"
+	}
+
+	objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, errorResponse{
+			Message: "Invalid id",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	c := requestCaller(r)
+
+	tm, err := svc.setStarred(ctx, c, objID, starred)
+	if errors.Is(err, errTodoNotFound) {
+		rnd.JSON(w, http.StatusNotFound, errorResponse{
+			Message: "Todo not found",
+		})
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		rnd.JSON(w, http.StatusForbidden, errorResponse{
+			Message: failed,
+			Error:   "Viewers cannot change todos",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: failed,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, todoResponse{
+		Message: done,
+		Data:    newTodo(tm, c.location()),
+	})
+}
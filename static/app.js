@@ -0,0 +1,23 @@
+// Cookie-authenticated requests that change anything must carry the
+// session's CSRF token.
+document.body.addEventListener('htmx:configRequest', function (event) {
+  event.detail.headers['X-CSRF-Token'] = document.querySelector('meta[name="csrf-token"]').content;
+});
+
+var errorBox = document.getElementById('error');
+
+document.body.addEventListener('htmx:beforeRequest', function () {
+  errorBox.hidden = true;
+});
+
+// htmx doesn't swap error responses, so show their message instead.
+document.body.addEventListener('htmx:responseError', function (event) {
+  errorBox.textContent = event.detail.xhr.responseText || 'Something went wrong, please try again';
+  errorBox.hidden = false;
+});
+
+document.body.addEventListener('htmx:afterRequest', function (event) {
+  if (event.detail.successful && event.detail.elt.tagName === 'FORM') {
+    event.detail.elt.reset();
+  }
+});
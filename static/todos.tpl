@@ -0,0 +1,16 @@
+{{ define "todos" }}
+<ul class="list-group" id="todos">
+  {{ range . }}{{ template "todo" . }}{{ end }}
+</ul>
+{{ end }}
+
+{{ define "todo" }}
+<li class="list-group-item {{ if .Completed }}checked{{ else }}not-checked{{ end }}" hx-post="/ui/todos/{{ .ID }}/toggle" hx-swap="outerHTML">
+    <i class="{{ if .Completed }}fa fa-check-circle text-success{{ else }}fa fa-circle{{ end }}">&nbsp;</i>
+    <span class="{{ if .Completed }}del{{ end }}">{{ .Title }}</span>
+    <div class="btn-group float-right" role="group" aria-label="Todo actions">
+      <button type="button" class="btn btn-danger btn-sm custom-button" hx-delete="/ui/todos/{{ .ID }}" hx-target="closest li" hx-swap="outerHTML" hx-confirm="Are you sure ?" onclick="event.stopPropagation()"><span class="fa fa-trash"></span></button>
+    </div>
+    {{ with .DescriptionHTML }}<div class="description">{{ . }}</div>{{ end }}
+</li>
+{{ end }}
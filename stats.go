@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// statsDays is how many days of completions the stats cover, today
+// included.
+const statsDays = 30
+
+type (
+	todoStats struct {
+		Total             int64            `json:"total"`
+		Completed         int64            `json:"completed"`
+		Pending           int64            `json:"pending"`
+		ByTag             map[string]int64 `json:"by_tag"`
+		ByPriority        map[string]int64 `json:"by_priority"`
+		CompletionsPerDay []dayCount       `json:"completions_per_day"`
+		CompletedToday    int64            `json:"completed_today"`
+		// AvgTimeToComplete is the mean time, in seconds, from creating a
+		// todo to completing it, or zero without completed todos.
+		AvgTimeToComplete float64 `json:"avg_time_to_complete"`
+	}
+
+	dayCount struct {
+		Date  string `json:"date"`
+		Count int64  `json:"count"`
+	}
+
+	// bucket is a group of the aggregation's $facet stage.
+	bucket struct {
+		Key   interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+)
+
+// stats counts the todos c can see in one aggregation. Todos without a
+// priority are counted as "none". Days are UTC and every day of the period
+// is listed, with zero for days without completions, but "today" in
+// completed_today is today in c's time zone.
+func (s *todoService) stats(ctx context.Context, c caller) (todoStats, error) {
+	filter, err := s.visibleFilter(ctx, c)
+	if err != nil {
+		return todoStats{}, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(statsDays - 1))
+	count := bson.M{"$sum": 1}
+	now := time.Now().In(c.location())
+	localToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$facet": bson.M{
+			"completed": bson.A{
+				bson.M{"$group": bson.M{"_id": "$completed", "count": count}},
+			},
+			"tags": bson.A{
+				bson.M{"$unwind": "$tags"},
+				bson.M{"$group": bson.M{"_id": "$tags", "count": count}},
+			},
+			"priority": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$ifNull": bson.A{"$priority", "none"}}, "count": count}},
+			},
+			"days": bson.A{
+				bson.M{"$match": bson.M{"completed": true, "completed_at": bson.M{"$gte": since}}},
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$completed_at"}},
+					"count": count,
+				}},
+			},
+			"completion": bson.A{
+				bson.M{"$match": bson.M{"completed": true, "completed_at": bson.M{"$type": "date"}}},
+				bson.M{"$group": bson.M{
+					"_id":   nil,
+					"avgMs": bson.M{"$avg": bson.M{"$subtract": bson.A{"$completed_at", "$created_at"}}},
+					"today": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$completed_at", localToday}}, 1, 0}}},
+				}},
+			},
+		}},
+	}
+
+	cursor, err := s.todos.Aggregate(ctx, pipeline)
+	if err != nil {
+		return todoStats{}, err
+	}
+	var facets []struct {
+		Completed  []bucket `bson:"completed"`
+		Tags       []bucket `bson:"tags"`
+		Priority   []bucket `bson:"priority"`
+		Days       []bucket `bson:"days"`
+		Completion []struct {
+			AvgMs float64 `bson:"avgMs"`
+			Today int64   `bson:"today"`
+		} `bson:"completion"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return todoStats{}, err
+	}
+
+	stats := todoStats{ByTag: map[string]int64{}, ByPriority: map[string]int64{}}
+	if len(facets) == 0 {
+		return stats, nil
+	}
+	f := facets[0]
+
+	for _, b := range f.Completed {
+		stats.Total += b.Count
+		if done, _ := b.Key.(bool); done {
+			stats.Completed += b.Count
+		} else {
+			stats.Pending += b.Count
+		}
+	}
+	for _, b := range f.Tags {
+		if tag, ok := b.Key.(string); ok {
+			stats.ByTag[tag] = b.Count
+		}
+	}
+	for _, b := range f.Priority {
+		if p, ok := b.Key.(string); ok {
+			stats.ByPriority[p] = b.Count
+		}
+	}
+
+	if len(f.Completion) > 0 {
+		stats.AvgTimeToComplete = f.Completion[0].AvgMs / 1000
+		stats.CompletedToday = f.Completion[0].Today
+	}
+
+	perDay := map[string]int64{}
+	for _, b := range f.Days {
+		if day, ok := b.Key.(string); ok {
+			perDay[day] = b.Count
+		}
+	}
+	for d := since; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		stats.CompletionsPerDay = append(stats.CompletionsPerDay, dayCount{Date: day, Count: perDay[day]})
+	}
+	return stats, nil
+}
+
+func fetchTodoStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	stats, err := svc.stats(ctx, requestCaller(r))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch todo stats",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	respond(w, r, http.StatusOK, itemResponse[todoStats]{
+		Data: stats,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+// Priorities a todo can have. Todos without one have an empty priority.
+const (
+	priorityLow    = "low"
+	priorityMedium = "medium"
+	priorityHigh   = "high"
+)
+
+var errInvalidPriority = errors.New("Priority must be low, medium or high")
+
+// normalizeTags trims and lowercases tags and drops empty and duplicate
+// ones, keeping the original order. It returns nil rather than an empty
+// slice, so todos without tags compare equal in the history.
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("Tags must be at most %d characters", maxTagLength)
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("A todo can have at most %d tags", maxTags)
+	}
+	return out, nil
+}
+
+func validPriority(p string) bool {
+	switch p {
+	case "", priorityLow, priorityMedium, priorityHigh:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	telegramLinksCollName = "telegram_links"
+	telegramCodesCollName = "telegram_link_codes"
+
+	// telegramCodeTTL is how long a link code can be redeemed.
+	telegramCodeTTL = 10 * time.Minute
+
+	// telegramPollTimeout is how long a getUpdates call waits for messages.
+	telegramPollTimeout = 30 * time.Second
+
+	// telegramListLimit keeps /list within a message's length limit.
+	telegramListLimit = 50
+)
+
+// telegram is the bot, or nil unless TELEGRAM_BOT_TOKEN is set.
+var telegram *telegramBot
+
+var errTelegramNotLinked = errors.New("telegram account not linked")
+
+// telegramBot lets users add, complete and list their todos by chatting
+// with a Telegram bot. A signed-in user gets a code from
+// POST /me/telegram/link and sends it to the bot with /link, which ties
+// their Telegram account to their account here.
+//
+// The bot long-polls Telegram for messages, and Telegram hands each
+// message to one poller only, so it should run on a single instance.
+type telegramBot struct {
+	apiURL string
+	client *http.Client
+	links  *mongo.Collection
+	codes  *mongo.Collection
+}
+
+type (
+	// telegramLinkModel ties a Telegram user to a user here.
+	telegramLinkModel struct {
+		TelegramID  int64     `bson:"_id"`
+		UserID      string    `bson:"user_id"`
+		WorkspaceID string    `bson:"workspace_id,omitempty"`
+		LinkedAt    time.Time `bson:"linked_at"`
+	}
+
+	telegramCodeModel struct {
+		Code        string    `bson:"_id"`
+		UserID      string    `bson:"user_id"`
+		WorkspaceID string    `bson:"workspace_id,omitempty"`
+		ExpiresAt   time.Time `bson:"expires_at"`
+	}
+
+	// telegramLinkCode is the code a user sends the bot to link their
+	// Telegram account.
+	telegramLinkCode struct {
+		Code      string `json:"code"`
+		ExpiresAt string `json:"expires_at"`
+	}
+
+	telegramUpdate struct {
+		UpdateID int64            `json:"update_id"`
+		Message  *telegramMessage `json:"message"`
+	}
+
+	telegramMessage struct {
+		Text string `json:"text"`
+		From struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	}
+)
+
+// newTelegramBot returns the bot for TELEGRAM_BOT_TOKEN, or nil if it isn't
+// set.
+func newTelegramBot(db *mongo.Database) *telegramBot {
+	token := envString("TELEGRAM_BOT_TOKEN", "")
+	if token == "" {
+		return nil
+	}
+	return &telegramBot{
+		apiURL: envString("TELEGRAM_API_URL", "https://api.telegram.org") + "/bot" + token + "/",
+		client: &http.Client{Timeout: telegramPollTimeout + 10*time.Second, Transport: tracedTransport()},
+		links:  db.Collection(telegramLinksCollName),
+		codes:  db.Collection(telegramCodesCollName),
+	}
+}
+
+func (b *telegramBot) ensureIndexes(ctx context.Context) error {
+	_, err := b.codes.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// call invokes a Bot API method and decodes its result into result.
+func (b *telegramBot) call(ctx context.Context, method string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.apiURL+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The error quotes the URL, which holds the token.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("telegram %s: %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("telegram %s: %s", method, reply.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// run polls for messages and answers them until ctx is done.
+func (b *telegramBot) run(ctx context.Context) {
+	log.Println("Telegram bot started")
+
+	var offset int64
+	for ctx.Err() == nil {
+		var updates []telegramUpdate
+		err := b.call(ctx, "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Telegram poll failed, retrying in 5s: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || u.Message.Text == "" {
+				continue
+			}
+			msgCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+			reply := b.handle(msgCtx, *u.Message)
+			err := b.call(msgCtx, "sendMessage", map[string]interface{}{
+				"chat_id": u.Message.Chat.ID,
+				"text":    reply,
+			}, nil)
+			cancel()
+			if err != nil {
+				log.Printf("Telegram reply failed: %v", err)
+			}
+		}
+	}
+}
+
+const telegramHelp = `Commands:
+/add <todo> - add a todo, e.g. /add Pay rent tomorrow 5pm #finance !high
+/list - show your open todos
+/done <number> - complete a todo from /list
+/link <code> - link your account, with a code from POST /me/telegram/link
+/unlink - unlink your account`
+
+// handle runs the command in msg and returns the reply.
+func (b *telegramBot) handle(ctx context.Context, msg telegramMessage) string {
+	command, args, _ := strings.Cut(strings.TrimSpace(msg.Text), " ")
+	// In groups commands come as /add@SomeBot.
+	command, _, _ = strings.Cut(strings.ToLower(command), "@")
+	args = strings.TrimSpace(args)
+
+	switch command {
+	case "/add", "/done", "/link", "/unlink":
+		if maintenance.enabled() {
+			return "Todos can't be changed during maintenance. Please try again later."
+		}
+	}
+
+	switch command {
+	case "/link":
+		return b.redeem(ctx, msg.From.ID, args)
+	case "/unlink":
+		if _, err := b.links.DeleteOne(ctx, bson.M{"_id": msg.From.ID}); err != nil {
+			log.Printf("Telegram unlink failed: %v", err)
+			return "Sorry, something went wrong. Please try again."
+		}
+		return "Your Telegram account is no longer linked."
+	case "/add", "/list", "/done":
+	default:
+		return telegramHelp
+	}
+
+	c, err := b.caller(ctx, msg.From.ID)
+	if errors.Is(err, errTelegramNotLinked) {
+		return "Link your account first: sign in, get a code from POST /me/telegram/link and send /link <code>."
+	}
+	if err != nil {
+		log.Printf("Telegram link lookup failed: %v", err)
+		return "Sorry, something went wrong. Please try again."
+	}
+
+	switch command {
+	case "/add":
+		return b.add(ctx, c, args)
+	case "/list":
+		return b.list(ctx, c)
+	default:
+		return b.done(ctx, c, args)
+	}
+}
+
+func (b *telegramBot) caller(ctx context.Context, telegramID int64) (caller, error) {
+	var link telegramLinkModel
+	err := b.links.FindOne(ctx, bson.M{"_id": telegramID}).Decode(&link)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return caller{}, errTelegramNotLinked
+	}
+	if err != nil {
+		return caller{}, err
+	}
+	return caller{userID: link.UserID, workspace: link.WorkspaceID, addr: "telegram"}, nil
+}
+
+// forget unlinks c's Telegram accounts after sending each of them text, for
+// when c's account is erased. Linked accounts are the ones that messaged
+// the bot, so their user ID is also their chat with it.
+func (b *telegramBot) forget(ctx context.Context, c caller, text string) error {
+	filter := c.scoped(bson.M{"user_id": c.userID})
+	cursor, err := b.links.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var links []telegramLinkModel
+	if err := cursor.All(ctx, &links); err != nil {
+		return err
+	}
+	for _, link := range links {
+		err := b.call(ctx, "sendMessage", map[string]interface{}{
+			"chat_id": link.TelegramID,
+			"text":    text,
+		}, nil)
+		if err != nil {
+			log.Printf("Telegram notification failed: %v", err)
+		}
+	}
+
+	if _, err := b.links.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	_, err = b.codes.DeleteMany(ctx, filter)
+	return err
+}
+
+func (b *telegramBot) redeem(ctx context.Context, telegramID int64, code string) string {
+	var c telegramCodeModel
+	err := b.codes.FindOneAndDelete(ctx, bson.M{
+		"_id":        strings.ToUpper(code),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "That code is unknown or has expired. Get a new one from POST /me/telegram/link."
+	}
+	if err == nil {
+		_, err = b.links.ReplaceOne(ctx, bson.M{"_id": telegramID}, telegramLinkModel{
+			TelegramID:  telegramID,
+			UserID:      c.UserID,
+			WorkspaceID: c.WorkspaceID,
+			LinkedAt:    time.Now(),
+		}, options.Replace().SetUpsert(true))
+	}
+	if err != nil {
+		log.Printf("Telegram link failed: %v", err)
+		return "Sorry, something went wrong. Please try again."
+	}
+	return "Your Telegram account is linked. Send /add <todo> to add a todo."
+}
+
+func (b *telegramBot) add(ctx context.Context, c caller, text string) string {
+	tm, err := todoFromText(text)
+	if err != nil {
+		return err.Error() + ". Try /add Pay rent tomorrow 5pm #finance !high"
+	}
+	err = svc.create(ctx, c, tm)
+	var qe *quotaError
+	if errors.As(err, &qe) {
+		return fmt.Sprintf("You have reached the limit of %d todos.", qe.limit)
+	}
+	if err != nil {
+		log.Printf("Telegram add failed: %v", err)
+		return "Sorry, the todo couldn't be added. Please try again."
+	}
+	return "Added " + telegramTodoLine(tm)
+}
+
+// openTodos lists c's open todos in the order /list numbers them.
+func (b *telegramBot) openTodos(ctx context.Context, c caller) ([]todoModel, error) {
+	open := false
+	return svc.list(ctx, c, todoQuery{completed: &open})
+}
+
+func (b *telegramBot) list(ctx context.Context, c caller) string {
+	todos, err := b.openTodos(ctx, c)
+	if err != nil {
+		log.Printf("Telegram list failed: %v", err)
+		return "Sorry, your todos couldn't be fetched. Please try again."
+	}
+	if len(todos) == 0 {
+		return "You have no open todos."
+	}
+	lines := []string{fmt.Sprintf("You have %d open todos:", len(todos))}
+	for i, t := range todos {
+		if i == telegramListLimit {
+			lines = append(lines, fmt.Sprintf("and %d more", len(todos)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, telegramTodoLine(t)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *telegramBot) done(ctx context.Context, c caller, arg string) string {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return "Which todo? Send /done with its number from /list, e.g. /done 2"
+	}
+	todos, err := b.openTodos(ctx, c)
+	if err != nil {
+		log.Printf("Telegram done failed: %v", err)
+		return "Sorry, something went wrong. Please try again."
+	}
+	if n > len(todos) {
+		return fmt.Sprintf("You have %d open todos; send /list to see them.", len(todos))
+	}
+
+	tm := todos[n-1]
+	tm.Completed = true
+	err = svc.update(ctx, c, tm.ID, newTodo(tm, time.UTC))
+	if errors.Is(err, errForbidden) {
+		return "You can only view that todo, not change it."
+	}
+	if err != nil {
+		log.Printf("Telegram done failed: %v", err)
+		return "Sorry, the todo couldn't be completed. Please try again."
+	}
+	return "Completed " + tm.Title
+}
+
+// telegramTodoLine formats a todo as a line of plain text.
+func telegramTodoLine(t todoModel) string {
+	line := t.Title
+	if t.DueDate != nil {
+		line += " · due " + t.DueDate.Format("Mon Jan 2 15:04")
+	}
+	if t.Priority != "" {
+		line += " · " + t.Priority + " priority"
+	}
+	for _, tag := range t.Tags {
+		line += " #" + tag
+	}
+	return line
+}
+
+// createTelegramLinkCode gives the signed-in user a code to send to the bot
+// with /link.
+func createTelegramLinkCode(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 5)
+	rand.Read(buf)
+	rc := requestCaller(r)
+	c := telegramCodeModel{
+		Code:        base32.StdEncoding.EncodeToString(buf),
+		UserID:      rc.userID,
+		WorkspaceID: rc.workspace,
+		ExpiresAt:   time.Now().Add(telegramCodeTTL),
+	}
+
+	ctx := r.Context()
+
+	if _, err := telegram.codes.InsertOne(ctx, c); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to create link code",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, itemResponse[telegramLinkCode]{
+		Message: "Send /link " + c.Code + " to the Telegram bot",
+		Data: telegramLinkCode{
+			Code:      c.Code,
+			ExpiresAt: c.ExpiresAt.Format(time.RFC3339),
+		},
+	})
+}
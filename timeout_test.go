@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unreachableService points the handlers at a MongoDB that never answers.
+// Without cancellation every query would wait out the long server selection
+// timeout.
+func unreachableService(t *testing.T) {
+	t.Helper()
+
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	old := svc
+	svc = newTodoService(client.Database("todo_test"), nil, nil, newEventBus(nil))
+	store = svc
+	t.Cleanup(func() { svc, store = old, old })
+}
+
+func TestRequestTimeoutCancelsStoreCalls(t *testing.T) {
+	unreachableService(t)
+
+	old := requestTimeout
+	requestTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = old })
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todo/", nil))
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request took %s, the timeout did not reach the store", elapsed)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestClientDisconnectCancelsStoreCalls(t *testing.T) {
+	unreachableService(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/todo/", nil).WithContext(ctx)
+	newRouter().ServeHTTP(rec, req)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request took %s, cancellation did not reach the store", elapsed)
+	}
+}
+
+func TestSlowUploadGetsTransferTimeout(t *testing.T) {
+	oldRequest, oldTransfer := requestTimeout, transferTimeout
+	requestTimeout, transferTimeout = 200*time.Millisecond, 5*time.Second
+	t.Cleanup(func() { requestTimeout, transferTimeout = oldRequest, oldTransfer })
+
+	r := chi.NewRouter()
+	readBody := func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(b)
+	}
+	r.With(timeout(requestTimeout)).Post("/todo/", readBody)
+	r.With(timeout(transferTimeout)).Post("/upload", readBody)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newServer(r)
+	ts.Start()
+	defer ts.Close()
+
+	// slowPost sends a body that takes 600ms to arrive, three times the
+	// request timeout.
+	slowPost := func(path string) (*http.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			for i := 0; i < 3; i++ {
+				time.Sleep(200 * time.Millisecond)
+				pw.Write([]byte("chunk "))
+			}
+			pw.Close()
+		}()
+		return http.Post(ts.URL+path, "application/octet-stream", pr)
+	}
+
+	resp, err := slowPost("/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(b) != "chunk chunk chunk " {
+		t.Errorf("upload: status %d, body %q", resp.StatusCode, b)
+	}
+
+	resp, err = slowPost("/todo/")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("a slow body was read past the request timeout")
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	tombstonesCollName = "tombstones"
+
+	// tombstoneTTL is how long deletions are remembered, and so how long a
+	// client can go without syncing before it has to start over.
+	tombstoneTTL = 30 * 24 * time.Hour
+
+	// changesSkew is subtracted from the time a changes query starts to
+	// get the next cursor. A write stamps updated_at before it commits, so
+	// a write in flight during the query can show up with an earlier
+	// timestamp; overlapping the windows a little makes sure it is picked
+	// up next time. Clients may see such changes twice.
+	changesSkew = 5 * time.Second
+)
+
+var errChangesExpired = errors.New("changes are no longer available")
+
+type (
+	// tombstoneModel records that a todo was deleted. It keeps the fields
+	// the visibility checks use, so a deletion is only reported to those
+	// who could see the todo.
+	tombstoneModel struct {
+		ID          primitive.ObjectID  `bson:"_id"`
+		ListID      *primitive.ObjectID `bson:"list_id,omitempty"`
+		OwnerID     string              `bson:"owner_id,omitempty"`
+		WorkspaceID string              `bson:"workspace_id,omitempty"`
+		DeletedAt   time.Time           `bson:"deleted_at"`
+	}
+
+	tombstone struct {
+		ID        string `json:"id"`
+		DeletedAt string `json:"deleted_at"`
+	}
+
+	changeSet struct {
+		Updated []todo      `json:"updated"`
+		Deleted []tombstone `json:"deleted"`
+		// Next is the since to pass on the following request.
+		Next string `json:"next"`
+	}
+)
+
+func (s *todoService) ensureTombstoneIndexes(ctx context.Context) error {
+	_, err := s.tombstones.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(tombstoneTTL.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.todos.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "updated_at", Value: 1}},
+	})
+	return err
+}
+
+// bury records the deletion of t.
+func (s *todoService) bury(ctx context.Context, t todoModel) error {
+	_, err := s.tombstones.ReplaceOne(ctx, bson.M{"_id": t.ID}, tombstoneModel{
+		ID:          t.ID,
+		ListID:      t.ListID,
+		OwnerID:     t.OwnerID,
+		WorkspaceID: t.WorkspaceID,
+		DeletedAt:   time.Now(),
+	}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// unbury forgets the deletion of a todo that was restored.
+func (s *todoService) unbury(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.tombstones.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// changes returns the todos c can see that were created, changed or deleted
+// since the given time. A zero since returns every todo and no deletions.
+func (s *todoService) changes(ctx context.Context, c caller, since time.Time) (changeSet, error) {
+	start := time.Now()
+	if !since.IsZero() && start.Sub(since) > tombstoneTTL {
+		return changeSet{}, errChangesExpired
+	}
+
+	visible, err := s.visibleFilter(ctx, c)
+	if err != nil {
+		return changeSet{}, err
+	}
+
+	set := changeSet{Updated: []todo{}, Deleted: []tombstone{}}
+
+	cursor, err := s.todos.Find(ctx,
+		bson.M{"$and": bson.A{visible, bson.M{"updated_at": bson.M{"$gte": since}}}},
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}),
+	)
+	if err != nil {
+		return set, err
+	}
+	var todos []todoModel
+	if err := cursor.All(ctx, &todos); err != nil {
+		return set, err
+	}
+	for _, t := range todos {
+		set.Updated = append(set.Updated, newTodo(t, c.location()))
+	}
+
+	set.Next = start.Add(-changesSkew).UTC().Format(time.RFC3339Nano)
+	if since.IsZero() {
+		return set, nil
+	}
+
+	cursor, err = s.tombstones.Find(ctx,
+		bson.M{"$and": bson.A{visible, bson.M{"deleted_at": bson.M{"$gte": since}}}},
+		options.Find().SetSort(bson.D{{Key: "deleted_at", Value: 1}}),
+	)
+	if err != nil {
+		return set, err
+	}
+	var tombstones []tombstoneModel
+	if err := cursor.All(ctx, &tombstones); err != nil {
+		return set, err
+	}
+	for _, t := range tombstones {
+		set.Deleted = append(set.Deleted, tombstone{ID: t.ID.Hex(), DeletedAt: t.DeletedAt.Format(time.RFC3339Nano)})
+	}
+	return set, nil
+}
+
+// fetchChanges serves GET /todo/changes?since=... for clients that keep a
+// copy of the todos. Without since it returns all of them.
+func fetchChanges(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := strings.TrimSpace(r.URL.Query().Get("since")); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, errorResponse{
+				Message: "Invalid query",
+				Error:   "since must be an RFC 3339 timestamp",
+			})
+			return
+		}
+		since = t
+	}
+
+	ctx := r.Context()
+
+	set, err := svc.changes(ctx, requestCaller(r), since)
+	if errors.Is(err, errChangesExpired) {
+		rnd.JSON(w, http.StatusGone, errorResponse{
+			Message: "Changes that old are no longer kept, fetch all todos again",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, errorResponse{
+			Message: "Failed to fetch changes",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	respond(w, r, http.StatusOK, itemResponse[changeSet]{
+		Data: set,
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerProvider records spans for requests, the database commands they run
+// and the calls they make to other services, and exports them over OTLP. It
+// is nil, and tracing off, unless OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. The exporters read the rest of
+// the OTEL_EXPORTER_OTLP_* settings themselves.
+var tracerProvider = newTracerProvider()
+
+func newTracerProvider() *sdktrace.TracerProvider {
+	if envString("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "") == "" && envString("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" {
+		return nil
+	}
+	exporter, err := newTraceExporter()
+	if err != nil {
+		invalidSetting("OTEL_EXPORTER_OTLP_ENDPOINT", err)
+		return nil
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(newTraceSampler()),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", envString("OTEL_SERVICE_NAME", "todo-go")),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp
+}
+
+// newTraceExporter sends spans over OTLP/HTTP, or gRPC if
+// OTEL_EXPORTER_OTLP_PROTOCOL asks for it. Neither connects until the first
+// batch is sent.
+func newTraceExporter() (sdktrace.SpanExporter, error) {
+	protocol := envString("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", envString("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"))
+	switch protocol {
+	case "grpc":
+		return otlptracegrpc.New(context.Background())
+	case "http/protobuf":
+		return otlptracehttp.New(context.Background())
+	}
+	return nil, fmt.Errorf("unsupported protocol %q", protocol)
+}
+
+// newTraceSampler reads OTEL_TRACES_SAMPLER and, for the ratio samplers,
+// OTEL_TRACES_SAMPLER_ARG. The default, parentbased_always_on, records
+// every trace that the caller didn't choose to drop.
+func newTraceSampler() sdktrace.Sampler {
+	name := envString("OTEL_TRACES_SAMPLER", "parentbased_always_on")
+	ratio := 1.0
+	if arg := envString("OTEL_TRACES_SAMPLER_ARG", ""); arg != "" && strings.HasSuffix(name, "traceidratio") {
+		r, err := strconv.ParseFloat(arg, 64)
+		if err != nil || r < 0 || r > 1 {
+			invalidSetting(fmt.Sprintf("OTEL_TRACES_SAMPLER_ARG=%q", arg), errors.New("must be a ratio from 0 to 1"))
+		} else {
+			ratio = r
+		}
+	}
+
+	switch name {
+	case "always_on":
+		return sdktrace.AlwaysSample()
+	case "always_off":
+		return sdktrace.NeverSample()
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio)
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))
+	}
+	invalidSetting(fmt.Sprintf("OTEL_TRACES_SAMPLER=%q", name), errors.New("unknown sampler"))
+	return sdktrace.ParentBased(sdktrace.AlwaysSample())
+}
+
+// shutdownTracing exports the spans still queued.
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Printf("Failed to export the last spans: %v", err)
+	}
+}
+
+// tracedTransport records a client span for every request made through
+// http.DefaultTransport as part of a traced request, and passes the trace on
+// in a traceparent header. Requests made outside of one, such as Telegram's
+// long polls, go out as they are. It returns nil, which http.Client takes
+// for the default, with tracing off.
+func tracedTransport() http.RoundTripper {
+	if tracerProvider == nil {
+		return nil
+	}
+	return otelhttp.NewTransport(http.DefaultTransport,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanContextFromContext(r.Context()).IsValid()
+		}),
+	)
+}
+
+// traceRequests records a server span for every request, continuing the
+// trace from the caller's traceparent header if it sent one. The span is
+// renamed after the matched route once the request is served, so all
+// requests for one endpoint group together.
+func traceRequests(next http.Handler) http.Handler {
+	if tracerProvider == nil {
+		return next
+	}
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			// A subrouter's root comes out as e.g. "/todo//".
+			route := strings.ReplaceAll(rctx.RoutePattern(), "//", "/")
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+	})
+	return otelhttp.NewHandler(named, "",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+	)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewTraceSampler(t *testing.T) {
+	t.Setenv("OTEL_TRACES_SAMPLER", "parentbased_traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+	want := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0.25)).Description()
+	if got := newTraceSampler().Description(); got != want {
+		t.Errorf("sampler = %s, want %s", got, want)
+	}
+
+	old := configErrors
+	t.Cleanup(func() { configErrors = old })
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "2")
+	if got := newTraceSampler().Description(); !strings.Contains(got, "AlwaysOnSampler") || len(configErrors) != len(old)+1 {
+		t.Errorf("ratio 2 gave %s, errors %v", got, configErrors)
+	}
+	t.Setenv("OTEL_TRACES_SAMPLER", "sometimes")
+	want = sdktrace.ParentBased(sdktrace.AlwaysSample()).Description()
+	if got := newTraceSampler().Description(); got != want || len(configErrors) != len(old)+2 {
+		t.Errorf("unknown sampler gave %s, errors %v", got, configErrors)
+	}
+}
+
+func TestTracedTransport(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+	}))
+	defer ts.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	oldProvider, oldTP, oldPropagator := tracerProvider, otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		tracerProvider = oldProvider
+		otel.SetTracerProvider(oldTP)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+	tracerProvider = tp
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	client := &http.Client{Transport: tracedTransport()}
+	get := func(ctx context.Context) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// Calls made outside of a request aren't traced.
+	get(context.Background())
+	if got != "" || len(spans.Ended()) != 0 {
+		t.Errorf("untraced call sent %q, recorded %d spans", got, len(spans.Ended()))
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "GET /todo/")
+	get(ctx)
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("recorded %d spans", len(ended))
+	}
+	s := ended[0]
+	if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("client span parent = %s, want %s", s.Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if want := "00-" + s.SpanContext().TraceID().String() + "-" + s.SpanContext().SpanID().String() + "-01"; got != want {
+		t.Errorf("traceparent = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usersCollName = "users"
+
+type (
+	userModel struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		Email       string             `bson:"email,omitempty"`
+		Name        string             `bson:"name"`
+		WorkspaceID string             `bson:"workspace_id,omitempty"`
+		Preferences preferences        `bson:"preferences,omitempty"`
+		Identities  []userIdentity     `bson:"identities"`
+		CreatedAt   time.Time          `bson:"created_at"`
+		LastLoginAt time.Time          `bson:"last_login_at"`
+	}
+
+	// userIdentity links a user to an account at an OAuth provider.
+	userIdentity struct {
+		Provider string `bson:"provider"`
+		Subject  string `bson:"subject"`
+	}
+)
+
+// workspaceDomains maps email domains to the workspace new users with a
+// verified address at that domain join, from WORKSPACE_DOMAINS, e.g.
+// "acme.com=acme,globex.com=globex".
+var workspaceDomains = parseWorkspaceDomains("WORKSPACE_DOMAINS")
+
+func parseWorkspaceDomains(key string) map[string]string {
+	domains := map[string]string{}
+	for _, entry := range envList(key, nil) {
+		domain, workspace, ok := strings.Cut(entry, "=")
+		domain, workspace = strings.ToLower(strings.TrimSpace(domain)), strings.TrimSpace(workspace)
+		if !ok || domain == "" || workspace == "" {
+			invalidSetting(fmt.Sprintf("%s entry %q", key, entry), errors.New("want domain=workspace"))
+			continue
+		}
+		domains[domain] = workspace
+	}
+	return domains
+}
+
+// signupWorkspace picks the workspace of a new user: the one their email
+// domain maps to or, failing that, a workspace of their own named after
+// their id. email is only set if it is verified.
+func signupWorkspace(id primitive.ObjectID, email string) string {
+	if _, domain, ok := strings.Cut(email, "@"); ok {
+		if workspace, ok := workspaceDomains[domain]; ok {
+			return workspace
+		}
+	}
+	return id.Hex()
+}
+
+// loginUser finds the user for an OAuth identity, creating one on first
+// login. A new identity is linked to an existing account with the same
+// verified email address, so signing in with Google and GitHub ends up on
+// one account. New users get their workspace from signupWorkspace; the
+// login request can't pick one, since it isn't signed in yet.
+func (s *todoService) loginUser(ctx context.Context, provider string, p oauthProfile) (userModel, error) {
+	now := time.Now()
+	identity := userIdentity{Provider: provider, Subject: p.Subject}
+	email := strings.ToLower(strings.TrimSpace(p.Email))
+
+	var u userModel
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.users.FindOneAndUpdate(ctx,
+		bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": p.Subject}}},
+		bson.M{"$set": bson.M{"last_login_at": now}},
+		opts,
+	).Decode(&u)
+	if err == nil || !errors.Is(err, mongo.ErrNoDocuments) {
+		return u, err
+	}
+
+	if email != "" && p.EmailVerified {
+		err = s.users.FindOneAndUpdate(ctx,
+			bson.M{"email": email},
+			bson.M{
+				"$push": bson.M{"identities": identity},
+				"$set":  bson.M{"last_login_at": now},
+			},
+			opts,
+		).Decode(&u)
+		if err == nil || !errors.Is(err, mongo.ErrNoDocuments) {
+			return u, err
+		}
+	} else {
+		// Unverified addresses can't be used to link accounts, and the
+		// unique index must not see them either.
+		email = ""
+	}
+
+	id := primitive.NewObjectID()
+	u = userModel{
+		ID:          id,
+		Email:       email,
+		Name:        p.Name,
+		WorkspaceID: signupWorkspace(id, email),
+		Identities:  []userIdentity{identity},
+		CreatedAt:   now,
+		LastLoginAt: now,
+	}
+	_, err = s.users.InsertOne(ctx, u)
+	return u, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// When they aren't, commit and buildTime fall back to what the Go toolchain
+// recorded from git.
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+func init() {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && commit == "":
+			commit = s.Value
+		case s.Key == "vcs.time" && buildTime == "":
+			buildTime = s.Value
+		}
+	}
+}
+
+// versionInfo describes the running build.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	Go        string `json:"go"`
+}
+
+func currentVersion() versionInfo {
+	return versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		Go:        runtime.Version(),
+	}
+}
+
+func fetchVersion(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, currentVersion())
+}
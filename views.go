@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// todoView is a todo as the server-rendered UI shows it.
+type todoView struct {
+	ID              string
+	Title           string
+	DescriptionHTML template.HTML
+	Completed       bool
+}
+
+func newTodoView(t todoModel) todoView {
+	v := todoView{ID: t.ID.Hex(), Title: t.Title, Completed: t.Completed}
+	if t.Description != "" {
+		// renderMarkdown escapes its input, so the result is safe to embed.
+		v.DescriptionHTML = template.HTML(renderMarkdown(t.Description))
+	}
+	return v
+}
+
+// renderView writes the named page or partial template.
+func renderView(w http.ResponseWriter, status int, name string, data interface{}) {
+	tpl, err := viewTemplates()
+	if err != nil {
+		log.Printf("Template err: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	if err := tpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Template err: %v", err)
+	}
+}
+
+// viewError answers a failed UI request with a short plain text message,
+// which the page shows above the list.
+func viewError(w http.ResponseWriter, err error) {
+	var qe *quotaError
+	switch {
+	case errors.As(err, &qe):
+		http.Error(w, fmt.Sprintf("You have reached the limit of %d todos", qe.limit), http.StatusForbidden)
+	case errors.Is(err, errTodoNotFound):
+		http.Error(w, "Todo not found", http.StatusNotFound)
+	case errors.Is(err, errForbidden):
+		http.Error(w, "You cannot change this todo", http.StatusForbidden)
+	default:
+		log.Printf("UI request failed: %v", err)
+		http.Error(w, "Something went wrong, please try again", http.StatusInternalServerError)
+	}
+}
+
+func visibleTodoViews(ctx context.Context, c caller) ([]todoView, error) {
+	todos, err := svc.list(ctx, c, todoQuery{})
+	if err != nil {
+		return nil, err
+	}
+	views := make([]todoView, 0, len(todos))
+	for _, t := range todos {
+		views = append(views, newTodoView(t))
+	}
+	return views, nil
+}
+
+// homeHandler renders the todo page. Adding, toggling and deleting todos
+// goes through the /ui/todos endpoints, which answer with HTML fragments
+// that htmx swaps into the page.
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	todos, err := visibleTodoViews(ctx, requestCaller(r))
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+
+	s, _ := requestSession(r)
+	renderView(w, http.StatusOK, "index.tpl", renderer.M{
+		"CSRFToken": s.CSRFToken,
+		"Todos":     todos,
+	})
+}
+
+func viewTodos(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	todos, err := visibleTodoViews(ctx, requestCaller(r))
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+	renderView(w, http.StatusOK, "todos", todos)
+}
+
+func viewCreateTodo(w http.ResponseWriter, r *http.Request) {
+	t := todo{Title: r.FormValue("title"), Description: r.FormValue("description")}
+	if err := sanitizeTodoText(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if t.Title == "" {
+		http.Error(w, "Title is required", http.StatusUnprocessableEntity)
+		return
+	}
+
+	tm := todoModel{
+		ID:          primitive.NewObjectID(),
+		Title:       t.Title,
+		Description: t.Description,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	ctx := r.Context()
+
+	c := requestCaller(r)
+	if err := svc.create(ctx, c, tm); err != nil {
+		viewError(w, err)
+		return
+	}
+	tm.OwnerID = c.userID
+	renderView(w, http.StatusCreated, "todo", newTodoView(tm))
+}
+
+func viewToggleTodo(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	c := requestCaller(r)
+	tm, err := svc.getForAccess(ctx, c, objID, true)
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+	tm.Completed = !tm.Completed
+	err = svc.update(ctx, c, objID, newTodo(tm, time.UTC))
+	if err != nil {
+		viewError(w, err)
+		return
+	}
+	renderView(w, http.StatusOK, "todo", newTodoView(tm))
+}
+
+func viewDeleteTodo(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "id")))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := svc.delete(ctx, requestCaller(r), objID); err != nil {
+		viewError(w, err)
+		return
+	}
+	// An empty 200 makes htmx swap the item out of the list.
+	w.WriteHeader(http.StatusOK)
+}